@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/logging"
+	"golang.org/x/exp/slog"
+)
+
+// Exit statuses of every command.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// A command is a wupdedup subcommand.
+type command struct {
+	name string
+	// args is the synopsis of the command's positional arguments.
+	args    string
+	summary string
+	// help describes the command at length, after its synopsis.
+	help string
+	// flags registers the command's flags, bound to the configuration they
+	// override, so that they default to the values loaded from WDD_*
+	// environment variables.
+	flags func(fs *flag.FlagSet, c *config.Config)
+	run   func(c *config.Config, args []string) error
+}
+
+// A usageError reports a command used wrongly.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// commands lists every command, in the order of the phases they run.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		scanCommand,
+		dupesCommand,
+		reportCommand,
+		planCommand,
+		applyCommand,
+		exportCommand,
+		dbCommand,
+		serveCommand,
+		configCommand,
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, "usage: wupdedup <command> [flags] [args]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprint(w, `
+Run 'wupdedup <command> --help' for a command's flags. Flags override the
+WDD_* environment variables, read from .env too, which override the config
+file given by -config or $WDD_CONFIG, which overrides the defaults.
+
+exit status: 0 on success, 1 on failure, 2 on bad usage.
+`)
+}
+
+func (cmd *command) printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: wupdedup %s [flags] %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+	if cmd.help != "" {
+		fmt.Fprintf(w, "\n%s", cmd.help)
+	}
+	fmt.Fprint(w, "\nflags:\n")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// commonFlags registers the flags shared by every command.
+func commonFlags(fs *flag.FlagSet, c *config.Config) {
+	fs.StringVar(&c.DBFile, "db", c.DBFile, "database `file`, or "+`":memory:"`+" for an ephemeral one")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log `level`: debug, info, warn or error")
+}
+
+// providerFlag registers the -provider flag of commands that can be limited
+// to some of the configured sources.
+func providerFlag(fs *flag.FlagSet, providers *[]string) {
+	fs.Var((*listFlag)(providers), "provider", "comma-separated `names` of the sources to use (default all configured)")
+}
+
+// listFlag is a comma-separated list flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// runCLI runs the command named by args[0] with the rest of args, and
+// returns the process exit status.
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	c, err := config.LoadConfig(configFile(args[1:]))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fs := flag.NewFlagSet("wupdedup "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {}
+	fs.String("config", "", "YAML or TOML config `file` (default $WDD_CONFIG)")
+	commonFlags(fs, &c)
+	if cmd.flags != nil {
+		cmd.flags(fs, &c)
+	}
+	cmdArgs, err := parseFlags(fs, args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			cmd.printUsage(os.Stdout, fs)
+			return exitOK
+		}
+		fmt.Fprintln(os.Stderr)
+		cmd.printUsage(os.Stderr, fs)
+		return exitUsage
+	}
+
+	// logs go to stderr, leaving stdout to the data commands write
+	logging.InitTo(c.LogLevel, os.Stderr)
+	err = cmd.run(&c, cmdArgs)
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		fmt.Fprintf(os.Stderr, "%s\n\n", ue)
+		cmd.printUsage(os.Stderr, fs)
+		return exitUsage
+	case err != nil:
+		slog.Error(cmd.name+" failed", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// parseFlags parses the flags in args, be they before or after positional
+// arguments (eg: "config show -config x.yaml"), as configFile finds -config
+// anywhere, and returns the positional arguments. Arguments after "--" are
+// all positional.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" || len(rest) == 0 {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// configFile returns the value of the -config flag in args, which is needed
+// before parsing them, since the other flags default to the config loaded.
+func configFile(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if ok {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// noArgs is the run func of commands taking no positional arguments.
+func noArgs(run func(c *config.Config) error) func(c *config.Config, args []string) error {
+	return func(c *config.Config, args []string) error {
+		if len(args) > 0 {
+			return usageError(fmt.Sprintf("unexpected arguments: %s", strings.Join(args, " ")))
+		}
+		return run(c)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runOutput runs the CLI with args from dir, away from any .env file, and
+// returns its exit status and what it printed to stdout.
+func runOutput(t *testing.T, dir string, args ...string) (int, string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	status := runCLI(args)
+	os.Stdout = stdout
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, string(b)
+}
+
+func TestCLIConfigFlag(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WDD_CONFIG", "")
+	path := filepath.Join(dir, "x.yaml")
+	if err := os.WriteFile(path, []byte("log_level: warn\ndedup:\n  near_distance: 5\nlocal:\n  root_path: "+dir+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"config", "-config", path, "show"},
+		{"config", "show", "-config", path},
+		{"config", "show", "--config=" + path, "yaml"},
+		{"config", "show", "-log-level", "warn", "-config", path},
+	} {
+		status, out := runOutput(t, dir, args...)
+		if status != exitOK || !strings.Contains(out, "log_level: warn") || !strings.Contains(out, "near_distance: 5") {
+			t.Errorf("%s exited with %d, printing:\n%s", strings.Join(args, " "), status, out)
+		}
+	}
+	// Flags given after the subcommand still override the config file's.
+	status, out := runOutput(t, dir, "config", "show", "-config", path, "-log-level", "error")
+	if status != exitOK || !strings.Contains(out, "log_level: error") {
+		t.Errorf("-log-level after the subcommand exited with %d, printing:\n%s", status, out)
+	}
+	// After "--", flags are positional arguments.
+	if status, _ := runOutput(t, dir, "config", "show", "--", "-config", path); status != exitUsage {
+		t.Errorf("arguments after -- exited with %d, want %d", status, exitUsage)
+	}
+}
+
+func TestCLIConfigShowRedacts(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WDD_CONFIG", "")
+	path := filepath.Join(dir, "x.yaml")
+	config := `smugmug:
+  url: https://example.smugmug.com/
+  api_key: the-api-key
+  api_secret: the-api-secret
+  user_token: the-user-token
+  user_secret: the-user-secret
+`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	status, out := runOutput(t, dir, "config", "show", "-config", path)
+	if status != exitOK || !strings.Contains(out, "url: https://example.smugmug.com/") {
+		t.Fatalf("config show exited with %d, printing:\n%s", status, out)
+	}
+	for _, secret := range []string{"the-api-key", "the-api-secret", "the-user-token", "the-user-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("config show printed %s:\n%s", secret, out)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
 	"time"
 
@@ -12,72 +14,144 @@ import (
 )
 
 type LocalConfig struct {
-	RootPath string `required:"true" split_words:"true" default:""`
+	RootPath string `required:"false" split_words:"true" default:"" yaml:"root_path" toml:"root_path" desc:"directory tree scanned"`
+
+	// Number of concurrent hashing workers. Zero means one per CPU.
+	HashWorkers int `required:"false" split_words:"true" default:"0" yaml:"hash_workers" toml:"hash_workers" desc:"concurrent hashing workers, 0 for one per CPU"`
+
+	// Digest algorithms computed for each regular file (sha256, blake3).
+	HashAlgorithms []string `required:"false" split_words:"true" default:"sha256" yaml:"hash_algorithms" toml:"hash_algorithms" desc:"digest algorithms computed for each file: sha256, blake3, md5"`
+
+	// Whether to compute perceptual hashes of images for near-duplicate search.
+	ImageHashes bool `required:"false" split_words:"true" default:"true" yaml:"image_hashes" toml:"image_hashes" desc:"compute perceptual hashes of images"`
+
+	// Whether to extract embedded metadata (EXIF, QuickTime atoms) from media files.
+	ExtractMetadata bool `required:"false" split_words:"true" default:"true" yaml:"extract_metadata" toml:"extract_metadata" desc:"extract EXIF and video metadata"`
 }
 
 func (c *LocalConfig) Specified() bool {
-	return *c != (LocalConfig{})
+	return c.RootPath != ""
 }
-func (c *LocalConfig) Valid() bool {
-	f, err := os.Open(c.RootPath)
-	if err != nil {
-		log.Fatalf("Can't open LocalConfig.RootPath: %s: %s", c.RootPath, err)
+func (c *LocalConfig) Valid() error {
+	if c.RootPath == "" {
+		return errors.New("missing root path")
 	}
-	var finfo os.FileInfo
-	finfo, err = f.Stat()
+	finfo, err := os.Stat(c.RootPath)
 	if err != nil {
-		log.Fatalf("Invalid LocalConfig.RootPath %s: %s", c.RootPath, err)
+		return fmt.Errorf("invalid root path: %s", err)
+	}
+	if !finfo.IsDir() {
+		return fmt.Errorf("root path %s isn't a directory", c.RootPath)
 	}
-	return finfo.IsDir()
+	return nil
 }
 
 type SmugMugConfig struct {
-	URL                string `required:"true" split_words:"true" default:""`
-	APIKey             string `required:"true" split_words:"true" default:""`
-	APISecret          string `required:"true" split_words:"true" default:""`
-	UserToken          string `required:"true" split_words:"true" default:""`
-	UserSecret         string `required:"true" split_words:"true" default:""`
-	Destination        string `required:"true" split_words:"true" default:""`
-	FileNames          string `required:"true" split_words:"true" default:""`
-	UseMetadataTimes   bool   `required:"true" split_words:"true" default:false`
-	ForceMetadataTimes bool   `required:"true" split_words:"true" default:false`
+	URL                string `required:"false" split_words:"true" default:"" yaml:"url" toml:"url" desc:"URL of the SmugMug site"`
+	APIKey             string `required:"false" split_words:"true" default:"" yaml:"api_key" toml:"api_key" desc:"API key" secret:"true"`
+	APISecret          string `required:"false" split_words:"true" default:"" yaml:"api_secret" toml:"api_secret" desc:"API secret" secret:"true"`
+	UserToken          string `required:"false" split_words:"true" default:"" yaml:"user_token" toml:"user_token" desc:"OAuth token of the user" secret:"true"`
+	UserSecret         string `required:"false" split_words:"true" default:"" yaml:"user_secret" toml:"user_secret" desc:"OAuth secret of the user" secret:"true"`
+	Destination        string `required:"false" split_words:"true" default:"" yaml:"destination" toml:"destination" desc:"directory the account is backed up to"`
+	FileNames          string `required:"false" split_words:"true" default:"" yaml:"file_names" toml:"file_names" desc:"template of the names of backed up files"`
+	UseMetadataTimes   bool   `required:"false" split_words:"true" default:"false" yaml:"use_metadata_times" toml:"use_metadata_times" desc:"time backed up files from their metadata"`
+	ForceMetadataTimes bool   `required:"false" split_words:"true" default:"false" yaml:"force_metadata_times" toml:"force_metadata_times" desc:"time backed up files from their metadata, even if already set"`
 }
 
 func (c *SmugMugConfig) Specified() bool {
 	return *c != (SmugMugConfig{})
 }
 
-func (c *SmugMugConfig) Valid() bool {
-	// TODO: fix validation
-	return true
+func (c *SmugMugConfig) Valid() error {
+	if c.URL == "" {
+		return errors.New("missing URL")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %s", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("URL %s isn't the address of a site", c.URL)
+	}
+	return nil
+}
+
+type DedupConfig struct {
+	// Digest algorithm used to confirm duplicates in the full-hash stage.
+	Algorithm string `required:"false" split_words:"true" default:"sha256" yaml:"algorithm" toml:"algorithm"`
+
+	// KiB read from each end of a same-size candidate in the partial-hash stage,
+	// up to 1 GiB. Zero skips the stage, as do candidates no larger than twice
+	// this, which are hashed fully.
+	PartialHashKB int `required:"false" split_words:"true" default:"64" yaml:"partial_hash_kb" toml:"partial_hash_kb"`
+
+	// Perceptual hash (ahash, dhash, phash) compared by near-duplicate search.
+	NearHash string `required:"false" split_words:"true" default:"phash" yaml:"near_hash" toml:"near_hash"`
+
+	// Max Hamming distance between two images' hashes to call them near-duplicates.
+	NearDistance int `required:"false" split_words:"true" default:"8" yaml:"near_distance" toml:"near_distance"`
+}
+
+type BatchConfig struct {
+	// Number of records buffered by the scan before committing them in one transaction.
+	Size int `required:"false" split_words:"true" default:"1000" yaml:"size" toml:"size"`
+
+	// Longest a scanned record waits to be committed. Zero waits for a full batch.
+	Interval time.Duration `required:"false" split_words:"true" default:"1s" yaml:"interval" toml:"interval"`
+
+	// Whether to let bolt's Batch coalesce concurrent writes instead of buffering them.
+	UseBoltBatch bool `required:"false" split_words:"true" default:"false" yaml:"use_bolt_batch" toml:"use_bolt_batch"`
 }
 
 type Config struct {
-	DBFile   string        `required:"false" split_words:"true" default:"wupdedup.bolt.db"`
-	LogLevel string        `required:"false" split_words:"true" default:"info"`
-	Timeout  time.Duration `required:"false" split_words:"true" default:"1m"`
+	DBFile   string        `required:"false" split_words:"true" default:"wupdedup.bolt.db" yaml:"db_file" toml:"db_file"`
+	LogLevel string        `required:"false" split_words:"true" default:"info" yaml:"log_level" toml:"log_level"`
+	Timeout  time.Duration `required:"false" split_words:"true" default:"1m" yaml:"timeout" toml:"timeout"`
 	// HomeDir  string `required:"false" split_words:"true" default:""`
-	Profile profiler.ProfileConfig
-	Local   LocalConfig
-	Smugmug SmugMugConfig
+	Profile profiler.ProfileConfig `yaml:"profile" toml:"profile"`
+	Batch   BatchConfig            `yaml:"batch" toml:"batch"`
+	Dedup   DedupConfig            `yaml:"dedup" toml:"dedup"`
+	Local   LocalConfig            `yaml:"local" toml:"local"`
+	Smugmug SmugMugConfig          `yaml:"smugmug" toml:"smugmug"`
+	// Sources lists named sources besides Local and Smugmug, which are the
+	// sources named after their type. WDD_SOURCES lists them as comma-separated
+	// name:type pairs, replacing those of the config file.
+	Sources []SourceConfig `required:"false" yaml:"sources,omitempty" toml:"sources,omitempty"`
 }
 
-// The struct returned is a singleton.
-func LoadConfig() Config {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading configuration from .env file: %s", err.Error())
-	}
+// Prefix of the environment variables holding the configuration.
+const envPrefix = "WDD"
 
+// LoadConfig loads the configuration, from lowest to highest precedence, from
+// the field defaults, the config file at `path` (or $WDD_CONFIG, if `path` is
+// empty), and the environment, including the optional .env file.
+func LoadConfig(path string) (Config, error) {
 	var conf Config
-	err = envconfig.Process("WDD", &conf)
-	if err != nil {
-		log.Fatalf("Error loading Global Config from environment: %s", err.Error())
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return conf, fmt.Errorf("loading .env file: %s", err)
 	}
-	jsonconf, err := json.MarshalIndent(conf, "", "  ")
-	if err != nil {
-		log.Fatalf("Error Marshalling conf struct: %s", err.Error())
+	if path == "" {
+		path = os.Getenv(envPrefix + "_CONFIG")
+	}
+	var file Config
+	var settings map[string]any
+	if path != "" {
+		if settings, err = loadFile(path, &file); err != nil {
+			return conf, err
+		}
+	}
+	// WDD_SOURCES replaces the sources of the config file, if set.
+	conf.Sources = file.Sources
+	if err := envconfig.Process(envPrefix, &conf); err != nil {
+		return conf, fmt.Errorf("loading config from environment: %s", err)
+	}
+	if err := mergeSettings(envPrefix, &conf, &file, settings); err != nil {
+		return conf, fmt.Errorf("config file %s: %s", path, err)
+	}
+	fromFile := file.Sources
+	if err := checkSources(conf.Sources, fromFile); err != nil {
+		return conf, err
 	}
-	log.Printf("Loaded Config:\n%s\n", jsonconf)
-	return conf
+	return conf, nil
 }
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of config files, chosen by their extension.
+var fileFormats = map[string]string{
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".toml": "toml",
+}
+
+// loadFile decodes the YAML or TOML config file at `path` into `file`, and
+// returns its settings, for mergeSettings to merge them into the config loaded
+// from the environment. Its keys are those of the environment variables,
+// lowercased, with the sections of nested fields as tables, eg:
+// `local: {root_path: /photos}` sets WDD_LOCAL_ROOT_PATH. Its sources list is
+// decoded into file.Sources.
+func loadFile(path string, file *Config) (map[string]any, error) {
+	format, ok := fileFormats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil, fmt.Errorf("config file %s: unknown format, expected .yaml, .yml or .toml", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %s", err)
+	}
+	settings := make(map[string]any)
+	switch format {
+	case "yaml":
+		if err = yaml.Unmarshal(data, &settings); err == nil {
+			err = yaml.Unmarshal(data, file)
+		}
+	case "toml":
+		if err = toml.Unmarshal(data, &settings); err == nil {
+			err = toml.Unmarshal(data, file)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %s", path, err)
+	}
+
+	file.Sources = nil
+	if sources, ok := settings["sources"]; ok {
+		delete(settings, "sources")
+		if file.Sources, err = decodeSources(sources); err != nil {
+			return nil, fmt.Errorf("config file %s: %s", path, err)
+		}
+	}
+	return settings, nil
+}
+
+// decodeSettings decodes settings, as read from a config file, into v, a
+// pointer to a config struct.
+func decodeSettings(settings map[string]any, v any) error {
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, v)
+}
+
+// mergeSettings copies the fields of `file`, a struct holding the decoded
+// settings, into those of `spec`, a struct of the same type loaded by
+// envconfig, where their environment variables, named after the settings'
+// dotted path and prefixed by `prefix`, aren't set.
+func mergeSettings(prefix string, spec, file any, settings map[string]any) error {
+	var keys bytes.Buffer
+	if err := envconfig.Usagef(prefix, spec, &keys, "{{range .}}{{usage_key .}}\n{{end}}"); err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, k := range strings.Fields(keys.String()) {
+		known[k] = true
+	}
+	var paths []string
+	if err := flatten(settings, nil, &paths); err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	dst, src := reflect.ValueOf(spec).Elem(), reflect.ValueOf(file).Elem()
+	for _, setting := range paths {
+		key := prefix + "_" + strings.ToUpper(strings.ReplaceAll(setting, ".", "_"))
+		path := strings.Split(setting, ".")
+		to, from := settingField(dst, path), settingField(src, path)
+		if !known[key] || !to.IsValid() {
+			return fmt.Errorf("unknown setting %s", setting)
+		}
+		if _, ok := os.LookupEnv(key); !ok {
+			to.Set(from)
+		}
+	}
+	return nil
+}
+
+// settingField returns the field of struct v at the dotted path of a setting,
+// following their yaml tags, or the zero Value if there's none.
+func settingField(v reflect.Value, path []string) reflect.Value {
+	for _, name := range path {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		next := reflect.Value{}
+		for i := 0; i < v.NumField(); i++ {
+			if tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ","); tag == name {
+				next = v.Field(i)
+				break
+			}
+		}
+		if v = next; !v.IsValid() {
+			return v
+		}
+	}
+	return v
+}
+
+// decodeSources decodes the sources list of a config file.
+func decodeSources(v any) ([]SourceConfig, error) {
+	var items []any
+	switch v := v.(type) {
+	case []any:
+		items = v
+	case []map[string]any:
+		// TOML arrays of tables
+		for _, item := range v {
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("sources: expected a list")
+	}
+	sources := make([]SourceConfig, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("sources: expected a list of tables")
+		}
+		s := &sources[i]
+		for k, v := range fields {
+			switch k {
+			case "name":
+				s.Name = fmt.Sprint(v)
+			case "type":
+				s.Type = fmt.Sprint(v)
+			case "settings":
+				s.Settings = v
+			default:
+				return nil, fmt.Errorf("sources: unknown key %s, settings go in the settings table", k)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// flatten appends the dotted paths of the settings holding values to
+// `paths`. Lists must hold plain values, as envconfig parses them.
+func flatten(settings map[string]any, path []string, paths *[]string) error {
+	for k, v := range settings {
+		p := append(path[:len(path):len(path)], k)
+		switch v := v.(type) {
+		case nil:
+		case map[string]any:
+			if err := flatten(v, p, paths); err != nil {
+				return err
+			}
+		case []any:
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("setting %s: expected a list of values", strings.Join(p, "."))
+				}
+			}
+			*paths = append(*paths, strings.Join(p, "."))
+		default:
+			*paths = append(*paths, strings.Join(p, "."))
+		}
+	}
+	return nil
+}
+
+// What secret values are replaced with by Redacted.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config with the values of the fields tagged
+// secret:"true" replaced, to be shown or logged.
+func (c Config) Redacted() Config {
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.Struct:
+			redact(f)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct:
+			// copied, since the copy of the config shares the original's items
+			items := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			reflect.Copy(items, f)
+			for j := 0; j < items.Len(); j++ {
+				redact(items.Index(j))
+			}
+			f.Set(items)
+		case f.Kind() == reflect.Interface && !f.IsNil() &&
+			f.Elem().Kind() == reflect.Pointer && f.Elem().Elem().Kind() == reflect.Struct:
+			// settings resolved by SourceConfig.Load
+			p := reflect.New(f.Elem().Elem().Type())
+			p.Elem().Set(f.Elem().Elem())
+			redact(p.Elem())
+			f.Set(p)
+		case f.Kind() == reflect.String && v.Type().Field(i).Tag.Get("secret") == "true" && f.String() != "":
+			f.SetString(redacted)
+		}
+	}
+}
+
+// Encode writes the config to w in `format`, yaml or toml, as read from
+// config files.
+func (c Config) Encode(w io.Writer, format string) error {
+	switch format {
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "toml":
+		return toml.NewEncoder(w).Encode(c)
+	default:
+		return fmt.Errorf("unknown config format %q", format)
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A Setting documents a setting of a config struct.
+type Setting struct {
+	// Name is the setting's key in config files, dotted in nested tables.
+	Name        string
+	Type        string
+	Default     string
+	Description string
+	Secret      bool
+}
+
+// Settings documents the settings of spec, a pointer to a config struct, from
+// the tags of its fields.
+func Settings(spec any) []Setting {
+	return settings(reflect.TypeOf(spec).Elem(), "")
+}
+
+func settings(t reflect.Type, prefix string) []Setting {
+	var list []Setting
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" || f.Tag.Get("ignored") == "true" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if f.Type.Kind() == reflect.Struct {
+			list = append(list, settings(f.Type, prefix+name+".")...)
+			continue
+		}
+		list = append(list, Setting{
+			Name:        prefix + name,
+			Type:        settingType(f.Type),
+			Default:     f.Tag.Get("default"),
+			Description: f.Tag.Get("desc"),
+			Secret:      f.Tag.Get("secret") == "true",
+		})
+	}
+	return list
+}
+
+func settingType(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case t.Kind() == reflect.Slice:
+		return "list of " + settingType(t.Elem())
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// A SourceConfig configures a source: a named instance of a storage provider
+// type, eg: one of several local disks, scanned into its own bucket.
+type SourceConfig struct {
+	Name string `yaml:"name" toml:"name"`
+	// Type is the provider type, eg: local or smugmug.
+	Type string `yaml:"type" toml:"type"`
+	// Settings holds the settings of the source's type, as read from the config
+	// file, until Load resolves them into the type's config struct.
+	Settings any `yaml:"settings,omitempty" toml:"settings,omitempty"`
+}
+
+// Names of sources, which also name their environment variables and buckets.
+var sourceNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// Decode implements envconfig.Decoder, parsing the "name:type" items of
+// WDD_SOURCES.
+func (s *SourceConfig) Decode(value string) error {
+	name, typ, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("source %q: expected name:type", value)
+	}
+	*s = SourceConfig{Name: strings.TrimSpace(name), Type: strings.TrimSpace(typ)}
+	return nil
+}
+
+// EnvPrefix returns the prefix of the environment variables holding the
+// source's settings, eg: WDD_SOURCE_DISK_1 for source disk-1.
+func (s *SourceConfig) EnvPrefix() string {
+	return envPrefix + "_SOURCE_" + strings.ToUpper(strings.ReplaceAll(s.Name, "-", "_"))
+}
+
+// Load resolves the source's settings into v, a pointer to the config struct
+// of its type, from lowest to highest precedence: the struct's defaults, the
+// settings of the config file, and the environment variables prefixed by
+// EnvPrefix. v then replaces the settings, to be shown or loaded again.
+func (s *SourceConfig) Load(v any) error {
+	if reflect.TypeOf(s.Settings) == reflect.TypeOf(v) {
+		reflect.ValueOf(v).Elem().Set(reflect.ValueOf(s.Settings).Elem())
+		return nil
+	}
+	prefix := s.EnvPrefix()
+	settings, ok := s.Settings.(map[string]any)
+	if !ok && s.Settings != nil {
+		return fmt.Errorf("source %s: settings must be a table", s.Name)
+	}
+	if err := envconfig.Process(prefix, v); err != nil {
+		return fmt.Errorf("source %s: %s", s.Name, err)
+	}
+	if settings != nil {
+		file := reflect.New(reflect.TypeOf(v).Elem()).Interface()
+		if err := decodeSettings(settings, file); err != nil {
+			return fmt.Errorf("source %s: %s", s.Name, err)
+		}
+		if err := mergeSettings(prefix, v, file, settings); err != nil {
+			return fmt.Errorf("source %s: %s", s.Name, err)
+		}
+	}
+	s.Settings = v
+	return nil
+}
+
+// AllSources returns the configured sources, starting with Local and Smugmug,
+// when they're specified, as the sources named after their type.
+func (c *Config) AllSources() []*SourceConfig {
+	var sources []*SourceConfig
+	if c.Local.Specified() {
+		sources = append(sources, &SourceConfig{Name: "local", Type: "local", Settings: &c.Local})
+	}
+	if c.Smugmug.Specified() {
+		sources = append(sources, &SourceConfig{Name: "smugmug", Type: "smugmug", Settings: &c.Smugmug})
+	}
+	for i := range c.Sources {
+		sources = append(sources, &c.Sources[i])
+	}
+	return sources
+}
+
+// checkSources checks the names of the sources, and keeps the settings the
+// config file gives sources listed again by WDD_SOURCES.
+func checkSources(sources, fromFile []SourceConfig) error {
+	seen := make(map[string]string)
+	for i := range sources {
+		s := &sources[i]
+		if !sourceNameRE.MatchString(s.Name) {
+			return fmt.Errorf("source name %q: expected letters, digits, _ and -", s.Name)
+		}
+		if s.Type == "" {
+			return fmt.Errorf("source %s: missing type", s.Name)
+		}
+		if other, ok := seen[s.EnvPrefix()]; ok && other == s.Name {
+			return fmt.Errorf("source %s configured twice", s.Name)
+		} else if ok {
+			return fmt.Errorf("sources %s and %s share environment variables", other, s.Name)
+		}
+		seen[s.EnvPrefix()] = s.Name
+		for _, f := range fromFile {
+			if s.Settings == nil && f.Name == s.Name && f.Type == s.Type {
+				s.Settings = f.Settings
+			}
+		}
+	}
+	return nil
+}
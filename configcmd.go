@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/digest"
+	"github.com/timblaktu/wupdedup/imagehash"
+	"golang.org/x/exp/slog"
+)
+
+var configCommand = &command{
+	name:    "config",
+	args:    "<subcommand> [args]",
+	summary: "check or show the configuration, or list the types of sources",
+	help: `subcommands:
+  check               report every problem with the configuration, failing if
+                      there's any
+  show [yaml|toml]    print the effective configuration, secrets redacted, as a
+                      config file (default yaml)
+  types               list the types of sources, and their settings
+`,
+	run: func(c *config.Config, args []string) error {
+		switch {
+		case len(args) == 1 && args[0] == "check":
+			return checkConfigCommand(c)
+		case len(args) == 1 && args[0] == "show":
+			return showConfig(c, "yaml")
+		case len(args) == 2 && args[0] == "show" && (args[1] == "yaml" || args[1] == "toml"):
+			return showConfig(c, args[1])
+		case len(args) == 1 && args[0] == "types":
+			return writeStorageStrategyTypes(os.Stdout)
+		default:
+			return usageError(fmt.Sprintf("bad subcommand: %s", strings.Join(args, " ")))
+		}
+	},
+}
+
+// showConfig prints the configuration, with the settings of its sources
+// resolved, and its secrets redacted.
+func showConfig(c *config.Config, format string) error {
+	if _, err := loadStorageStrategyContexts(c); err != nil {
+		return err
+	}
+	return c.Redacted().Encode(os.Stdout, format)
+}
+
+// writeStorageStrategyTypes lists the registered types of sources, with the
+// settings of each.
+func writeStorageStrategyTypes(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, t := range sortedStorageStrategyTypes() {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s: %s\n", t.Name, t.Summary)
+		fmt.Fprintln(tw, "  setting\ttype\tdefault\tdescription")
+		for _, s := range t.Settings() {
+			desc := s.Description
+			if s.Secret {
+				desc += " (secret)"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", s.Name, s.Type, s.Default, desc)
+		}
+	}
+	return tw.Flush()
+}
+
+func checkConfigCommand(c *config.Config) error {
+	problems := checkConfig(c)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d configuration problems", len(problems))
+	}
+	fmt.Println("configuration ok")
+	return nil
+}
+
+// checkConfig returns every problem found with the configuration.
+func checkConfig(c *config.Config) (problems []string) {
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		add("log level: %s", err)
+	}
+	if c.DBFile != db.MemoryFile {
+		if fi, err := os.Stat(filepath.Dir(c.DBFile)); err != nil || !fi.IsDir() {
+			add("db file: directory of %s doesn't exist", c.DBFile)
+		}
+	}
+	if c.Batch.Size <= 0 {
+		add("batch size: must be positive, got %d", c.Batch.Size)
+	}
+	if c.Batch.Interval < 0 {
+		add("batch interval: must not be negative, got %s", c.Batch.Interval)
+	}
+
+	if algos, err := digest.ParseAlgorithms([]string{c.Dedup.Algorithm}); err != nil {
+		add("dedup algorithm: %s", err)
+	} else if len(algos) != 1 {
+		add("dedup algorithm: exactly one required, got %q", c.Dedup.Algorithm)
+	}
+	if c.Dedup.PartialHashKB < 0 || c.Dedup.PartialHashKB > maxPartialHashKB {
+		add("dedup partial hash: must be within 0-%d KiB, got %d", maxPartialHashKB, c.Dedup.PartialHashKB)
+	}
+	if _, err := (imagehash.Hashes{}).Get(imagehash.Kind(c.Dedup.NearHash)); err != nil {
+		add("dedup near hash: %s", err)
+	}
+	if c.Dedup.NearDistance < 0 || c.Dedup.NearDistance > 64 {
+		add("dedup near distance: must be within 0-64, got %d", c.Dedup.NearDistance)
+	}
+
+	var failed bool
+	for _, s := range c.AllSources() {
+		if _, err := newStorageStrategy(s); err != nil {
+			add("%s", err)
+			failed = true
+		}
+	}
+	if _, err := loadStorageStrategyContexts(c); err != nil && !failed {
+		add("sources: %s", err)
+	}
+	return problems
+}
@@ -0,0 +1,127 @@
+package content
+
+import (
+	"fmt"
+	"strings"
+)
+
+//go:generate stringer -type=Category -linecomment
+type Category int
+
+const (
+	// Unknown is the zero value, for content not yet classified.
+	Unknown  Category = iota // unknown
+	Image                    // image
+	RawImage                 // raw-image
+	Video                    // video
+	Audio                    // audio
+	Document                 // document
+	Archive                  // archive
+	Code                     // code
+	Other                    // other
+)
+
+// ParseCategory converts a category name (eg: "raw-image") to a Category.
+func ParseCategory(s string) (Category, error) {
+	for c := Unknown; c <= Other; c++ {
+		if strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+	return Unknown, fmt.Errorf("unknown content category %q", s)
+}
+
+func (c Category) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Category) UnmarshalText(text []byte) error {
+	var err error
+	*c, err = ParseCategory(string(text))
+	return err
+}
+
+// Camera raw formats, which net/http sniffs as TIFF or not at all.
+var rawImageTypes = map[string]bool{
+	"image/x-canon-cr2":     true,
+	"image/x-canon-cr3":     true,
+	"image/x-nikon-nef":     true,
+	"image/x-sony-arw":      true,
+	"image/x-adobe-dng":     true,
+	"image/x-olympus-orf":   true,
+	"image/x-panasonic-rw2": true,
+	"image/x-fuji-raf":      true,
+}
+
+var documentTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/rtf":               true,
+	"application/msword":            true,
+	"application/epub+zip":          true,
+	"application/postscript":        true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"text/plain":                    true,
+	"text/csv":                      true,
+	"text/markdown":                 true,
+}
+
+var archiveTypes = map[string]bool{
+	"application/zip":                         true,
+	"application/x-gzip":                      true,
+	"application/x-bzip2":                     true,
+	"application/x-xz":                        true,
+	"application/x-tar":                       true,
+	"application/x-rar-compressed":            true,
+	"application/x-7z-compressed":             true,
+	"application/zstd":                        true,
+	"application/java-archive":                true,
+	"application/vnd.android.package-archive": true,
+	"application/x-iso9660-image":             true,
+}
+
+var codeTypes = map[string]bool{
+	"text/html":              true,
+	"text/xml":               true,
+	"text/css":               true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/wasm":       true,
+	"application/x-sh":       true,
+}
+
+// CategoryOf maps a MIME type, as returned by GetType or DetectType, to its
+// Category. Parameters such as "; charset=utf-8" are ignored.
+func CategoryOf(mimeType string) Category {
+	t := mediaType(mimeType)
+	switch {
+	case t == "":
+		return Unknown
+	case rawImageTypes[t]:
+		return RawImage
+	case strings.HasPrefix(t, "image/"):
+		return Image
+	case strings.HasPrefix(t, "video/"):
+		return Video
+	case strings.HasPrefix(t, "audio/"), t == "application/ogg":
+		return Audio
+	case documentTypes[t],
+		strings.HasPrefix(t, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(t, "application/vnd.oasis.opendocument."):
+		return Document
+	case archiveTypes[t]:
+		return Archive
+	case codeTypes[t], strings.HasPrefix(t, "text/x-"):
+		return Code
+	default:
+		return Other
+	}
+}
+
+// mediaType strips any parameters from a MIME type.
+func mediaType(mimeType string) string {
+	t, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
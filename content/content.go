@@ -1,16 +1,69 @@
 package content
 
 import (
+	"bytes"
+	"mime"
 	"net/http"
+	"path/filepath"
+	"strings"
 )
 
+// EmptyType is the MIME type of empty files, classified as Other.
+const EmptyType = "application/x-empty"
+
+// GetType returns the MIME type of content starting with hdr. It recognizes
+// the formats net/http knows plus common media formats it doesn't (HEIC,
+// camera raws, Matroska, QuickTime, 3GP).
 func GetType(hdr []byte) string {
-	return http.DetectContentType(hdr)
+	return DetectType(hdr, "")
+}
+
+// DetectType returns the MIME type of the file `name` whose content starts
+// with hdr, falling back on name's extension when the content alone only
+// yields a generic type (eg: raw formats that are plain TIFF containers,
+// office documents that are zip files, or source code that is plain text).
+func DetectType(hdr []byte, name string) string {
+	if len(hdr) == 0 {
+		// Empty files hold nothing of the type their name implies.
+		return EmptyType
+	}
+	t := sniff(hdr)
+	if t == "" {
+		t = http.DetectContentType(hdr)
+	}
+	if !generic(t) {
+		return t
+	}
+	if et, ok := extensionTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		return et
+	}
+	return t
+}
+
+// NameType returns the MIME type of file `name` told by its extension alone,
+// for files whose content isn't read (eg: listed by a remote provider), or
+// application/octet-stream if the extension is unknown.
+func NameType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Classify returns the MIME type and Category of a file, as DetectType and
+// CategoryOf would.
+func Classify(hdr []byte, name string) (string, Category) {
+	t := DetectType(hdr, name)
+	return t, CategoryOf(t)
 }
 
 func IsVideo(hdr []byte) bool {
-	switch ft := GetType(hdr); ft {
-	case "video":
+	switch ft := CategoryOf(GetType(hdr)); ft {
+	case Video:
 		return true
 	default:
 		return false
@@ -18,10 +71,172 @@ func IsVideo(hdr []byte) bool {
 }
 
 func IsImage(hdr []byte) bool {
-	switch ft := GetType(hdr); ft {
-	case "image":
+	switch ft := CategoryOf(GetType(hdr)); ft {
+	case Image, RawImage:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsImageType reports whether a MIME type, as returned by GetType, is an image.
+func IsImageType(t string) bool {
+	switch CategoryOf(t) {
+	case Image, RawImage:
 		return true
 	default:
 		return false
 	}
 }
+
+// generic reports whether a sniffed type is too vague to trust over the
+// file's extension.
+func generic(t string) bool {
+	switch mediaType(t) {
+	case "application/octet-stream", "text/plain", "application/zip", "image/tiff":
+		return true
+	default:
+		return false
+	}
+}
+
+// ISO base media file format major brands and the types they denote.
+var ftypBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"hevc": "image/heic-sequence",
+	"hevx": "image/heic-sequence",
+	"mif1": "image/heif",
+	"msf1": "image/heif-sequence",
+	"avif": "image/avif",
+	"crx ": "image/x-canon-cr3",
+	"qt  ": "video/quicktime",
+	"M4V ": "video/x-m4v",
+	"M4A ": "audio/mp4",
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+	"dash": "video/mp4",
+}
+
+// sniff recognizes formats that http.DetectContentType doesn't, returning ""
+// for everything else.
+func sniff(hdr []byte) string {
+	switch {
+	case len(hdr) >= 12 && string(hdr[4:8]) == "ftyp":
+		brand := string(hdr[8:12])
+		if t, ok := ftypBrands[brand]; ok {
+			return t
+		}
+		switch {
+		case strings.HasPrefix(brand, "3gp"):
+			return "video/3gpp"
+		case strings.HasPrefix(brand, "3g2"):
+			return "video/3gpp2"
+		}
+	case len(hdr) >= 8 && quickTimeAtom(string(hdr[4:8])):
+		// QuickTime files predating the ftyp atom start with a top level atom.
+		return "video/quicktime"
+	case bytes.HasPrefix(hdr, []byte("\x1a\x45\xdf\xa3")):
+		if bytes.Contains(hdr, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(hdr, []byte("II*\x00")) && len(hdr) >= 10 && string(hdr[8:10]) == "CR":
+		return "image/x-canon-cr2"
+	case bytes.HasPrefix(hdr, []byte("II*\x00")), bytes.HasPrefix(hdr, []byte("MM\x00*")):
+		return "image/tiff"
+	case bytes.HasPrefix(hdr, []byte("IIRO")), bytes.HasPrefix(hdr, []byte("IIRS")):
+		return "image/x-olympus-orf"
+	case bytes.HasPrefix(hdr, []byte("IIU\x00")):
+		return "image/x-panasonic-rw2"
+	case bytes.HasPrefix(hdr, []byte("FUJIFILMCCD-RAW")):
+		return "image/x-fuji-raf"
+	}
+	return ""
+}
+
+func quickTimeAtom(name string) bool {
+	switch name {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	default:
+		return false
+	}
+}
+
+// Types implied by file extensions, consulted only when sniffing the content
+// gives a generic type.
+var extensionTypes = map[string]string{
+	// camera raws stored in plain TIFF containers
+	".nef": "image/x-nikon-nef",
+	".nrw": "image/x-nikon-nef",
+	".arw": "image/x-sony-arw",
+	".srf": "image/x-sony-arw",
+	".sr2": "image/x-sony-arw",
+	".dng": "image/x-adobe-dng",
+	".cr2": "image/x-canon-cr2",
+	".cr3": "image/x-canon-cr3",
+	".orf": "image/x-olympus-orf",
+	".rw2": "image/x-panasonic-rw2",
+	".raf": "image/x-fuji-raf",
+	// media
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".3gp":  "video/3gpp",
+	".3g2":  "video/3gpp2",
+	".m4v":  "video/x-m4v",
+	".m4a":  "audio/mp4",
+	".flac": "audio/flac",
+	// documents, many of which are zip containers
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".rtf":  "application/rtf",
+	".doc":  "application/msword",
+	".xls":  "application/vnd.ms-excel",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".epub": "application/epub+zip",
+	// archives
+	".jar": "application/java-archive",
+	".apk": "application/vnd.android.package-archive",
+	".tar": "application/x-tar",
+	".7z":  "application/x-7z-compressed",
+	".xz":  "application/x-xz",
+	".bz2": "application/x-bzip2",
+	".zst": "application/zstd",
+	".iso": "application/x-iso9660-image",
+	// source code
+	".go":   "text/x-go",
+	".py":   "text/x-python",
+	".js":   "text/javascript",
+	".ts":   "text/x-typescript",
+	".c":    "text/x-c",
+	".h":    "text/x-c",
+	".cc":   "text/x-c++",
+	".cpp":  "text/x-c++",
+	".hpp":  "text/x-c++",
+	".rs":   "text/x-rust",
+	".java": "text/x-java",
+	".rb":   "text/x-ruby",
+	".sh":   "application/x-sh",
+	".json": "application/json",
+	".yaml": "text/x-yaml",
+	".yml":  "text/x-yaml",
+	".toml": "text/x-toml",
+	".css":  "text/css",
+	".sql":  "text/x-sql",
+}
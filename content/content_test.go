@@ -0,0 +1,125 @@
+package content
+
+import "testing"
+
+// ftyp returns the header of an ISO base media file of major brand `brand`,
+// also listed as its only compatible brand.
+func ftyp(brand string) []byte {
+	return []byte("\x00\x00\x00\x14ftyp" + brand + "\x00\x00\x00\x00" + brand)
+}
+
+func TestClassify(t *testing.T) {
+	var (
+		jpeg = []byte("\xff\xd8\xff\xe1\x00\x18Exif\x00\x00")
+		png  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+		tiff = []byte("II*\x00\x08\x00\x00\x00")
+		zip  = []byte("PK\x03\x04\x14\x00\x00\x00")
+		text = []byte("hello, world\n")
+		bin  = []byte("\x00\x01\x02\x03\x04\x05\x06\x07")
+	)
+	tests := []struct {
+		name string
+		hdr  []byte
+		want string
+		cat  Category
+	}{
+		// sniffed headers, whatever the name
+		{"photo.jpg", jpeg, "image/jpeg", Image},
+		{"photo.mov", jpeg, "image/jpeg", Image},
+		{"image.png", png, "image/png", Image},
+		{"IMG_0001.HEIC", ftyp("heic"), "image/heic", Image},
+		{"IMG_0001", ftyp("heix"), "image/heic", Image},
+		{"burst.heic", ftyp("hevc"), "image/heic-sequence", Image},
+		{"image.avif", ftyp("avif"), "image/avif", Image},
+		{"IMG_0001.CR3", ftyp("crx "), "image/x-canon-cr3", RawImage},
+		{"IMG_0001.CR2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), "image/x-canon-cr2", RawImage},
+		{"P0001.ORF", []byte("IIRO\x08\x00\x00\x00"), "image/x-olympus-orf", RawImage},
+		{"clip.mp4", ftyp("isom"), "video/mp4", Video},
+		{"clip", ftyp("mp42"), "video/mp4", Video},
+		{"IMG_0001.MOV", ftyp("qt  "), "video/quicktime", Video},
+		{"old.mov", []byte("\x00\x00\x00\x20moov\x00\x00\x00\x6cmvhd"), "video/quicktime", Video},
+		{"old", []byte("\x00\x00\x00\x08wide\x00\x00\x00\x00mdat"), "video/quicktime", Video},
+		{"clip.3gp", ftyp("3gp5"), "video/3gpp", Video},
+		{"clip.mkv", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01matroska"), "video/x-matroska", Video},
+		{"clip.webm", []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"), "video/webm", Video},
+		{"song.m4a", ftyp("M4A "), "audio/mp4", Audio},
+		{"doc.pdf", []byte("%PDF-1.7\n"), "application/pdf", Document},
+		{"notes", text, "text/plain; charset=utf-8", Document},
+		{"page.html", []byte("<!DOCTYPE html><html>"), "text/html; charset=utf-8", Code},
+		{"empty.jpg", nil, EmptyType, Other},
+		{"empty.mp4", []byte{}, EmptyType, Other},
+
+		// generic headers, told apart by their name
+		{"DSC_0001.NEF", tiff, "image/x-nikon-nef", RawImage},
+		{"DSC_0001.arw", tiff, "image/x-sony-arw", RawImage},
+		{"scan.tif", tiff, "image/tiff", Image},
+		{"IMG_0001.heic", bin, "image/heic", Image},
+		{"clip.MOV", bin, "video/quicktime", Video},
+		{"clip.mp4", bin, "video/mp4", Video},
+		{"blob", bin, "application/octet-stream", Other},
+		{"blob.unknown", bin, "application/octet-stream", Other},
+		{"report.docx", zip, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Document},
+		{"app.jar", zip, "application/java-archive", Archive},
+		{"files.zip", zip, "application/zip", Archive},
+		{"main.go", text, "text/x-go", Code},
+		{"config.YAML", text, "text/x-yaml", Code},
+		{"README.md", text, "text/markdown", Document},
+		{"notes.txt", text, "text/plain; charset=utf-8", Document},
+	}
+	for _, tt := range tests {
+		typ, cat := Classify(tt.hdr, tt.name)
+		if typ != tt.want || cat != tt.cat {
+			t.Errorf("Classify(%q, %q) = %q, %v, want %q, %v", tt.hdr, tt.name, typ, cat, tt.want, tt.cat)
+		}
+	}
+}
+
+func TestIsImageIsVideo(t *testing.T) {
+	tests := []struct {
+		hdr          []byte
+		image, video bool
+	}{
+		{[]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), true, false},
+		{[]byte("\x89PNG\r\n\x1a\n"), true, false},
+		{[]byte("GIF89a\x01\x00\x01\x00"), true, false},
+		{ftyp("heic"), true, false},
+		{ftyp("mif1"), true, false},
+		{[]byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), true, false},
+		{[]byte("MM\x00*\x00\x00\x00\x08"), true, false},
+		{ftyp("isom"), false, true},
+		{ftyp("qt  "), false, true},
+		{[]byte("\x00\x00\x00\x20moov"), false, true},
+		{[]byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81"), false, true},
+		{ftyp("M4A "), false, false},
+		{[]byte("hello, world\n"), false, false},
+		{[]byte("%PDF-1.7\n"), false, false},
+		{[]byte("\x00\x01\x02\x03"), false, false},
+		{nil, false, false},
+	}
+	for _, tt := range tests {
+		if got := IsImage(tt.hdr); got != tt.image {
+			t.Errorf("IsImage(%q) = %v, want %v", tt.hdr, got, tt.image)
+		}
+		if got := IsVideo(tt.hdr); got != tt.video {
+			t.Errorf("IsVideo(%q) = %v, want %v", tt.hdr, got, tt.video)
+		}
+		if got := IsImageType(GetType(tt.hdr)); got != tt.image {
+			t.Errorf("IsImageType(GetType(%q)) = %v, want %v", tt.hdr, got, tt.image)
+		}
+	}
+}
+
+func TestNameType(t *testing.T) {
+	for name, want := range map[string]string{
+		"IMG_0001.HEIC": "image/heic",
+		"DSC_0001.nef":  "image/x-nikon-nef",
+		"clip.mov":      "video/quicktime",
+		"photo.jpg":     "image/jpeg",
+		"blob":          "application/octet-stream",
+		"blob.unknown":  "application/octet-stream",
+	} {
+		if got := NameType(name); got != want {
+			t.Errorf("NameType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
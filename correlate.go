@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/timblaktu/wupdedup/db"
+	"golang.org/x/exp/slog"
+)
+
+// Name of the bucket holding duplicate groups that span several providers.
+const crossProviderBucket = "cross-provider-duplicates"
+
+// A CrossProviderGroup is a set of copies of the same content held by more
+// than one provider, linked by any digest or provider checksum they share.
+type CrossProviderGroup struct {
+	// Digests linking the members, as "<algorithm>:<hex>".
+	Hashes    []string          `json:"hashes"`
+	Size      int64             `json:"size"`
+	Providers []string          `json:"providers"`
+	Members   []DuplicateMember `json:"members"`
+}
+
+// Key returns the bucket key the group is stored under.
+func (g *CrossProviderGroup) Key() []byte {
+	return []byte(g.Hashes[0])
+}
+
+// correlateProviders compares the records of every context and replaces the
+// contents of the cross-provider bucket with the groups of identical content
+// found in more than one of them.
+//
+// Providers don't all compute the same digests (eg: local sha256 vs SmugMug
+// md5), so every hash and checksum of a record is a link to other records,
+// and groups are the connected sets of records under those links.
+func correlateProviders(d *db.DB, contexts []*StorageStrategyContext) error {
+	var members []DuplicateMember
+	var sizes []int64
+	owners := make(map[string]int) // "<algo>:<hex>" -> first member holding it
+	sets := newDisjointSets()
+	for _, c := range contexts {
+		err := c.bucket.Map(func(k, v []byte) error {
+			r, err := UnmarshalFileRecord(v)
+			if err != nil {
+				slog.Error("skipping undecodable record", err, "key", string(k))
+				return nil
+			}
+			if !r.Mode.IsRegular() || r.Size == 0 {
+				return nil
+			}
+			i := sets.add()
+			members = append(members, DuplicateMember{c.name, r.Path})
+			sizes = append(sizes, r.Size)
+			for _, sums := range []map[string]string{r.Hashes, r.Checksums} {
+				for algo, sum := range sums {
+					key := algo + ":" + sum
+					if j, ok := owners[key]; ok {
+						sets.union(i, j)
+					} else {
+						owners[key] = i
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	groups := make(map[int]*CrossProviderGroup)
+	for i, m := range members {
+		root := sets.find(i)
+		g, ok := groups[root]
+		if !ok {
+			g = &CrossProviderGroup{Size: sizes[i]}
+			groups[root] = g
+		}
+		g.Members = append(g.Members, m)
+	}
+	for key, i := range owners {
+		g := groups[sets.find(i)]
+		g.Hashes = append(g.Hashes, key)
+	}
+
+	b, err := d.Recreate([]byte(crossProviderBucket))
+	if err != nil {
+		return err
+	}
+	out := db.NewTypedBucket[string, *CrossProviderGroup](b, db.StringKeys{}, db.JSONCodec[*CrossProviderGroup]{})
+	var items []db.TypedItem[string, *CrossProviderGroup]
+	for _, g := range groups {
+		providers := make(map[string]bool)
+		for _, m := range g.Members {
+			providers[m.Provider] = true
+		}
+		if len(providers) < 2 {
+			continue
+		}
+		for p := range providers {
+			g.Providers = append(g.Providers, p)
+		}
+		sort.Strings(g.Providers)
+		sort.Strings(g.Hashes)
+		sort.Slice(g.Members, func(i, j int) bool {
+			if g.Members[i].Provider != g.Members[j].Provider {
+				return g.Members[i].Provider < g.Members[j].Provider
+			}
+			return g.Members[i].Path < g.Members[j].Path
+		})
+		items = append(items, db.TypedItem[string, *CrossProviderGroup]{Key: string(g.Key()), Value: g})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	if err := out.Insert(items); err != nil {
+		return err
+	}
+	slog.Info("Done correlating providers", "#providers", len(contexts), "#groups", len(items))
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// disjointSets is a union-find over member indexes.
+type disjointSets struct {
+	parent []int
+}
+
+func newDisjointSets() *disjointSets {
+	return &disjointSets{}
+}
+
+// add creates a new singleton set and returns its index.
+func (s *disjointSets) add() int {
+	s.parent = append(s.parent, len(s.parent))
+	return len(s.parent) - 1
+}
+
+func (s *disjointSets) find(i int) int {
+	for s.parent[i] != i {
+		s.parent[i] = s.parent[s.parent[i]]
+		i = s.parent[i]
+	}
+	return i
+}
+
+func (s *disjointSets) union(i, j int) {
+	ri, rj := s.find(i), s.find(j)
+	if ri != rj {
+		s.parent[ri] = rj
+	}
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+/* -- BATCH WRITER -- */
+
+// BatchOptions configure a BatchWriter.
+type BatchOptions struct {
+	// Size is the number of buffered writes that triggers a commit.
+	Size int
+	// Interval is the longest a buffered write waits to be committed, or 0 to
+	// wait until Size writes are buffered or the writer is flushed.
+	Interval time.Duration
+	// UseBoltBatch hands each write to the KV's Batch, which bolt uses to
+	// coalesce concurrent writes into shared transactions, instead of
+	// buffering. Each Put then waits for its batch to commit, so it only pays
+	// off with many concurrent writers. Size then sets bolt's MaxBatchSize.
+	UseBoltBatch bool
+}
+
+// BatchStats hold the commit latency metrics of a BatchWriter.
+type BatchStats struct {
+	Writes  int
+	Commits int
+	// Total and longest time spent committing.
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean commit latency.
+func (s BatchStats) Mean() time.Duration {
+	if s.Commits == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Commits)
+}
+
+// ErrWriterClosed is returned for writes to a closed BatchWriter.
+var ErrWriterClosed = errors.New("batch writer closed")
+
+// A BatchWriter buffers writes to a bucket from any number of goroutines, and
+// commits them sorted by key in transactions of up to Size writes, which is
+// much faster than a transaction per write. Writes for the same key are
+// committed in the order they were made.
+//
+// Buffered writes aren't visible to readers of the bucket until committed.
+// Close must be called to commit the last of them.
+type BatchWriter struct {
+	bk   *Bucket
+	opts BatchOptions
+
+	mu      sync.Mutex
+	pending []Item
+	closed  bool
+	stats   BatchStats
+	// err is the first error of a commit, which fails every later Put.
+	err error
+
+	// flushMu serializes commits, so that they land in the order their
+	// writes were made.
+	flushMu sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewBatchWriter returns a BatchWriter for the bucket.
+func (bk *Bucket) NewBatchWriter(opts BatchOptions) *BatchWriter {
+	if opts.Size <= 0 {
+		opts.Size = 1
+	}
+	w := &BatchWriter{bk: bk, opts: opts}
+	if b, ok := bk.db.KV.(interface{ SetMaxBatchSize(int) }); ok && opts.UseBoltBatch {
+		b.SetMaxBatchSize(opts.Size)
+	}
+	if opts.Interval > 0 && !opts.UseBoltBatch {
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.tick()
+	}
+	return w
+}
+
+// tick flushes the writer every Interval until it's closed.
+func (w *BatchWriter) tick() {
+	defer close(w.done)
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			// a failure is latched in w.err
+			w.Flush()
+		}
+	}
+}
+
+// Put buffers value `v` with key `k`, committing the buffer if it's full.
+func (w *BatchWriter) Put(k, v []byte) error {
+	item := Item{append([]byte(nil), k...), append([]byte(nil), v...)}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+	if err := w.err; err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	if w.opts.UseBoltBatch {
+		w.mu.Unlock()
+		return w.commit(func(tx KVTx) error {
+			return w.bk.put(tx, item.Key, item.Value)
+		}, 1)
+	}
+	w.pending = append(w.pending, item)
+	full := len(w.pending) >= w.opts.Size
+	w.mu.Unlock()
+	if full {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush commits every buffered write. If the commit fails, the writes are
+// kept buffered for the next Flush to retry.
+func (w *BatchWriter) Flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	items := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(items) == 0 {
+		return nil
+	}
+	// Sorting speeds up bolt's inserts; a stable sort keeps the last write
+	// of a key last.
+	sort.SliceStable(items, func(i, j int) bool {
+		return bytes.Compare(items[i].Key, items[j].Key) < 0
+	})
+	err := w.commit(func(tx KVTx) error {
+		for _, item := range items {
+			if err := w.bk.put(tx, item.Key, item.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, len(items))
+	if err != nil {
+		// writes buffered since are newer, so these go back ahead of them
+		w.mu.Lock()
+		w.pending = append(items, w.pending...)
+		w.mu.Unlock()
+	}
+	return err
+}
+
+// commit runs `fn` in a transaction and records its latency, or latches its
+// error.
+func (w *BatchWriter) commit(fn func(tx KVTx) error, writes int) error {
+	start := time.Now()
+	var err error
+	if w.opts.UseBoltBatch {
+		err = w.bk.db.Batch(fn)
+	} else {
+		err = w.bk.db.Update(fn)
+	}
+	elapsed := time.Since(start)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		return err
+	}
+	w.stats.Writes += writes
+	w.stats.Commits++
+	w.stats.Total += elapsed
+	if elapsed > w.stats.Max {
+		w.stats.Max = elapsed
+	}
+	return nil
+}
+
+// Stats returns the writer's commit metrics so far. With UseBoltBatch, each
+// write counts as a commit, timed until bolt committed its batch.
+func (w *BatchWriter) Stats() BatchStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Close commits every buffered write and stops the writer. It returns the
+// first error of any of its commits, even if a later one committed the
+// writes it failed, as writes made since were refused.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.Flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errCommit = errors.New("disk full")
+
+// failingKV fails every read-write transaction while its failing flag is set.
+type failingKV struct {
+	KV
+	mu      sync.Mutex
+	failing bool
+}
+
+func (kv *failingKV) fail(failing bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.failing = failing
+}
+
+func (kv *failingKV) Update(fn func(tx KVTx) error) error {
+	kv.mu.Lock()
+	failing := kv.failing
+	kv.mu.Unlock()
+	if failing {
+		return errCommit
+	}
+	return kv.KV.Update(fn)
+}
+
+func (kv *failingKV) Batch(fn func(tx KVTx) error) error {
+	return kv.Update(fn)
+}
+
+// keysOf returns the keys stored in bk.
+func keysOf(t *testing.T, bk *Bucket) []string {
+	t.Helper()
+	items, err := bk.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, string(item.Key))
+	}
+	return keys
+}
+
+func TestBatchWriter(t *testing.T) {
+	d := New(newMemKV())
+	bk, err := d.Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bk.NewBatchWriter(BatchOptions{Size: 3})
+	for _, k := range []string{"c", "a", "b", "d"} {
+		if err := w.Put([]byte(k), []byte(k+"1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := keysOf(t, bk), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("before Close, stored %q, want the first full batch %q", got, want)
+	}
+	if err := w.Put([]byte("a"), []byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keysOf(t, bk), []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after Close, stored %q, want %q", got, want)
+	}
+	if v, _ := bk.Get([]byte("a")); string(v) != "a2" {
+		t.Errorf("a = %q, want its last write a2", v)
+	}
+	if s := w.Stats(); s.Writes != 5 || s.Commits != 2 {
+		t.Errorf("stats = %+v, want 5 writes in 2 commits", s)
+	}
+	if err := w.Put([]byte("f"), nil); err != ErrWriterClosed {
+		t.Errorf("Put after Close: %v, want %v", err, ErrWriterClosed)
+	}
+}
+
+func TestBatchWriterFailures(t *testing.T) {
+	for _, opts := range []BatchOptions{
+		{Size: 2},
+		{Size: 100, Interval: time.Millisecond},
+		{Size: 2, UseBoltBatch: true},
+	} {
+		t.Run(fmt.Sprintf("%+v", opts), func(t *testing.T) {
+			kv := &failingKV{KV: newMemKV()}
+			d := New(kv)
+			bk, err := d.Bucket([]byte("b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := bk.NewBatchWriter(opts)
+			if err := w.Put([]byte("a"), []byte("1")); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			kv.fail(true)
+			// Writes fail once a commit failed, be it a Put's, or one made
+			// in the background.
+			var putErr error
+			for i := 0; putErr == nil && i < 1000; i++ {
+				putErr = w.Put([]byte(fmt.Sprintf("k%03d", i)), []byte("v"))
+				time.Sleep(time.Millisecond / 10)
+			}
+			if putErr != errCommit {
+				t.Fatalf("Put with commits failing: %v, want %v", putErr, errCommit)
+			}
+			if err := w.Put([]byte("z"), []byte("v")); err != errCommit {
+				t.Errorf("Put after a failed commit: %v, want %v", err, errCommit)
+			}
+
+			// The writes of the failed commit are retried, but Close still
+			// reports the failure, as later writes were refused.
+			kv.fail(false)
+			if err := w.Close(); err != errCommit {
+				t.Errorf("Close after a failed commit: %v, want %v", err, errCommit)
+			}
+			got := keysOf(t, bk)
+			if opts.UseBoltBatch {
+				if want := []string{"a"}; !reflect.DeepEqual(got, want) {
+					t.Errorf("stored %q, want %q", got, want)
+				}
+			} else if len(got) < 2 || got[0] != "a" || got[1] != "k000" || got[len(got)-1] == "z" {
+				t.Errorf("stored %q, want a and the writes made before the failure", got)
+			}
+		})
+	}
+}
+
+func TestBatchWriterCloseFailure(t *testing.T) {
+	kv := &failingKV{KV: newMemKV()}
+	bk, err := New(kv).Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bk.NewBatchWriter(BatchOptions{Size: 10})
+	for _, k := range []string{"a", "b"} {
+		if err := w.Put([]byte(k), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kv.fail(true)
+	if err := w.Close(); err != errCommit {
+		t.Errorf("Close failing to commit: %v, want %v", err, errCommit)
+	}
+	kv.fail(false)
+	if got := keysOf(t, bk); len(got) != 0 {
+		t.Errorf("stored %q, want nothing", got)
+	}
+}
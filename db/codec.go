@@ -0,0 +1,100 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+/* -- CODECS -- */
+
+// A Codec converts values of type V to and from the bytes stored in a bucket.
+type Codec[V any] interface {
+	Encode(v V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// JSONCodec encodes values as JSON.
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(v V) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[V]) Decode(data []byte) (v V, err error) {
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob. Each value is encoded as a
+// self-contained stream, type information included.
+type GobCodec[V any] struct{}
+
+func (GobCodec[V]) Encode(v V) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[V]) Decode(data []byte) (v V, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// MsgpackCodec encodes values as MessagePack.
+type MsgpackCodec[V any] struct{}
+
+func (MsgpackCodec[V]) Encode(v V) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec[V]) Decode(data []byte) (v V, err error) {
+	err = msgpack.Unmarshal(data, &v)
+	return v, err
+}
+
+// A Message is a value that marshals itself to a compact binary form, such as
+// the types generated by gogo/protobuf or vtprotobuf.
+type Message interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// MessageCodec encodes values of a pointer type PV whose pointee V
+// implements Message, eg: MessageCodec[pb.File, *pb.File].
+type MessageCodec[V any, PV interface {
+	*V
+	Message
+}] struct{}
+
+func (MessageCodec[V, PV]) Encode(v PV) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("cannot encode nil %T", v)
+	}
+	return v.Marshal()
+}
+
+func (MessageCodec[V, PV]) Decode(data []byte) (PV, error) {
+	v := PV(new(V))
+	if err := v.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// BytesCodec stores byte slices as they are. Decoded slices are copies, valid
+// beyond the transaction they were read in.
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (BytesCodec) Decode(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
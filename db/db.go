@@ -1,9 +1,11 @@
 package db
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 	"golang.org/x/exp/slog"
@@ -11,13 +13,17 @@ import (
 
 // DB Type
 //
-// A DB is a bolt database with convenience methods for working with buckets.
+// A DB is a key/value store with convenience methods for working with
+// buckets.
 //
-// A DB embeds the exposed bolt.DB methods.
+// A DB embeds the methods of the KV it's built on.
 type DB struct {
-	*bolt.DB
+	KV
 }
 
+// Name of the database file that Open opens as an in-memory database.
+const MemoryFile = ":memory:"
+
 func Init(dbfile string) *DB {
 	d, err := Open(dbfile)
 	if err != nil {
@@ -26,7 +32,17 @@ func Init(dbfile string) *DB {
 	return d
 }
 
+// New returns a DB built on kv.
+func New(kv KV) *DB {
+	return &DB{kv}
+}
+
+// Open opens the bolt database file at path, creating it if it doesn't exist,
+// or an in-memory database if path is MemoryFile.
 func Open(path string) (*DB, error) {
+	if path == MemoryFile {
+		return OpenMemory(), nil
+	}
 	opts := &bolt.Options{}
 	// TODO: add support for db options
 	// opts := &bolt.Options{Timeout: 1 * time.Second}
@@ -35,12 +51,35 @@ func Open(path string) (*DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't open %s: %s", path, err)
 	}
-	return &DB{db}, nil
+	return New(boltKV{db}), nil
+}
+
+// OpenReadOnly opens the database at path for reading only, failing after
+// `timeout` if another process holds it open for writing.
+func OpenReadOnly(path string, timeout time.Duration) (*DB, error) {
+	// bolt would create a missing file, and then fail to initialize it
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	opts := &bolt.Options{ReadOnly: true, Timeout: timeout}
+	slog.Debug("opening bolt db", "path", path, "opts", opts)
+	db, err := bolt.Open(path, 0400, opts)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %s", path, err)
+	}
+	return New(boltKV{db}), nil
+}
+
+// OpenMemory returns an empty database held in memory, and lost when it's
+// closed.
+func OpenMemory() *DB {
+	slog.Debug("opening memory db")
+	return New(newMemKV())
 }
 
 func (db *DB) Bucket(name []byte) (*Bucket, error) {
 	slog.Debug("creating bucket if it doesn't exist", "name", name)
-	err := db.Update(func(tx *bolt.Tx) error {
+	err := db.Update(func(tx KVTx) error {
 		b, err := tx.CreateBucketIfNotExists(name)
 		if err != nil {
 			slog.Error("error creating bucket", err, "name", name)
@@ -53,16 +92,56 @@ func (db *DB) Bucket(name []byte) (*Bucket, error) {
 		slog.Error("db.Update(TX) failed", err)
 		return nil, err
 	}
-	return &Bucket{db, name}, nil
+	return newBucket(db, [][]byte{name}), nil
 }
 
-// Delete removes the named bucket.
+// Delete removes the named bucket, along with its indexes.
 func (db *DB) Delete(name []byte) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return db.Update(func(tx KVTx) error {
+		if err := deleteIndexes(tx, name); err != nil {
+			return err
+		}
 		return tx.DeleteBucket(name)
 	})
 }
 
+// deleteIndexes removes the index buckets of the bucket named `name` within
+// container c.
+func deleteIndexes(c KVContainer, name []byte) error {
+	siblings, err := children(c)
+	if err != nil {
+		return err
+	}
+	for _, n := range siblings {
+		if isIndexOf(n, name) {
+			if err := c.DeleteBucket(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Recreate empties the named bucket, creating it if it doesn't exist. Its
+// indexes are removed.
+func (db *DB) Recreate(name []byte) (*Bucket, error) {
+	err := db.Update(func(tx KVTx) error {
+		if err := deleteIndexes(tx, name); err != nil {
+			return err
+		}
+		err := tx.DeleteBucket(name)
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+		_, err = tx.CreateBucket(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newBucket(db, [][]byte{name}), nil
+}
+
 /* -- ITEM -- */
 
 // An Item holds a key/value pair.
@@ -74,28 +153,48 @@ type Item struct {
 /* -- BUCKET-- */
 
 // Bucket represents a collection of key/value pairs inside the database.
+// Buckets may be nested in other buckets.
 type Bucket struct {
 	db   *DB
 	Name []byte
+	// names of the buckets containing the bucket, outermost first
+	parents [][]byte
+	indexes []Index
 }
 
 // Put inserts value `v` with key `k`.
 func (bk *Bucket) Put(k, v []byte) error {
-	return bk.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bk.Name).Put(k, v)
+	return bk.db.Update(func(tx KVTx) error {
+		return bk.put(tx, k, v)
 	})
 }
 
 // PutNX (put-if-not-exists) inserts value `v` with key `k`
 // if key doesn't exist.
 func (bk *Bucket) PutNX(k, v []byte) error {
-	v, err := bk.Get(k)
-	if v != nil || err != nil {
+	return bk.db.Tx(func(tx *Tx) error {
+		b, err := tx.Bucket(bk)
+		if err != nil {
+			return err
+		}
+		_, err = b.PutNX(k, v)
+		return err
+	})
+}
+
+// CompareAndSwap replaces the value of key `k` with `v` if its current value
+// is `old`, and reports whether it did. A nil `old` matches a missing key, and
+// a nil `v` deletes the key.
+func (bk *Bucket) CompareAndSwap(k, old, v []byte) (swapped bool, err error) {
+	err = bk.db.Tx(func(tx *Tx) error {
+		b, err := tx.Bucket(bk)
+		if err != nil {
+			return err
+		}
+		swapped, err = b.CompareAndSwap(k, old, v)
 		return err
-	}
-	return bk.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bk.Name).Put(k, v)
 	})
+	return swapped, err
 }
 
 // Insert iterates over a slice of k/v pairs, putting each item in
@@ -103,9 +202,11 @@ func (bk *Bucket) PutNX(k, v []byte) error {
 // be sure to pre-sort your items (by Key in byte-sorted order), which
 // will result in much more efficient insertion times and storage costs.
 func (bk *Bucket) Insert(items []struct{ Key, Value []byte }) error {
-	return bk.db.Update(func(tx *bolt.Tx) error {
+	return bk.db.Update(func(tx KVTx) error {
 		for _, item := range items {
-			tx.Bucket(bk.Name).Put(item.Key, item.Value)
+			if err := bk.put(tx, item.Key, item.Value); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -116,11 +217,14 @@ func (bk *Bucket) Insert(items []struct{ Key, Value []byte }) error {
 // Unlike Insert, however, InsertNX will not update the value for an
 // existing key.
 func (bk *Bucket) InsertNX(items []struct{ Key, Value []byte }) error {
-	return bk.db.Update(func(tx *bolt.Tx) error {
+	return bk.db.Tx(func(tx *Tx) error {
+		b, err := tx.Bucket(bk)
+		if err != nil {
+			return err
+		}
 		for _, item := range items {
-			v, _ := bk.Get(item.Key)
-			if v == nil {
-				tx.Bucket(bk.Name).Put(item.Key, item.Value)
+			if _, err := b.PutNX(item.Key, item.Value); err != nil {
+				return err
 			}
 		}
 		return nil
@@ -129,15 +233,19 @@ func (bk *Bucket) InsertNX(items []struct{ Key, Value []byte }) error {
 
 // Delete removes key `k`.
 func (bk *Bucket) Delete(k []byte) error {
-	return bk.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bk.Name).Delete(k)
+	return bk.db.Update(func(tx KVTx) error {
+		return bk.del(tx, k)
 	})
 }
 
 // Get retrieves the value for key `k`.
 func (bk *Bucket) Get(k []byte) (value []byte, err error) {
-	err = bk.db.View(func(tx *bolt.Tx) error {
-		v := tx.Bucket(bk.Name).Get(k)
+	err = bk.db.View(func(tx KVTx) error {
+		b, err := bk.open(tx)
+		if err != nil {
+			return err
+		}
+		v := b.Get(k)
 		if v != nil {
 			value = make([]byte, len(v))
 			copy(value, v)
@@ -150,100 +258,52 @@ func (bk *Bucket) Get(k []byte) (value []byte, err error) {
 // Items returns a slice of key/value pairs.  Each k/v pair in the slice
 // is of type Item (`struct{ Key, Value []byte }`).
 func (bk *Bucket) Items() (items []Item, err error) {
-	return items, bk.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(bk.Name).Cursor()
-		var key, value []byte
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if v != nil {
-				key = make([]byte, len(k))
-				copy(key, k)
-				value = make([]byte, len(v))
-				copy(value, v)
-				items = append(items, Item{key, value})
-			}
-		}
-		return nil
-	})
+	return items, bk.Map(collectItems(&items))
 }
 
 // PrefixItems returns a slice of key/value pairs for all keys with
 // a given prefix.  Each k/v pair in the slice is of type Item
 // (`struct{ Key, Value []byte }`).
 func (bk *Bucket) PrefixItems(pre []byte) (items []Item, err error) {
-	err = bk.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(bk.Name).Cursor()
-		var key, value []byte
-		for k, v := c.Seek(pre); bytes.HasPrefix(k, pre); k, v = c.Next() {
-			if v != nil {
-				key = make([]byte, len(k))
-				copy(key, k)
-				value = make([]byte, len(v))
-				copy(value, v)
-				items = append(items, Item{key, value})
-			}
-		}
-		return nil
-	})
-	return items, err
+	return bk.NewPrefixScanner(pre).Items()
 }
 
 // RangeItems returns a slice of key/value pairs for all keys within
 // a given range.  Each k/v pair in the slice is of type Item
 // (`struct{ Key, Value []byte }`).
 func (bk *Bucket) RangeItems(min []byte, max []byte) (items []Item, err error) {
-	err = bk.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(bk.Name).Cursor()
-		var key, value []byte
-		for k, v := c.Seek(min); isBefore(k, max); k, v = c.Next() {
-			if v != nil {
-				key = make([]byte, len(k))
-				copy(key, k)
-				value = make([]byte, len(v))
-				copy(value, v)
-				items = append(items, Item{key, value})
-			}
-		}
-		return nil
-	})
-	return items, err
+	return bk.NewRangeScanner(min, max).Items()
+}
+
+// Iterate applies `do` on each key/value pair, in key order, until `do`
+// returns an error or `ctx` is done. Returning ErrStop from `do` ends the
+// iteration without error.
+func (bk *Bucket) Iterate(ctx context.Context, opts IterOptions, do func(k, v []byte) error) error {
+	return iterate(ctx, bk.db, bk.Path(), nil, all, opts, do)
 }
 
 // Map applies `do` on each key/value pair.
 func (bk *Bucket) Map(do func(k, v []byte) error) error {
-	return bk.db.View(func(tx *bolt.Tx) error {
-		return tx.Bucket(bk.Name).ForEach(do)
-	})
+	return bk.Iterate(context.Background(), IterOptions{}, do)
 }
 
 // MapPrefix applies `do` on each k/v pair of keys with prefix.
 func (bk *Bucket) MapPrefix(do func(k, v []byte) error, pre []byte) error {
-	return bk.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(bk.Name).Cursor()
-		for k, v := c.Seek(pre); bytes.HasPrefix(k, pre); k, v = c.Next() {
-			do(k, v)
-		}
-		return nil
-	})
+	return bk.NewPrefixScanner(pre).Map(do)
 }
 
 // MapRange applies `do` on each k/v pair of keys within range.
 func (bk *Bucket) MapRange(do func(k, v []byte) error, min, max []byte) error {
-	return bk.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(bk.Name).Cursor()
-		for k, v := c.Seek(min); isBefore(k, max); k, v = c.Next() {
-			do(k, v)
-		}
-		return nil
-	})
+	return bk.NewRangeScanner(min, max).Map(do)
 }
 
 // NewPrefixScanner initializes a new prefix scanner.
 func (bk *Bucket) NewPrefixScanner(pre []byte) *PrefixScanner {
-	return &PrefixScanner{bk.db, bk.Name, pre}
+	return &PrefixScanner{bk.db, bk.Name, pre, bk.Path()}
 }
 
 // NewRangeScanner initializes a new range scanner.  It takes a `min` and a
 // `max` key for specifying the range paramaters.
 func (bk *Bucket) NewRangeScanner(min, max []byte) *RangeScanner {
-	return &RangeScanner{bk.db, bk.Name, min, max}
+	return &RangeScanner{bk.db, bk.Name, min, max, bk.Path()}
 }
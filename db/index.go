@@ -0,0 +1,282 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/slog"
+)
+
+/* -- INDEX -- */
+
+// An Index maintains an auxiliary bucket mapping keys derived from each value
+// in a Bucket back to the value's key, so values can be found by something
+// other than their key without scanning the whole bucket.
+//
+// Index buckets are updated in the same transaction as every Put, Insert and
+// Delete on the indexed bucket.
+type Index struct {
+	// Name identifies the index among its bucket's indexes.
+	Name string
+	// Keys returns the index keys of a key/value pair. A pair may have no
+	// index keys, or several.
+	Keys func(k, v []byte) [][]byte
+}
+
+// indexSep separates an index's name from its bucket's name.
+const indexSep = ".idx."
+
+// indexBucketName returns the name of the bucket holding index `name` of
+// bucket `bucket`.
+func indexBucketName(bucket []byte, name string) []byte {
+	return []byte(string(bucket) + indexSep + name)
+}
+
+// isIndexOf reports whether `name` is an index bucket of bucket `bucket`.
+func isIndexOf(name, bucket []byte) bool {
+	return bytes.HasPrefix(name, indexBucketName(bucket, ""))
+}
+
+// IsIndexBucket reports whether `name` is the name of an index bucket.
+func IsIndexBucket(name []byte) bool {
+	return bytes.Contains(name, []byte(indexSep))
+}
+
+// Index entries are keyed by the index key followed by the record's key. To
+// keep entries sorted by index key and the two keys separable, 0x00 bytes in
+// the index key are escaped as 0x00 0xFF, and it's terminated by 0x00 0x01.
+func encodeIndexEntry(ik, k []byte) []byte {
+	e := make([]byte, 0, len(ik)+len(k)+2)
+	for _, c := range ik {
+		e = append(e, c)
+		if c == 0x00 {
+			e = append(e, 0xFF)
+		}
+	}
+	e = append(e, 0x00, 0x01)
+	return append(e, k...)
+}
+
+func encodeIndexPrefix(ik []byte) []byte {
+	e := encodeIndexEntry(ik, nil)
+	return e[:len(e):len(e)]
+}
+
+func decodeIndexEntry(e []byte) (ik, k []byte, err error) {
+	ik = make([]byte, 0, len(e))
+	for i := 0; i < len(e)-1; i++ {
+		if e[i] != 0x00 {
+			ik = append(ik, e[i])
+			continue
+		}
+		switch e[i+1] {
+		case 0xFF:
+			ik = append(ik, 0x00)
+			i++
+		case 0x01:
+			k = make([]byte, len(e)-i-2)
+			copy(k, e[i+2:])
+			return ik, k, nil
+		default:
+			return nil, nil, fmt.Errorf("malformed index entry %q", e)
+		}
+	}
+	return nil, nil, fmt.Errorf("malformed index entry %q", e)
+}
+
+// AddIndex registers an index on the bucket. If the index's bucket doesn't
+// exist yet, it's created and populated from the bucket's current contents.
+// The index is only registered once its bucket is.
+func (bk *Bucket) AddIndex(idx Index) error {
+	for _, i := range bk.indexes {
+		if i.Name == idx.Name {
+			return fmt.Errorf("bucket %s already has index %s", bk.Name, idx.Name)
+		}
+	}
+	err := bk.db.Update(func(tx KVTx) error {
+		b, err := bk.open(tx)
+		if err != nil {
+			return err
+		}
+		_, err = bk.indexBucket(tx, b, idx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	bk.indexes = append(bk.indexes, idx)
+	return nil
+}
+
+// indexBucket returns the bucket of index idx of b within tx. If it doesn't
+// exist, as after the bucket was recreated, it's created and populated from
+// b's current contents.
+func (bk *Bucket) indexBucket(tx KVTx, b KVBucket, idx Index) (KVBucket, error) {
+	if ib := resolve(tx, bk.indexPath(idx.Name)); ib != nil {
+		return ib, nil
+	}
+	slog.Debug("building index", "bucket", pathString(bk.Path()), "index", idx.Name)
+	ib, err := parentOf(tx, bk.Path()).CreateBucket(indexBucketName(bk.Name, idx.Name))
+	if err != nil {
+		return nil, err
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		return addIndexEntries(ib, idx, k, v)
+	})
+	return ib, err
+}
+
+// Reindex rebuilds every index of the bucket from its current contents.
+func (bk *Bucket) Reindex() error {
+	return bk.db.Update(func(tx KVTx) error {
+		b, err := bk.open(tx)
+		if err != nil {
+			return err
+		}
+		parent := parentOf(tx, bk.Path())
+		for _, idx := range bk.indexes {
+			name := indexBucketName(bk.Name, idx.Name)
+			if err := parent.DeleteBucket(name); err != nil && err != ErrBucketNotFound {
+				return err
+			}
+			ib, err := parent.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+			err = b.ForEach(func(k, v []byte) error {
+				return addIndexEntries(ib, idx, k, v)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func addIndexEntries(ib KVBucket, idx Index, k, v []byte) error {
+	// nested buckets aren't indexed
+	if v == nil {
+		return nil
+	}
+	for _, ik := range idx.Keys(k, v) {
+		if err := ib.Put(encodeIndexEntry(ik, k), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexPath returns the path of the bucket holding index `name`, a sibling
+// of the bucket.
+func (bk *Bucket) indexPath(name string) [][]byte {
+	return append(append([][]byte(nil), bk.parents...), indexBucketName(bk.Name, name))
+}
+
+func (bk *Bucket) index(name string) (Index, error) {
+	for _, idx := range bk.indexes {
+		if idx.Name == name {
+			return idx, nil
+		}
+	}
+	return Index{}, fmt.Errorf("bucket %s has no index %s", bk.Name, name)
+}
+
+// put stores k/v within tx, updating the bucket's indexes.
+func (bk *Bucket) put(tx KVTx, k, v []byte) error {
+	b, err := bk.open(tx)
+	if err != nil {
+		return err
+	}
+	if err := bk.unindex(tx, b, k, b.Get(k)); err != nil {
+		return err
+	}
+	for _, idx := range bk.indexes {
+		ib, err := bk.indexBucket(tx, b, idx)
+		if err != nil {
+			return err
+		}
+		if err := addIndexEntries(ib, idx, k, v); err != nil {
+			return err
+		}
+	}
+	return b.Put(k, v)
+}
+
+// del removes k within tx, updating the bucket's indexes.
+func (bk *Bucket) del(tx KVTx, k []byte) error {
+	b, err := bk.open(tx)
+	if err != nil {
+		return err
+	}
+	if err := bk.unindex(tx, b, k, b.Get(k)); err != nil {
+		return err
+	}
+	return b.Delete(k)
+}
+
+// unindex removes the index entries of the old value `v` of key `k` of b.
+func (bk *Bucket) unindex(tx KVTx, b KVBucket, k, v []byte) error {
+	if v == nil {
+		return nil
+	}
+	for _, idx := range bk.indexes {
+		ib, err := bk.indexBucket(tx, b, idx)
+		if err != nil {
+			return err
+		}
+		for _, ik := range idx.Keys(k, v) {
+			if err := ib.Delete(encodeIndexEntry(ik, k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lookup returns the keys of the values whose index keys in index `name`
+// include `ik`. An index whose bucket is gone, as after the bucket was
+// recreated, matches nothing.
+func (bk *Bucket) Lookup(name string, ik []byte) (keys [][]byte, err error) {
+	if _, err := bk.index(name); err != nil {
+		return nil, err
+	}
+	pre := encodeIndexPrefix(ik)
+	err = bk.db.View(func(tx KVTx) error {
+		ib := resolve(tx, bk.indexPath(name))
+		if ib == nil {
+			return nil
+		}
+		c := ib.Cursor()
+		for e, _ := c.Seek(pre); bytes.HasPrefix(e, pre); e, _ = c.Next() {
+			k := make([]byte, len(e)-len(pre))
+			copy(k, e[len(pre):])
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// MapIndex applies `do` on each index key/key pair of index `name`, in index
+// key order, stopping at the first error `do` returns.
+func (bk *Bucket) MapIndex(name string, do func(ik, k []byte) error) error {
+	return bk.IterateIndex(context.Background(), name, IterOptions{}, do)
+}
+
+// IterateIndex applies `do` on each index key/key pair of index `name`, in
+// index key order, until `do` returns an error or `ctx` is done.
+func (bk *Bucket) IterateIndex(ctx context.Context, name string, opts IterOptions,
+	do func(ik, k []byte) error) error {
+	if _, err := bk.index(name); err != nil {
+		return err
+	}
+	return iterate(ctx, bk.db, bk.indexPath(name), nil, all, opts, func(e, _ []byte) error {
+		ik, k, err := decodeIndexEntry(e)
+		if err != nil {
+			return err
+		}
+		return do(ik, k)
+	})
+}
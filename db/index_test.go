@@ -0,0 +1,334 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexEntryEncoding(t *testing.T) {
+	tests := []struct {
+		ik, k, want string
+	}{
+		{"size", "a", "size\x00\x01a"},
+		{"", "a", "\x00\x01a"},
+		{"a\x00b", "k", "a\x00\xffb\x00\x01k"},
+		{"\x00\x01", "\x00\x01", "\x00\xff\x01\x00\x01\x00\x01"},
+		{"\xff\x00", "", "\xff\x00\xff\x00\x01"},
+	}
+	for _, tt := range tests {
+		e := encodeIndexEntry([]byte(tt.ik), []byte(tt.k))
+		if string(e) != tt.want {
+			t.Errorf("encodeIndexEntry(%q, %q) = %q, want %q", tt.ik, tt.k, e, tt.want)
+		}
+		ik, k, err := decodeIndexEntry(e)
+		if err != nil || string(ik) != tt.ik || string(k) != tt.k {
+			t.Errorf("decodeIndexEntry(%q) = %q, %q, %v, want %q, %q", e, ik, k, err, tt.ik, tt.k)
+		}
+		if pre := encodeIndexPrefix([]byte(tt.ik)); !bytes.HasPrefix(e, pre) {
+			t.Errorf("entry %q doesn't start with prefix %q", e, pre)
+		}
+	}
+
+	for _, e := range []string{"", "size", "a\x00", "a\x00\x02k"} {
+		if ik, k, err := decodeIndexEntry([]byte(e)); err == nil {
+			t.Errorf("decodeIndexEntry(%q) = %q, %q, want an error", e, ik, k)
+		}
+	}
+}
+
+func TestIndexEntryOrder(t *testing.T) {
+	// Entries sort by index key first, whatever the keys that follow, and the
+	// prefix of an index key matches none of the entries of longer ones.
+	iks := []string{"", "\x00", "\x00\x00", "\x00\x01", "a", "a\x00", "a\x00\xff", "a\x01", "ab", "b"}
+	var entries []string
+	for i := len(iks) - 1; i >= 0; i-- {
+		for _, k := range []string{"\xff", "\x00", "zz"} {
+			entries = append(entries, string(encodeIndexEntry([]byte(iks[i]), []byte(k))))
+		}
+	}
+	sort.Strings(entries)
+	for i, e := range entries {
+		ik, _, err := decodeIndexEntry([]byte(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := iks[i/3]; string(ik) != want {
+			t.Errorf("entry %d has index key %q, want %q", i, ik, want)
+		}
+		for _, other := range iks {
+			if other != string(ik) && bytes.HasPrefix([]byte(e), encodeIndexPrefix([]byte(other))) {
+				t.Errorf("entry %q of index key %q matches the prefix of %q", e, ik, other)
+			}
+		}
+	}
+}
+
+// tagIndex indexes comma-separated values by each of their items.
+var tagIndex = Index{Name: "tag", Keys: func(k, v []byte) [][]byte {
+	if len(v) == 0 {
+		return nil
+	}
+	return bytes.Split(v, []byte(","))
+}}
+
+// indexEntries returns the "ik=k" pairs of index `name` of bk, in order.
+func indexEntries(t *testing.T, bk *Bucket, name string) []string {
+	t.Helper()
+	var got []string
+	err := bk.MapIndex(name, func(ik, k []byte) error {
+		got = append(got, fmt.Sprintf("%s=%s", ik, k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func lookup(t *testing.T, bk *Bucket, name, ik string) string {
+	t.Helper()
+	keys, err := bk.Lookup(name, []byte(ik))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes.Join(keys, []byte(" ")))
+}
+
+func TestIndexSync(t *testing.T) {
+	d := New(newMemKV())
+	bk, err := d.Bucket([]byte("photos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values stored before the index is added are indexed by AddIndex.
+	if err := bk.Put([]byte("p1"), []byte("cat,dog")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.AddIndex(tagIndex); err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.AddIndex(tagIndex); err == nil {
+		t.Error("added the same index twice")
+	}
+
+	steps := []struct {
+		name string
+		op   func() error
+		want []string
+	}{
+		{"add index", func() error { return nil }, []string{"cat=p1", "dog=p1"}},
+		{"put", func() error { return bk.Put([]byte("p2"), []byte("dog")) },
+			[]string{"cat=p1", "dog=p1", "dog=p2"}},
+		{"overwrite", func() error { return bk.Put([]byte("p1"), []byte("bird,cat")) },
+			[]string{"bird=p1", "cat=p1", "dog=p2"}},
+		{"overwrite with no index keys", func() error { return bk.Put([]byte("p2"), []byte("")) },
+			[]string{"bird=p1", "cat=p1"}},
+		{"insert", func() error {
+			return bk.Insert([]struct{ Key, Value []byte }{{[]byte("p3"), []byte("cat")}, {[]byte("p2"), []byte("ant")}})
+		}, []string{"ant=p2", "bird=p1", "cat=p1", "cat=p3"}},
+		{"put in a transaction", func() error {
+			return d.Tx(func(tx *Tx) error {
+				b, err := tx.Bucket(bk)
+				if err != nil {
+					return err
+				}
+				if _, err := b.PutNX([]byte("p4"), []byte("eel")); err != nil {
+					return err
+				}
+				_, err = b.CompareAndSwap([]byte("p3"), []byte("cat"), []byte("cow"))
+				return err
+			})
+		}, []string{"ant=p2", "bird=p1", "cat=p1", "cow=p3", "eel=p4"}},
+		{"rolled back transaction", func() error {
+			d.Tx(func(tx *Tx) error {
+				b, err := tx.Bucket(bk)
+				if err != nil {
+					return err
+				}
+				if err := b.Put([]byte("p5"), []byte("fox")); err != nil {
+					return err
+				}
+				return fmt.Errorf("roll back")
+			})
+			return nil
+		}, []string{"ant=p2", "bird=p1", "cat=p1", "cow=p3", "eel=p4"}},
+		{"delete", func() error { return bk.Delete([]byte("p1")) },
+			[]string{"ant=p2", "cow=p3", "eel=p4"}},
+		{"delete missing", func() error { return bk.Delete([]byte("p1")) },
+			[]string{"ant=p2", "cow=p3", "eel=p4"}},
+		{"compare and delete", func() error {
+			_, err := bk.CompareAndSwap([]byte("p4"), []byte("eel"), nil)
+			return err
+		}, []string{"ant=p2", "cow=p3"}},
+	}
+	for _, s := range steps {
+		if err := s.op(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got := indexEntries(t, bk, "tag"); !reflect.DeepEqual(got, s.want) {
+			t.Fatalf("%s: index holds %q, want %q", s.name, got, s.want)
+		}
+	}
+
+	if got := lookup(t, bk, "tag", "cow"); got != "p3" {
+		t.Errorf("Lookup(cow) = %q, want p3", got)
+	}
+	if got := lookup(t, bk, "tag", "co"); got != "" {
+		t.Errorf("Lookup(co) = %q, want none", got)
+	}
+	if _, err := bk.Lookup("missing", []byte("cow")); err == nil {
+		t.Error("looked up a missing index")
+	}
+}
+
+func TestIndexKeysWithZeros(t *testing.T) {
+	d := New(newMemKV())
+	bk, err := d.Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := Index{Name: "raw", Keys: func(k, v []byte) [][]byte { return [][]byte{v} }}
+	if err := bk.AddIndex(raw); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{"k1": "a", "k2": "a\x00", "k3": "a\x00\x01", "k4": "\x00", "k5": "a"}
+	for k, v := range values {
+		if err := bk.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for ik, want := range map[string]string{"a": "k1 k5", "a\x00": "k2", "a\x00\x01": "k3", "\x00": "k4", "": ""} {
+		if got := lookup(t, bk, "raw", ik); got != want {
+			t.Errorf("Lookup(%q) = %q, want %q", ik, got, want)
+		}
+	}
+	got := strings.Join(indexEntries(t, bk, "raw"), " ")
+	if want := "\x00=k4 a=k1 a=k5 a\x00=k2 a\x00\x01=k3"; got != want {
+		t.Errorf("index holds %q, want %q", got, want)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	d := New(newMemKV())
+	bk, err := d.Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.AddIndex(tagIndex); err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.Put([]byte("k"), []byte("x,y")); err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the index behind the bucket's back, then rebuild it.
+	err = d.Update(func(tx KVTx) error {
+		return resolve(tx, bk.indexPath("tag")).Put(encodeIndexEntry([]byte("stale"), []byte("gone")), []byte{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexEntries(t, bk, "tag"), []string{"x=k", "y=k"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %q, want %q", got, want)
+	}
+}
+
+func TestIndexOnReadOnlyDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bk, err := d.Bucket([]byte("b"))
+	if err == nil {
+		err = bk.Put([]byte("k"), []byte("x"))
+	}
+	d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err = OpenReadOnly(path, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if bk, err = d.OpenBucketPath([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	// The index bucket can't be built, so the index isn't registered.
+	if err := bk.AddIndex(tagIndex); err == nil {
+		t.Fatal("added an index to a read-only database")
+	}
+	if keys, err := bk.Lookup("tag", []byte("x")); err == nil {
+		t.Errorf("Lookup through an index that failed to be added = %q", keys)
+	}
+}
+
+func TestIndexAfterRecreate(t *testing.T) {
+	d := New(newMemKV())
+	bk, err := d.Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.AddIndex(tagIndex); err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.Put([]byte("k1"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recreate drops the index buckets, which the handle rebuilds on its
+	// next write.
+	if _, err := d.Recreate([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookup(t, bk, "tag", "x"); got != "" {
+		t.Errorf("Lookup(x) after Recreate = %q, want none", got)
+	}
+	if err := bk.Put([]byte("k2"), []byte("x,y")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexEntries(t, bk, "tag"), []string{"x=k2", "y=k2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %q, want %q", got, want)
+	}
+
+	// An index bucket dropped under existing values is rebuilt from them.
+	err = d.Update(func(tx KVTx) error {
+		return tx.DeleteBucket(indexBucketName([]byte("b"), "tag"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.Put([]byte("k2"), []byte("z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.Put([]byte("k3"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexEntries(t, bk, "tag"), []string{"x=k3", "z=k2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %q, want %q", got, want)
+	}
+
+	// Once the bucket is deleted, writes fail and lookups match nothing.
+	if err := d.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookup(t, bk, "tag", "x"); got != "" {
+		t.Errorf("Lookup(x) after Delete = %q, want none", got)
+	}
+	if err := bk.Put([]byte("k4"), []byte("x")); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Put after Delete: %v, want %v", err, ErrBucketNotFound)
+	}
+	if err := bk.Delete([]byte("k3")); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Delete after Delete: %v, want %v", err, ErrBucketNotFound)
+	}
+}
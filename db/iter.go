@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+/* -- ITERATION -- */
+
+// ErrStop may be returned by the func passed to Map or Iterate to stop
+// scanning early. The scan then returns nil.
+var ErrStop = errors.New("stop scanning")
+
+// IterOptions paginate an iteration.
+type IterOptions struct {
+	// Offset is the number of key/value pairs skipped before the first one
+	// passed to the iteration's func.
+	Offset int
+	// Limit is the maximum number of key/value pairs passed to the
+	// iteration's func, or 0 for no limit.
+	Limit int
+}
+
+// iterate applies `do` on each key/value pair of the bucket at `path` from key
+// `seek` (or the first key, if nil) for as long as `in` accepts the keys.
+// Nested buckets are skipped.
+//
+// Keys and values passed to `do` are only valid until it returns.
+func iterate(ctx context.Context, db *DB, path [][]byte, seek []byte, in func(k []byte) bool,
+	opts IterOptions, do func(k, v []byte) error) error {
+	err := db.View(func(tx KVTx) error {
+		b := resolve(tx, path)
+		if b == nil {
+			return fmt.Errorf("bucket %s: %w", pathString(path), ErrBucketNotFound)
+		}
+		c := b.Cursor()
+		k, v := c.First()
+		if seek != nil {
+			k, v = c.Seek(seek)
+		}
+		skipped, done := 0, 0
+		for ; k != nil && in(k); k, v = c.Next() {
+			if v == nil {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if skipped < opts.Offset {
+				skipped++
+				continue
+			}
+			if opts.Limit > 0 && done >= opts.Limit {
+				return nil
+			}
+			if err := do(k, v); err != nil {
+				return err
+			}
+			done++
+		}
+		return nil
+	})
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+// all accepts every key.
+func all([]byte) bool {
+	return true
+}
+
+// collectItems returns an iteration func appending copies of each key/value
+// pair to `items`.
+func collectItems(items *[]Item) func(k, v []byte) error {
+	return func(k, v []byte) error {
+		*items = append(*items, Item{append([]byte(nil), k...), append([]byte(nil), v...)})
+		return nil
+	}
+}
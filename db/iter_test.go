@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// iterBucket returns a bucket holding keys a to f, with an empty value for
+// f, and a nested bucket, which iterations skip.
+func iterBucket(t *testing.T) *Bucket {
+	t.Helper()
+	bk, err := New(newMemKV()).Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		if err := bk.Put([]byte(k), []byte(k+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bk.Put([]byte("f"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bk.Bucket([]byte("c2")); err != nil {
+		t.Fatal(err)
+	}
+	return bk
+}
+
+// iterated returns the keys `s` passes its func with `opts`, space-separated.
+func iterated(t *testing.T, s interface {
+	Iterate(context.Context, IterOptions, func(k, v []byte) error) error
+}, opts IterOptions) string {
+	t.Helper()
+	var keys []string
+	err := s.Iterate(context.Background(), opts, func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(keys, " ")
+}
+
+func TestIteratePagination(t *testing.T) {
+	bk := iterBucket(t)
+	scanners := []struct {
+		name string
+		s    Scanner
+		all  string
+	}{
+		{"prefix", bk.NewPrefixScanner([]byte("c")), "c"},
+		{"empty prefix", bk.NewPrefixScanner(nil), "a b c d e f"},
+		{"range", bk.NewRangeScanner([]byte("b"), []byte("e")), "b c d e"},
+		{"empty range", bk.NewRangeScanner([]byte("x"), []byte("z")), ""},
+	}
+	pages := []struct {
+		opts IterOptions
+		// window selects the keys of the page among all of them
+		window func(keys []string) []string
+	}{
+		{IterOptions{}, func(keys []string) []string { return keys }},
+		{IterOptions{Limit: 0}, func(keys []string) []string { return keys }},
+		{IterOptions{Offset: 2}, func(keys []string) []string { return window(keys, 2, len(keys)) }},
+		{IterOptions{Limit: 2}, func(keys []string) []string { return window(keys, 0, 2) }},
+		{IterOptions{Offset: 1, Limit: 2}, func(keys []string) []string { return window(keys, 1, 3) }},
+		{IterOptions{Offset: 3, Limit: 100}, func(keys []string) []string { return window(keys, 3, len(keys)) }},
+		{IterOptions{Offset: 6}, func(keys []string) []string { return nil }},
+		{IterOptions{Offset: 100, Limit: 1}, func(keys []string) []string { return nil }},
+	}
+	for _, sc := range scanners {
+		keys := strings.Fields(sc.all)
+		for _, p := range pages {
+			want := strings.Join(p.window(keys), " ")
+			if got := iterated(t, sc.s, p.opts); got != want {
+				t.Errorf("%s with %+v: %q, want %q", sc.name, p.opts, got, want)
+			}
+		}
+	}
+	for _, p := range pages {
+		want := strings.Join(p.window(strings.Fields("a b c d e f")), " ")
+		if got := iterated(t, bk, p.opts); got != want {
+			t.Errorf("bucket with %+v: %q, want %q", p.opts, got, want)
+		}
+	}
+}
+
+// window returns keys[i:j], clamped to keys.
+func window(keys []string, i, j int) []string {
+	if j > len(keys) {
+		j = len(keys)
+	}
+	if i >= j {
+		return nil
+	}
+	return keys[i:j]
+}
+
+func TestIterateStops(t *testing.T) {
+	bk := iterBucket(t)
+	errBoom := errors.New("boom")
+	for _, c := range []struct {
+		name string
+		opts IterOptions
+		// the func returns ret when passed key stop
+		stop string
+		ret  error
+		want error
+		keys string
+	}{
+		{"error", IterOptions{}, "b", errBoom, errBoom, "a b"},
+		{"error on the first key", IterOptions{}, "a", errBoom, errBoom, "a"},
+		{"error after an offset", IterOptions{Offset: 2}, "d", errBoom, errBoom, "c d"},
+		{"error past the limit", IterOptions{Limit: 2}, "c", errBoom, nil, "a b"},
+		{"stop", IterOptions{}, "c", ErrStop, nil, "a b c"},
+	} {
+		var keys []string
+		err := bk.Iterate(context.Background(), c.opts, func(k, v []byte) error {
+			keys = append(keys, string(k))
+			if string(k) == c.stop {
+				return c.ret
+			}
+			return nil
+		})
+		if (err == nil) != (c.want == nil) || err != nil && err.Error() != c.want.Error() {
+			t.Errorf("%s: Iterate returned %v, want %v", c.name, err, c.want)
+		}
+		if got := strings.Join(keys, " "); got != c.keys {
+			t.Errorf("%s: iterated %q, want %q", c.name, got, c.keys)
+		}
+	}
+
+	// Canceling the context stops the iteration with its error.
+	ctx, cancel := context.WithCancel(context.Background())
+	var keys []string
+	err := bk.NewRangeScanner([]byte("a"), []byte("z")).Iterate(ctx, IterOptions{}, func(k, v []byte) error {
+		keys = append(keys, string(k))
+		if string(k) == "b" {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || strings.Join(keys, " ") != "a b" {
+		t.Errorf("canceled iteration returned %v after %q, want %v after a b", err, keys, context.Canceled)
+	}
+	if err := bk.Iterate(ctx, IterOptions{}, func(k, v []byte) error {
+		t.Errorf("iterated %q with a canceled context", k)
+		return nil
+	}); err != context.Canceled {
+		t.Errorf("iteration with a canceled context returned %v", err)
+	}
+}
+
+func TestIterateMissingBucket(t *testing.T) {
+	bk := iterBucket(t)
+	if err := bk.db.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bk.Items(); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Items of a deleted bucket: %v, want %v", err, ErrBucketNotFound)
+	}
+}
+
+func TestScannerResults(t *testing.T) {
+	bk := iterBucket(t)
+	s := bk.NewRangeScanner([]byte("d"), []byte("z"))
+	items, err := s.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, string(item.Key)+"="+string(item.Value))
+	}
+	// Empty values aren't mistaken for nested buckets.
+	if want := []string{"d=dd", "e=ee", "f="}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Items = %q, want %q", got, want)
+	}
+	if n, err := s.Count(); err != nil || n != 3 {
+		t.Errorf("Count = %d, %v, want 3", n, err)
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+/* -- KEY ENCODERS -- */
+
+// A KeyEncoder converts keys of type K to and from bucket keys. Encoders
+// preserve order: the byte-wise ordering of encoded keys matches the
+// natural ordering of K, so range scans over encoded keys work as expected.
+type KeyEncoder[K any] interface {
+	EncodeKey(k K) []byte
+	DecodeKey(data []byte) (K, error)
+}
+
+// StringKeys encodes string keys as their bytes.
+type StringKeys struct{}
+
+func (StringKeys) EncodeKey(k string) []byte {
+	return []byte(k)
+}
+
+func (StringKeys) DecodeKey(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// IntKeys encodes integer keys as 8 big-endian bytes. The sign bit of signed
+// integers is flipped, so that negative keys sort before positive ones.
+type IntKeys[K constraints.Integer] struct{}
+
+// signed reports whether K is a signed integer type.
+func (IntKeys[K]) signed() bool {
+	var zero K
+	return zero-1 < zero
+}
+
+func (e IntKeys[K]) EncodeKey(k K) []byte {
+	data := make([]byte, 8)
+	u := uint64(k)
+	if e.signed() {
+		u ^= 1 << 63
+	}
+	binary.BigEndian.PutUint64(data, u)
+	return data
+}
+
+func (e IntKeys[K]) DecodeKey(data []byte) (K, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("integer key %q isn't 8 bytes long", data)
+	}
+	u := binary.BigEndian.Uint64(data)
+	if e.signed() {
+		u ^= 1 << 63
+	}
+	return K(u), nil
+}
+
+// TimeKeys encodes timestamps as 12 big-endian bytes: the seconds since the
+// Unix epoch with their sign bit flipped, then the nanoseconds. Keys are
+// decoded in UTC; their original time zone isn't kept.
+type TimeKeys struct{}
+
+func (TimeKeys) EncodeKey(k time.Time) []byte {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint64(data, uint64(k.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(data[8:], uint32(k.Nanosecond()))
+	return data
+}
+
+func (TimeKeys) DecodeKey(data []byte) (time.Time, error) {
+	if len(data) != 12 {
+		return time.Time{}, fmt.Errorf("time key %q isn't 12 bytes long", data)
+	}
+	secs := int64(binary.BigEndian.Uint64(data) ^ (1 << 63))
+	nsecs := binary.BigEndian.Uint32(data[8:])
+	if nsecs >= uint32(time.Second) {
+		return time.Time{}, fmt.Errorf("time key %q has invalid nanoseconds", data)
+	}
+	return time.Unix(secs, int64(nsecs)).UTC(), nil
+}
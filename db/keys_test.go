@@ -0,0 +1,195 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// checkOrder checks that keys, sorted in their natural order, encode to
+// increasing byte strings, and decode back to themselves.
+func checkOrder[K any](t *testing.T, enc KeyEncoder[K], keys []K, equal func(a, b K) bool) {
+	t.Helper()
+	var prev []byte
+	for i, k := range keys {
+		data := enc.EncodeKey(k)
+		if i > 0 && bytes.Compare(prev, data) >= 0 {
+			t.Errorf("key %v encodes to %x, not after %v's %x", k, data, keys[i-1], prev)
+		}
+		got, err := enc.DecodeKey(data)
+		if err != nil || !equal(got, k) {
+			t.Errorf("key %v decodes to %v, %v", k, got, err)
+		}
+		prev = data
+	}
+}
+
+func eq[K comparable](a, b K) bool { return a == b }
+
+func TestIntKeys(t *testing.T) {
+	checkOrder[int64](t, IntKeys[int64]{}, []int64{math.MinInt64, math.MinInt64 + 1, -1 << 40, -256, -1, 0, 1, 255, 1 << 40, math.MaxInt64}, eq[int64])
+	checkOrder[int](t, IntKeys[int]{}, []int{-1000, -1, 0, 1, 1000}, eq[int])
+	checkOrder[int8](t, IntKeys[int8]{}, []int8{math.MinInt8, -1, 0, 1, math.MaxInt8}, eq[int8])
+	checkOrder[uint64](t, IntKeys[uint64]{}, []uint64{0, 1, 1 << 63, math.MaxUint64}, eq[uint64])
+	checkOrder[uint16](t, IntKeys[uint16]{}, []uint16{0, 1, 1 << 15, math.MaxUint16}, eq[uint16])
+
+	for _, data := range [][]byte{nil, make([]byte, 7), make([]byte, 9)} {
+		if k, err := (IntKeys[int]{}).DecodeKey(data); err == nil {
+			t.Errorf("DecodeKey(%x) = %d, want an error", data, k)
+		}
+	}
+}
+
+func TestTimeKeys(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	checkOrder[time.Time](t, TimeKeys{}, []time.Time{
+		at("0001-01-01T00:00:00Z"),
+		at("1903-12-31T23:59:59.999999999Z"),
+		at("1969-12-31T23:59:59Z"),
+		at("1969-12-31T23:59:59.5Z"),
+		at("1970-01-01T00:00:00Z"),
+		at("1970-01-01T00:00:00.000000001Z"),
+		at("2022-03-04T05:06:07.5+09:00"),
+		at("2022-03-04T05:06:07Z"),
+		at("9999-12-31T23:59:59.999999999Z"),
+	}, time.Time.Equal)
+
+	// Keys decode in UTC.
+	k := at("2022-03-04T05:06:07.5+09:00")
+	got, err := TimeKeys{}.DecodeKey(TimeKeys{}.EncodeKey(k))
+	if err != nil || got.Location() != time.UTC || !got.Equal(k) {
+		t.Errorf("decoded %v, %v, want %v in UTC", got, err, k)
+	}
+
+	bad := TimeKeys{}.EncodeKey(k)
+	bad[8] = 0xFF
+	for _, data := range [][]byte{nil, make([]byte, 8), bad} {
+		if k, err := (TimeKeys{}).DecodeKey(data); err == nil {
+			t.Errorf("DecodeKey(%x) = %v, want an error", data, k)
+		}
+	}
+}
+
+func TestStringKeys(t *testing.T) {
+	keys := []string{"", "\x00", "a", "a\x00", "ab", "b", "\xff"}
+	checkOrder[string](t, StringKeys{}, keys, eq[string])
+}
+
+func TestTypedBucketOrder(t *testing.T) {
+	bk, err := New(newMemKV()).Bucket([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb := NewTypedBucket[int, string](bk, IntKeys[int]{}, JSONCodec[string]{})
+	keys := []int{5, -3, 0, math.MinInt, 42, -1}
+	for _, k := range keys {
+		if err := tb.Put(k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []int
+	err = tb.MapRange(func(k int, v string) error {
+		got = append(got, k)
+		return nil
+	}, -3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{-3, -1, 0, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("range [-3, 5] holds %v, want %v", got, want)
+	}
+	items, err := tb.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, item := range items {
+		got = append(got, item.Key)
+	}
+	sort.Ints(keys)
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("bucket holds %v, want %v", got, keys)
+	}
+}
+
+type record struct {
+	Name  string
+	Size  int64
+	Tags  []string
+	Extra map[string]string
+}
+
+// message is a Message encoding a string as its bytes.
+type message struct {
+	s string
+}
+
+func (m *message) Marshal() ([]byte, error) {
+	if m.s == "" {
+		return nil, errors.New("empty message")
+	}
+	return []byte(m.s), nil
+}
+
+func (m *message) Unmarshal(data []byte) error {
+	m.s = string(data)
+	return nil
+}
+
+// roundTrip checks that v survives being encoded and decoded by c.
+func roundTrip[V any](t *testing.T, name string, c Codec[V], v V) {
+	t.Helper()
+	data, err := c.Encode(v)
+	if err != nil {
+		t.Fatalf("%s: encoding %v: %v", name, v, err)
+	}
+	got, err := c.Decode(data)
+	if err != nil {
+		t.Fatalf("%s: decoding %v: %v", name, v, err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("%s: %+v round-trips to %+v", name, v, got)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	r := record{Name: "a.jpg", Size: -1, Tags: []string{"x", "y"}, Extra: map[string]string{"k": "v"}}
+	roundTrip[record](t, "json", JSONCodec[record]{}, r)
+	roundTrip[record](t, "gob", GobCodec[record]{}, r)
+	roundTrip[record](t, "msgpack", MsgpackCodec[record]{}, r)
+	roundTrip[*record](t, "json pointer", JSONCodec[*record]{}, &r)
+	roundTrip[int64](t, "msgpack int", MsgpackCodec[int64]{}, math.MinInt64)
+	roundTrip[*message](t, "message", MessageCodec[message, *message]{}, &message{"hello"})
+	roundTrip[[]byte](t, "bytes", BytesCodec{}, []byte("\x00raw\xff"))
+
+	if _, err := (MessageCodec[message, *message]{}).Encode(nil); err == nil {
+		t.Error("encoded a nil message")
+	}
+	if _, err := (MessageCodec[message, *message]{}).Encode(&message{}); err == nil {
+		t.Error("encoding error of a message lost")
+	}
+	if _, err := (JSONCodec[record]{}).Decode([]byte("{")); err == nil {
+		t.Error("decoded truncated JSON")
+	}
+	if _, err := (GobCodec[record]{}).Decode([]byte("junk")); err == nil {
+		t.Error("decoded junk gob")
+	}
+
+	// Decoded byte slices don't alias the stored ones.
+	data := []byte("stored")
+	got, _ := BytesCodec{}.Decode(data)
+	data[0] = 'X'
+	if string(got) != "stored" {
+		t.Errorf("decoded bytes changed to %q with the stored ones", got)
+	}
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+/* -- KEY/VALUE BACKEND -- */
+
+// A KV is an ordered, transactional key/value store holding nested buckets,
+// which a DB is built on. Open returns a DB backed by a bbolt file, and
+// OpenMemory one held in memory.
+//
+// Keys and values read through a KV are only valid for the life of the
+// transaction they were read in.
+type KV interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(tx KVTx) error) error
+	// Update runs fn in a read-write transaction, committed if fn returns
+	// nil and rolled back otherwise.
+	Update(fn func(tx KVTx) error) error
+	// Batch is Update for callers in many goroutines, whose transactions
+	// the KV may combine. fn may run more than once.
+	Batch(fn func(tx KVTx) error) error
+	// Path returns the file holding the store, or "" if there's none.
+	Path() string
+	Close() error
+}
+
+// A KVContainer holds buckets: a transaction's top level, or a bucket.
+type KVContainer interface {
+	// Bucket returns the named bucket, or nil if there's none.
+	Bucket(name []byte) KVBucket
+	CreateBucket(name []byte) (KVBucket, error)
+	CreateBucketIfNotExists(name []byte) (KVBucket, error)
+	DeleteBucket(name []byte) error
+	// ForEachBucket calls fn with the name of each bucket directly within
+	// the container, in order.
+	ForEachBucket(fn func(name []byte) error) error
+}
+
+// A KVTx is a transaction.
+type KVTx interface {
+	KVContainer
+	Writable() bool
+}
+
+// A KVBucket is a collection of key/value pairs and nested buckets, sorted
+// by key.
+type KVBucket interface {
+	KVContainer
+	// Get returns the value of key k, or nil if there's none or k names a
+	// nested bucket.
+	Get(k []byte) []byte
+	Put(k, v []byte) error
+	Delete(k []byte) error
+	// ForEach calls fn with each key/value pair, in key order. Nested
+	// buckets have nil values.
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() KVCursor
+}
+
+// A KVCursor moves over a bucket's keys in order. Its methods return nil
+// keys past the last key, and nil values for nested buckets.
+type KVCursor interface {
+	First() (k, v []byte)
+	Next() (k, v []byte)
+	// Seek moves to key `seek`, or the key following it if it doesn't exist.
+	Seek(seek []byte) (k, v []byte)
+}
+
+// Errors returned by every KV, whichever implementation.
+var (
+	ErrBucketNotFound    = bolt.ErrBucketNotFound
+	ErrBucketExists      = bolt.ErrBucketExists
+	ErrIncompatibleValue = bolt.ErrIncompatibleValue
+	ErrTxNotWritable     = bolt.ErrTxNotWritable
+	ErrKeyRequired       = bolt.ErrKeyRequired
+	ErrDatabaseNotOpen   = bolt.ErrDatabaseNotOpen
+)
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+/* -- BBOLT BACKEND -- */
+
+// boltKV is a KV backed by a bbolt database file.
+type boltKV struct {
+	db *bolt.DB
+}
+
+func (b boltKV) View(fn func(tx KVTx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b boltKV) Update(fn func(tx KVTx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b boltKV) Batch(fn func(tx KVTx) error) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (b boltKV) Path() string {
+	return b.db.Path()
+}
+
+func (b boltKV) Close() error {
+	return b.db.Close()
+}
+
+// WriteTo streams a consistent copy of the database file to w.
+func (b boltKV) WriteTo(w io.Writer) (n int64, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// SetMaxBatchSize sets the most calls to Batch combined in a transaction.
+func (b boltKV) SetMaxBatchSize(n int) {
+	b.db.MaxBatchSize = n
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Bucket(name []byte) KVBucket {
+	return wrapBoltBucket(t.tx.Bucket(name))
+}
+
+func (t boltTx) CreateBucket(name []byte) (KVBucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	return wrapBoltBucket(b), err
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	return wrapBoltBucket(b), err
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	return t.tx.DeleteBucket(name)
+}
+
+func (t boltTx) ForEachBucket(fn func(name []byte) error) error {
+	return t.tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		return fn(name)
+	})
+}
+
+func (t boltTx) Writable() bool {
+	return t.tx.Writable()
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+// wrapBoltBucket wraps b, keeping a nil b nil rather than a non-nil KVBucket
+// holding a nil pointer.
+func wrapBoltBucket(b *bolt.Bucket) KVBucket {
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (b boltBucket) Get(k []byte) []byte {
+	return b.b.Get(k)
+}
+
+func (b boltBucket) Put(k, v []byte) error {
+	return b.b.Put(k, v)
+}
+
+func (b boltBucket) Delete(k []byte) error {
+	return b.b.Delete(k)
+}
+
+func (b boltBucket) Bucket(name []byte) KVBucket {
+	return wrapBoltBucket(b.b.Bucket(name))
+}
+
+func (b boltBucket) CreateBucket(name []byte) (KVBucket, error) {
+	nb, err := b.b.CreateBucket(name)
+	return wrapBoltBucket(nb), err
+}
+
+func (b boltBucket) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	nb, err := b.b.CreateBucketIfNotExists(name)
+	return wrapBoltBucket(nb), err
+}
+
+func (b boltBucket) DeleteBucket(name []byte) error {
+	return b.b.DeleteBucket(name)
+}
+
+func (b boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b boltBucket) ForEachBucket(fn func(name []byte) error) error {
+	return b.b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(k)
+	})
+}
+
+func (b boltBucket) Cursor() KVCursor {
+	return b.b.Cursor()
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+/* -- MEMORY BACKEND -- */
+
+// memKV is a KV held in memory, for tests and runs whose results needn't
+// outlive the process. Transactions are serialized like bolt's: any number of
+// readers, or a single writer. A writer keeps a log of how to undo its
+// changes, replayed backwards to roll it back.
+type memKV struct {
+	mu     sync.RWMutex
+	root   *memBucket
+	closed bool
+}
+
+func newMemKV() *memKV {
+	return &memKV{root: newMemBucket()}
+}
+
+func (m *memKV) View(fn func(tx KVTx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrDatabaseNotOpen
+	}
+	return fn(&memTx{root: m.root})
+}
+
+func (m *memKV) Update(fn func(tx KVTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrDatabaseNotOpen
+	}
+	tx := &memTx{root: m.root, writable: true}
+	err := fn(tx)
+	if err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+	}
+	return err
+}
+
+// Batch has nothing to gain from combining transactions held in memory.
+func (m *memKV) Batch(fn func(tx KVTx) error) error {
+	return m.Update(fn)
+}
+
+func (m *memKV) Path() string {
+	return ""
+}
+
+func (m *memKV) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.root = nil
+	return nil
+}
+
+// A memBucket holds its keys sorted, each mapped to a value or a nested
+// bucket.
+type memBucket struct {
+	keys    [][]byte
+	entries map[string]*memEntry
+}
+
+type memEntry struct {
+	value  []byte
+	bucket *memBucket
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{entries: map[string]*memEntry{}}
+}
+
+// search returns the position of the first key not less than k.
+func (b *memBucket) search(k []byte) int {
+	return sort.Search(len(b.keys), func(i int) bool {
+		return bytes.Compare(b.keys[i], k) >= 0
+	})
+}
+
+// set maps key k to e, or removes k if e is nil.
+func (b *memBucket) set(k []byte, e *memEntry) {
+	i := b.search(k)
+	found := i < len(b.keys) && bytes.Equal(b.keys[i], k)
+	switch {
+	case e == nil && found:
+		b.keys = append(b.keys[:i], b.keys[i+1:]...)
+		delete(b.entries, string(k))
+	case e == nil:
+	case found:
+		b.entries[string(k)] = e
+	default:
+		k = append([]byte(nil), k...)
+		b.keys = append(b.keys, nil)
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = k
+		b.entries[string(k)] = e
+	}
+}
+
+// memTx is a transaction over the top-level bucket of a memKV.
+type memTx struct {
+	root     *memBucket
+	writable bool
+	undo     []func()
+}
+
+// set maps key k of bucket b to e, logging how to undo it.
+func (tx *memTx) set(b *memBucket, k []byte, e *memEntry) error {
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	if len(k) == 0 {
+		return ErrKeyRequired
+	}
+	old := b.entries[string(k)]
+	k = append([]byte(nil), k...)
+	b.set(k, e)
+	tx.undo = append(tx.undo, func() { b.set(k, old) })
+	return nil
+}
+
+func (tx *memTx) Writable() bool {
+	return tx.writable
+}
+
+func (tx *memTx) Bucket(name []byte) KVBucket {
+	return tx.bucket(tx.root).Bucket(name)
+}
+
+func (tx *memTx) CreateBucket(name []byte) (KVBucket, error) {
+	return tx.bucket(tx.root).CreateBucket(name)
+}
+
+func (tx *memTx) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	return tx.bucket(tx.root).CreateBucketIfNotExists(name)
+}
+
+func (tx *memTx) DeleteBucket(name []byte) error {
+	return tx.bucket(tx.root).DeleteBucket(name)
+}
+
+func (tx *memTx) ForEachBucket(fn func(name []byte) error) error {
+	return tx.bucket(tx.root).ForEachBucket(fn)
+}
+
+func (tx *memTx) bucket(b *memBucket) *memTxBucket {
+	return &memTxBucket{tx, b}
+}
+
+// memTxBucket is a memBucket bound to a transaction.
+type memTxBucket struct {
+	tx *memTx
+	b  *memBucket
+}
+
+func (b *memTxBucket) Get(k []byte) []byte {
+	if e := b.b.entries[string(k)]; e != nil {
+		return e.value
+	}
+	return nil
+}
+
+func (b *memTxBucket) Put(k, v []byte) error {
+	if e := b.b.entries[string(k)]; e != nil && e.bucket != nil {
+		return ErrIncompatibleValue
+	}
+	// an empty value must still read back non-nil
+	return b.tx.set(b.b, k, &memEntry{value: append([]byte{}, v...)})
+}
+
+func (b *memTxBucket) Delete(k []byte) error {
+	e := b.b.entries[string(k)]
+	if e == nil {
+		return nil
+	}
+	if e.bucket != nil {
+		return ErrIncompatibleValue
+	}
+	return b.tx.set(b.b, k, nil)
+}
+
+func (b *memTxBucket) Bucket(name []byte) KVBucket {
+	if e := b.b.entries[string(name)]; e != nil && e.bucket != nil {
+		return b.tx.bucket(e.bucket)
+	}
+	return nil
+}
+
+func (b *memTxBucket) CreateBucket(name []byte) (KVBucket, error) {
+	if e := b.b.entries[string(name)]; e != nil {
+		if e.bucket != nil {
+			return nil, ErrBucketExists
+		}
+		return nil, ErrIncompatibleValue
+	}
+	nb := newMemBucket()
+	if err := b.tx.set(b.b, name, &memEntry{bucket: nb}); err != nil {
+		return nil, err
+	}
+	return b.tx.bucket(nb), nil
+}
+
+func (b *memTxBucket) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	if nb := b.Bucket(name); nb != nil {
+		return nb, nil
+	}
+	return b.CreateBucket(name)
+}
+
+func (b *memTxBucket) DeleteBucket(name []byte) error {
+	e := b.b.entries[string(name)]
+	if e == nil {
+		return ErrBucketNotFound
+	}
+	if e.bucket == nil {
+		return ErrIncompatibleValue
+	}
+	return b.tx.set(b.b, name, nil)
+}
+
+func (b *memTxBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memTxBucket) ForEachBucket(fn func(name []byte) error) error {
+	return b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(k)
+	})
+}
+
+func (b *memTxBucket) Cursor() KVCursor {
+	return &memCursor{b: b.b}
+}
+
+// memCursor remembers the last key it returned rather than a position, so
+// that it keeps its place while its bucket is written to.
+type memCursor struct {
+	b    *memBucket
+	last []byte
+}
+
+func (c *memCursor) First() (k, v []byte) {
+	return c.at(0)
+}
+
+func (c *memCursor) Next() (k, v []byte) {
+	if c.last == nil {
+		return nil, nil
+	}
+	i := c.b.search(c.last)
+	if i < len(c.b.keys) && bytes.Equal(c.b.keys[i], c.last) {
+		i++
+	}
+	return c.at(i)
+}
+
+func (c *memCursor) Seek(seek []byte) (k, v []byte) {
+	return c.at(c.b.search(seek))
+}
+
+func (c *memCursor) at(i int) (k, v []byte) {
+	if i >= len(c.b.keys) {
+		c.last = nil
+		return nil, nil
+	}
+	k = c.b.keys[i]
+	c.last = k
+	return k, c.b.entries[string(k)].value
+}
@@ -0,0 +1,380 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// kvs opens an empty KV of each implementation, closed when the test ends.
+var kvs = []struct {
+	name string
+	open func(t *testing.T) KV
+}{
+	{"bolt", func(t *testing.T) KV {
+		d, err := Open(filepath.Join(t.TempDir(), "kv.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		return d.KV
+	}},
+	{"memory", func(t *testing.T) KV {
+		kv := newMemKV()
+		t.Cleanup(func() { kv.Close() })
+		return kv
+	}},
+}
+
+// testKVs runs a conformance test on every KV implementation.
+func testKVs(t *testing.T, test func(t *testing.T, kv KV)) {
+	for _, impl := range kvs {
+		t.Run(impl.name, func(t *testing.T) {
+			test(t, impl.open(t))
+		})
+	}
+}
+
+// update runs fn in a read-write transaction, failing the test on error.
+func update(t *testing.T, kv KV, fn func(tx KVTx) error) {
+	t.Helper()
+	if err := kv.Update(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dump returns the contents of bucket b, nested buckets included, as
+// "k=v" items and "k/" for buckets.
+func dump(b KVBucket) []string {
+	var items []string
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			items = append(items, string(k)+"/")
+			for _, item := range dump(b.Bucket(k)) {
+				items = append(items, string(k)+"/"+item)
+			}
+		} else {
+			items = append(items, fmt.Sprintf("%s=%s", k, v))
+		}
+		return nil
+	})
+	return items
+}
+
+func TestKVCursor(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		keys := []string{"m", "a", "zz", "b\x00", "b", "\x00", "\xff", "ab"}
+		update(t, kv, func(tx KVTx) error {
+			b, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := b.Put([]byte(k), []byte("v"+k)); err != nil {
+					return err
+				}
+			}
+			_, err = b.CreateBucket([]byte("n"))
+			return err
+		})
+		err := kv.View(func(tx KVTx) error {
+			c := tx.Bucket([]byte("b")).Cursor()
+			var got []string
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				got = append(got, fmt.Sprintf("%q=%q", k, v))
+			}
+			want := []string{`"\x00"="v\x00"`, `"a"="va"`, `"ab"="vab"`, `"b"="vb"`, `"b\x00"="vb\x00"`,
+				`"m"="vm"`, `"n"=""`, `"zz"="vzz"`, `"\xff"="v\xff"`}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("cursor walked %s, want %s", got, want)
+			}
+			if k, _ := c.Next(); k != nil {
+				t.Errorf("cursor went past the end to %q", k)
+			}
+
+			for _, s := range []struct{ seek, want string }{
+				{"", "\x00"}, {"a", "a"}, {"aa", "ab"}, {"b\x00\x00", "m"}, {"n", "n"}, {"zzz", "\xff"},
+			} {
+				if k, _ := c.Seek([]byte(s.seek)); string(k) != s.want {
+					t.Errorf("Seek(%q) = %q, want %q", s.seek, k, s.want)
+				}
+			}
+			if k, v := c.Seek([]byte("n")); v != nil {
+				t.Errorf("nested bucket %q has value %q", k, v)
+			}
+			if k, _ := c.Seek([]byte("\xff\xff")); k != nil {
+				t.Errorf("Seek past the last key = %q", k)
+			}
+			if k, _ := c.First(); string(k) != "\x00" {
+				t.Errorf("First after the end = %q", k)
+			}
+			if k, _ := c.Next(); string(k) != "a" {
+				t.Errorf("Next after First = %q", k)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestKVValues(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		update(t, kv, func(tx KVTx) error {
+			b, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("empty"), nil); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("k"), []byte("v1")); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("k"), []byte("v2")); err != nil {
+				return err
+			}
+			if err := b.Delete([]byte("missing")); err != nil {
+				return err
+			}
+			if err := b.Put(nil, []byte("v")); !errors.Is(err, ErrKeyRequired) {
+				t.Errorf("Put with an empty key: %v, want %v", err, ErrKeyRequired)
+			}
+			return nil
+		})
+		err := kv.View(func(tx KVTx) error {
+			b := tx.Bucket([]byte("b"))
+			if v := b.Get([]byte("empty")); v == nil || len(v) != 0 {
+				t.Errorf("empty value reads back as %#v", v)
+			}
+			if v := b.Get([]byte("k")); string(v) != "v2" {
+				t.Errorf("overwritten value reads back as %q", v)
+			}
+			if v := b.Get([]byte("missing")); v != nil {
+				t.Errorf("missing key reads back as %q", v)
+			}
+			if err := b.Put([]byte("k"), []byte("v")); !errors.Is(err, ErrTxNotWritable) {
+				t.Errorf("Put in a read-only transaction: %v, want %v", err, ErrTxNotWritable)
+			}
+			if _, err := tx.CreateBucket([]byte("c")); !errors.Is(err, ErrTxNotWritable) {
+				t.Errorf("CreateBucket in a read-only transaction: %v, want %v", err, ErrTxNotWritable)
+			}
+			if tx.Writable() {
+				t.Error("read-only transaction is writable")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestKVNestedBuckets(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		update(t, kv, func(tx KVTx) error {
+			for _, name := range []string{"z", "a", "m"} {
+				if _, err := tx.CreateBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+			a := tx.Bucket([]byte("a"))
+			if err := a.Put([]byte("v"), []byte("1")); err != nil {
+				return err
+			}
+			deep, err := a.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			if deep, err = deep.CreateBucketIfNotExists([]byte("c")); err != nil {
+				return err
+			}
+			if err := deep.Put([]byte("k"), []byte("deep")); err != nil {
+				return err
+			}
+			again, err := a.CreateBucketIfNotExists([]byte("b"))
+			if err != nil || again.Bucket([]byte("c")) == nil {
+				t.Errorf("CreateBucketIfNotExists of an existing bucket: %v", err)
+			}
+
+			for _, c := range []struct {
+				name string
+				err  error
+				want error
+			}{
+				{"CreateBucket of a bucket", errOf(tx.CreateBucket([]byte("a"))), ErrBucketExists},
+				{"CreateBucket of a value", errOf(a.CreateBucket([]byte("v"))), ErrIncompatibleValue},
+				{"CreateBucketIfNotExists of a value", errOf(a.CreateBucketIfNotExists([]byte("v"))), ErrIncompatibleValue},
+				{"Put over a bucket", a.Put([]byte("b"), []byte("x")), ErrIncompatibleValue},
+				{"Delete of a bucket", a.Delete([]byte("b")), ErrIncompatibleValue},
+				{"DeleteBucket of a value", a.DeleteBucket([]byte("v")), ErrIncompatibleValue},
+				{"DeleteBucket of a missing bucket", tx.DeleteBucket([]byte("missing")), ErrBucketNotFound},
+			} {
+				if !errors.Is(c.err, c.want) {
+					t.Errorf("%s: %v, want %v", c.name, c.err, c.want)
+				}
+			}
+			if a.Get([]byte("b")) != nil {
+				t.Error("nested bucket has a value")
+			}
+			if tx.Bucket([]byte("missing")) != nil || a.Bucket([]byte("v")) != nil {
+				t.Error("got a bucket for a missing bucket or a value")
+			}
+			return nil
+		})
+		err := kv.View(func(tx KVTx) error {
+			var names []string
+			tx.ForEachBucket(func(name []byte) error {
+				names = append(names, string(name))
+				return nil
+			})
+			if want := []string{"a", "m", "z"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("top-level buckets %s, want %s", names, want)
+			}
+			names = nil
+			tx.Bucket([]byte("a")).ForEachBucket(func(name []byte) error {
+				names = append(names, string(name))
+				return nil
+			})
+			if want := []string{"b"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("buckets of a %s, want %s", names, want)
+			}
+			if got, want := dump(tx.Bucket([]byte("a"))), []string{"b/", "b/c/", "b/c/k=deep", "v=1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("a holds %s, want %s", got, want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Deleting a bucket deletes what it holds.
+		update(t, kv, func(tx KVTx) error {
+			if err := tx.Bucket([]byte("a")).DeleteBucket([]byte("b")); err != nil {
+				return err
+			}
+			b, err := tx.Bucket([]byte("a")).CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			if got := dump(b); len(got) != 0 {
+				t.Errorf("recreated bucket holds %s", got)
+			}
+			return nil
+		})
+	})
+}
+
+func errOf(_ KVBucket, err error) error {
+	return err
+}
+
+func TestKVRollback(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		update(t, kv, func(tx KVTx) error {
+			b, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("k"), []byte("v")); err != nil {
+				return err
+			}
+			_, err = b.CreateBucket([]byte("n"))
+			return err
+		})
+		boom := errors.New("boom")
+		err := kv.Update(func(tx KVTx) error {
+			b := tx.Bucket([]byte("b"))
+			b.Put([]byte("k"), []byte("changed"))
+			b.Put([]byte("new"), []byte("v"))
+			b.DeleteBucket([]byte("n"))
+			nb, _ := b.CreateBucket([]byte("n2"))
+			nb.Put([]byte("k"), []byte("v"))
+			tx.CreateBucket([]byte("c"))
+			if got := dump(b); !reflect.DeepEqual(got, []string{"k=changed", "n2/", "n2/k=v", "new=v"}) {
+				t.Errorf("transaction doesn't see its own writes: %s", got)
+			}
+			return boom
+		})
+		if err != boom {
+			t.Fatalf("Update returned %v, want %v", err, boom)
+		}
+		err = kv.View(func(tx KVTx) error {
+			if got, want := dump(tx.Bucket([]byte("b"))), []string{"k=v", "n/"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("rolled back bucket holds %s, want %s", got, want)
+			}
+			if tx.Bucket([]byte("c")) != nil {
+				t.Error("bucket created by a rolled back transaction")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestKVScans(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		d := New(kv)
+		bk, err := d.BucketPath([]byte("p"), []byte("root"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a/1", "a/2", "a/3", "a0", "b/1", "b/2", "c"} {
+			if err := bk.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := bk.Bucket([]byte("a/nested")); err != nil {
+			t.Fatal(err)
+		}
+		keys := func(items []Item, err error) string {
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ks [][]byte
+			for _, item := range items {
+				ks = append(ks, item.Key)
+			}
+			return string(bytes.Join(ks, []byte(" ")))
+		}
+		for _, c := range []struct {
+			name, got, want string
+		}{
+			{"items", keys(bk.Items()), "a/1 a/2 a/3 a0 b/1 b/2 c"},
+			{"prefix a/", keys(bk.PrefixItems([]byte("a/"))), "a/1 a/2 a/3"},
+			{"prefix b", keys(bk.PrefixItems([]byte("b"))), "b/1 b/2"},
+			{"prefix missing", keys(bk.PrefixItems([]byte("x"))), ""},
+			{"range", keys(bk.RangeItems([]byte("a/2"), []byte("b/1"))), "a/2 a/3 a0 b/1"},
+			{"range between keys", keys(bk.RangeItems([]byte("a/25"), []byte("a05"))), "a/3 a0"},
+			{"range past the end", keys(bk.RangeItems([]byte("b/2"), []byte("z"))), "b/2 c"},
+			{"empty range", keys(bk.RangeItems([]byte("d"), []byte("e"))), ""},
+		} {
+			if c.got != c.want {
+				t.Errorf("%s: got %q, want %q", c.name, c.got, c.want)
+			}
+		}
+
+		var page []string
+		err = bk.NewPrefixScanner([]byte("a")).Iterate(context.Background(), IterOptions{Offset: 1, Limit: 2}, func(k, v []byte) error {
+			page = append(page, string(k))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a/2", "a/3"}; !reflect.DeepEqual(page, want) {
+			t.Errorf("page of prefix a: %s, want %s", page, want)
+		}
+		n, err := bk.NewRangeScanner([]byte("a0"), []byte("c")).Count()
+		if err != nil || n != 4 {
+			t.Errorf("range [a0, c] counts %d, %v, want 4", n, err)
+		}
+	})
+}
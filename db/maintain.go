@@ -0,0 +1,157 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/exp/slog"
+)
+
+/* -- MAINTENANCE -- */
+
+// ErrNotSupported is returned for maintenance a database's KV can't do.
+var ErrNotSupported = errors.New("not supported by this database")
+
+// WriteTo streams a consistent copy of the database to w, from a read
+// transaction, so the database stays usable while it's written. Only
+// databases backed by a file can be copied.
+func (db *DB) WriteTo(w io.Writer) (n int64, err error) {
+	wt, ok := db.KV.(io.WriterTo)
+	if !ok {
+		return 0, fmt.Errorf("writing a copy: %w", ErrNotSupported)
+	}
+	return wt.WriteTo(w)
+}
+
+// Backup writes a consistent copy of the database to the file at `path`.
+func (db *DB) Backup(path string) (err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	if _, err = db.WriteTo(f); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Restore replaces the database file at `path` with a copy of the backup at
+// `backup`, after checking that the backup is a readable database. The file
+// being replaced is kept next to it, and its name returned, unless there
+// wasn't any. The database must not be open.
+func Restore(path, backup string) (previous string, err error) {
+	if err := Verify(backup); err != nil {
+		return "", fmt.Errorf("%s isn't a usable backup: %s", backup, err)
+	}
+	src, err := os.Open(backup)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		previous = fmt.Sprintf("%s.pre-restore.%s.bak", path, time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(path, previous); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return previous, err
+	}
+	return previous, nil
+}
+
+// Verify opens the database file at `path` read-only and checks the
+// consistency of its pages.
+func Verify(path string) error {
+	// bolt would create a missing file, and then fail to initialize it
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	d, err := bolt.Open(path, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	})
+}
+
+// Compact rewrites the database file at `path` into a fresh file holding
+// only live pages, then replaces it, reclaiming the space of deleted records.
+// It returns the sizes of the file before and after. The database must not
+// be open.
+func Compact(path string, txMaxSize int64) (before, after int64, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = fi.Size()
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, 0, fmt.Errorf("couldn't open %s: %s", path, err)
+	}
+	defer src.Close()
+	tmp := path + ".compacting"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0600, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	slog.Debug("compacting bolt db", "path", path, "tmp", tmp)
+	if err := bolt.Compact(dst, src, txMaxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	if fi, err = os.Stat(tmp); err != nil {
+		return 0, 0, err
+	}
+	after = fi.Size()
+	if err := src.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// dumpDB returns the contents of every bucket of d, as dump formats them,
+// prefixed with their top-level bucket.
+func dumpDB(t *testing.T, d *DB) []string {
+	t.Helper()
+	var items []string
+	err := d.View(func(tx KVTx) error {
+		return tx.ForEachBucket(func(name []byte) error {
+			items = append(items, string(name)+"/")
+			for _, item := range dump(tx.Bucket(name)) {
+				items = append(items, string(name)+"/"+item)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+// fillDB stores n records in each of a top-level bucket, and a bucket nested
+// two deep, both indexed, and returns them.
+func fillDB(t *testing.T, d *DB, n int) (top, nested *Bucket) {
+	t.Helper()
+	var err error
+	if top, err = d.Bucket([]byte("top")); err != nil {
+		t.Fatal(err)
+	}
+	if nested, err = d.BucketPath([]byte("p"), []byte("q"), []byte("r")); err != nil {
+		t.Fatal(err)
+	}
+	for _, bk := range []*Bucket{top, nested} {
+		if err := bk.AddIndex(tagIndex); err != nil {
+			t.Fatal(err)
+		}
+		items := make([]struct{ Key, Value []byte }, n)
+		for i := range items {
+			items[i].Key = []byte(fmt.Sprintf("k%04d", i))
+			items[i].Value = []byte(fmt.Sprintf("t%d,u%d", i%7, i%3))
+		}
+		if err := bk.Insert(items); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return top, nested
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	d := openTemp(t)
+	fillDB(t, d, 50)
+	want := dumpDB(t, d)
+
+	backup := filepath.Join(dir, "backup.db")
+	if err := d.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	// The database stays usable, and later writes aren't in the backup.
+	if err := d.Delete([]byte("top")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(backup); err != nil {
+		t.Fatalf("Verify(backup): %v", err)
+	}
+	b, err := Open(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got := dumpDB(t, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("backup holds %d items, want the %d of the database", len(got), len(want))
+	}
+	bk, err := b.OpenBucketPath([]byte("p"), []byte("q"), []byte("r"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.AddIndex(tagIndex); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := bk.Lookup("tag", []byte("t6")); err != nil || len(keys) != 7 {
+		t.Errorf("Lookup in the backup = %q, %v, want 7 keys", keys, err)
+	}
+}
+
+func TestBackupMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.db")
+	d := OpenMemory()
+	defer d.Close()
+	if err := d.Backup(path); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Backup of a memory database: %v, want %v", err, ErrNotSupported)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("failed backup left a file behind: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	backup := filepath.Join(dir, "backup.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillDB(t, d, 20)
+	want := dumpDB(t, d)
+	if err := d.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+	putValue(t, d, "top", "after", "backup")
+	replaced := dumpDB(t, d)
+	d.Close()
+
+	previous, err := Restore(path, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(path string, want []string) {
+		t.Helper()
+		d, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if got := dumpDB(t, d); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s holds %d items, want %d", path, len(got), len(want))
+		}
+	}
+	check(path, want)
+	if previous == "" {
+		t.Fatal("Restore didn't keep the database it replaced")
+	}
+	check(previous, replaced)
+
+	// Restoring where there's no database keeps nothing.
+	other := filepath.Join(dir, "other.db")
+	if previous, err := Restore(other, backup); err != nil || previous != "" {
+		t.Errorf("Restore to a new file = %q, %v", previous, err)
+	}
+	check(other, want)
+
+	// Backups that aren't databases are refused, leaving the database as is.
+	bogus := filepath.Join(dir, "bogus.db")
+	if err := os.WriteFile(bogus, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(path, bogus); err == nil {
+		t.Error("restored a file that isn't a database")
+	}
+	if _, err := Restore(path, filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("restored a missing file")
+	}
+	check(path, want)
+	if _, err := os.Stat(filepath.Join(dir, "missing.db")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Restore of a missing backup created it: %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 5 {
+		t.Errorf("restores left %d files, want 5: %v", len(entries), err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, _ := fillDB(t, d, 2000)
+	// Deleting most records leaves free pages for compaction to reclaim.
+	err = d.Tx(func(tx *Tx) error {
+		b, err := tx.Bucket(top)
+		if err != nil {
+			return err
+		}
+		for i := 100; i < 2000; i++ {
+			if err := b.Delete([]byte(fmt.Sprintf("k%04d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dumpDB(t, d)
+	d.Close()
+
+	before, after, err := Compact(path, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after >= before {
+		t.Errorf("Compact went from %d to %d bytes, want fewer", before, after)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != after {
+		t.Errorf("compacted file: %v, %v, want %d bytes", fi, err, after)
+	}
+	if _, err := os.Stat(path + ".compacting"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Compact left its temporary file: %v", err)
+	}
+	if err := Verify(path); err != nil {
+		t.Fatalf("Verify after Compact: %v", err)
+	}
+	d, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	got := dumpDB(t, d)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compacted database holds %d items, want %d", len(got), len(want))
+	}
+	for _, bucket := range []string{"top.idx.tag/", "p/q/r.idx.tag/", "p/q/r/"} {
+		if !contains(got, bucket) {
+			t.Errorf("compacted database lost bucket %s", bucket)
+		}
+	}
+
+	if _, _, err := Compact(filepath.Join(t.TempDir(), "missing.db"), 0); err == nil {
+		t.Error("compacted a missing file")
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+/* -- SCHEMA -- */
+
+// Name of the bucket holding metadata about the database itself.
+const metaBucket = "meta"
+
+var schemaVersionKey = []byte("schema_version")
+
+// A Migration upgrades a database from the previous schema version to
+// Version.
+type Migration struct {
+	Version     int
+	Description string
+	// Up performs the upgrade. It runs in the same transaction that records
+	// the new schema version, so a failed migration leaves the database as it
+	// was.
+	Up func(tx KVTx) error
+}
+
+// SchemaVersion returns the schema version recorded in the database, or 0 if
+// none is.
+func (db *DB) SchemaVersion() (version int, err error) {
+	err = db.View(func(tx KVTx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+func schemaVersion(tx KVTx) int {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+	v := b.Get(schemaVersionKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func setSchemaVersion(tx KVTx, version int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(version))
+	return b.Put(schemaVersionKey, v)
+}
+
+// IsMetaBucket reports whether `name` is the name of the metadata bucket.
+func IsMetaBucket(name []byte) bool {
+	return string(name) == metaBucket
+}
+
+// Migrate brings the database up to the latest of `migrations`, which must be
+// sorted by Version. An empty database is stamped with the latest version
+// without running any migration; any other database is copied to a backup
+// file next to it before the first pending migration runs.
+//
+// Migrate refuses to open databases written by a newer schema.
+func (db *DB) Migrate(migrations []Migration) error {
+	if len(migrations) == 0 {
+		return nil
+	}
+	latest := migrations[len(migrations)-1].Version
+	var current int
+	var empty bool
+	err := db.View(func(tx KVTx) error {
+		current = schemaVersion(tx)
+		empty = tx.ForEachBucket(func([]byte) error { return errNotEmpty }) == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case current > latest:
+		return fmt.Errorf("%s has schema version %d, newer than supported version %d",
+			db.Path(), current, latest)
+	case current == latest:
+		return nil
+	case empty:
+		slog.Debug("initializing schema", "path", db.Path(), "version", latest)
+		return db.Update(func(tx KVTx) error {
+			return setSchemaVersion(tx, latest)
+		})
+	}
+
+	// a database without a file has nothing worth keeping across a failure
+	backup := "(none)"
+	if db.Path() != "" {
+		backup = fmt.Sprintf("%s.v%d.%s.bak", db.Path(), current, time.Now().UTC().Format("20060102T150405Z"))
+		slog.Info("backing up database before migrating", "path", db.Path(), "backup", backup)
+		if err := db.Backup(backup); err != nil {
+			return fmt.Errorf("couldn't back up %s: %s", db.Path(), err)
+		}
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		slog.Info("migrating database", "path", db.Path(), "from", current, "to", m.Version,
+			"migration", m.Description)
+		err := db.Update(func(tx KVTx) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.Version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration to schema version %d (%s) failed: %s; backup is at %s",
+				m.Version, m.Description, err, backup)
+		}
+		current = m.Version
+	}
+	return nil
+}
+
+var errNotEmpty = errors.New("database not empty")
+
+// DropIndexes removes every index bucket, nested ones included, so that
+// indexes are rebuilt from their buckets' contents when next added. It's meant
+// for migrations that change how values are indexed.
+func DropIndexes(tx KVTx) error {
+	return dropIndexes(tx)
+}
+
+func dropIndexes(c KVContainer) error {
+	names, err := children(c)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if IsIndexBucket(name) {
+			err = c.DeleteBucket(name)
+		} else {
+			err = dropIndexes(c.Bucket(name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite replaces every key/value pair of the named bucket with the pair
+// returned by `fn`, or drops it if `fn` returns a nil key. It's meant for
+// migrations that change the format of values or the layout of keys. Indexes
+// of the bucket are left untouched, and should be dropped with DropIndexes.
+func Rewrite(tx KVTx, name []byte, fn func(k, v []byte) ([]byte, []byte, error)) error {
+	b := tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	var items []Item
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		nk, nv, err := fn(k, v)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %s", name, k, err)
+		}
+		if nk != nil {
+			items = append(items, Item{append([]byte(nil), nk...), append([]byte(nil), nv...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.DeleteBucket(name); err != nil {
+		return err
+	}
+	if b, err = tx.CreateBucket(name); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := b.Put(item.Key, item.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTemp opens a new database file in a temporary directory.
+func openTemp(t *testing.T) *DB {
+	t.Helper()
+	d, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// putValue stores k/v in the top-level bucket `name`.
+func putValue(t *testing.T, d *DB, name, k, v string) {
+	t.Helper()
+	err := d.Update(func(tx KVTx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(k), []byte(v))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getValue returns the value of k in the top-level bucket `name`.
+func getValue(t *testing.T, d *DB, name, k string) (v string) {
+	t.Helper()
+	err := d.View(func(tx KVTx) error {
+		if b := tx.Bucket([]byte(name)); b != nil {
+			v = string(b.Get([]byte(k)))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func schema(t *testing.T, d *DB) int {
+	t.Helper()
+	v, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func backups(t *testing.T, d *DB) []string {
+	t.Helper()
+	files, err := filepath.Glob(d.Path() + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// appendValue returns a migration appending to the value of k in bucket "b".
+func appendValue(version int, k, suffix string) Migration {
+	return Migration{Version: version, Description: "append " + suffix, Up: func(tx KVTx) error {
+		b := tx.Bucket([]byte("b"))
+		return b.Put([]byte(k), append(append([]byte(nil), b.Get([]byte(k))...), suffix...))
+	}}
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	d := openTemp(t)
+	m := appendValue(3, "k", "!")
+	m.Up = func(tx KVTx) error { return errors.New("ran a migration on an empty database") }
+	if err := d.Migrate([]Migration{appendValue(1, "k", "1"), m}); err != nil {
+		t.Fatal(err)
+	}
+	if v := schema(t, d); v != 3 {
+		t.Errorf("schema version %d, want 3", v)
+	}
+	if files := backups(t, d); len(files) != 0 {
+		t.Errorf("backed up an empty database to %s", files)
+	}
+}
+
+func TestMigrateUpgrades(t *testing.T) {
+	d := openTemp(t)
+	putValue(t, d, "b", "k", "v")
+	migrations := []Migration{appendValue(1, "k", "1"), appendValue(2, "k", "2")}
+	if err := d.Migrate(migrations[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if v, s := getValue(t, d, "b", "k"), schema(t, d); v != "v1" || s != 1 {
+		t.Fatalf("got %q at version %d, want v1 at version 1", v, s)
+	}
+	files := backups(t, d)
+	if len(files) != 1 || !strings.Contains(files[0], ".v0.") {
+		t.Fatalf("backups %s, want one of version 0", files)
+	}
+
+	// Only pending migrations run, and a database already up to date is
+	// left alone.
+	for i := 0; i < 2; i++ {
+		if err := d.Migrate(migrations); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, s := getValue(t, d, "b", "k"), schema(t, d); v != "v12" || s != 2 {
+		t.Errorf("got %q at version %d, want v12 at version 2", v, s)
+	}
+	if files := backups(t, d); len(files) != 2 {
+		t.Errorf("backups %s, want one of version 0 and one of version 1", files)
+	}
+
+	// The backup holds the database as it was before migrating.
+	b, err := Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if v, s := getValue(t, b, "b", "k"), schema(t, b); v != "v" || s != 0 {
+		t.Errorf("backup holds %q at version %d, want v at version 0", v, s)
+	}
+}
+
+func TestMigrateRollsBack(t *testing.T) {
+	d := openTemp(t)
+	putValue(t, d, "b", "k", "v")
+	failing := Migration{Version: 3, Description: "fail", Up: func(tx KVTx) error {
+		if err := tx.Bucket([]byte("b")).Put([]byte("k"), []byte("clobbered")); err != nil {
+			return err
+		}
+		return errors.New("boom")
+	}}
+	err := d.Migrate([]Migration{appendValue(1, "k", "1"), appendValue(2, "k", "2"), failing, appendValue(4, "k", "4")})
+	if err == nil || !strings.Contains(err.Error(), "boom") || !strings.Contains(err.Error(), "backup is at "+backups(t, d)[0]) {
+		t.Fatalf("got error %v, want the migration's error and the backup", err)
+	}
+	// Migrations before the failing one are kept, its changes aren't, and
+	// none after it ran.
+	if v, s := getValue(t, d, "b", "k"), schema(t, d); v != "v12" || s != 2 {
+		t.Errorf("got %q at version %d, want v12 at version 2", v, s)
+	}
+}
+
+func TestMigrateRefusesNewer(t *testing.T) {
+	d := openTemp(t)
+	putValue(t, d, "b", "k", "v")
+	if err := d.Migrate([]Migration{appendValue(1, "k", "1"), appendValue(5, "k", "5")}); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Migrate([]Migration{appendValue(1, "k", "1"), appendValue(2, "k", "2")})
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("got error %v, want a refusal of the newer schema", err)
+	}
+	if v, s := getValue(t, d, "b", "k"), schema(t, d); v != "v15" || s != 5 {
+		t.Errorf("got %q at version %d, want v15 at version 5", v, s)
+	}
+}
+
+func TestMigrateMemory(t *testing.T) {
+	d := OpenMemory()
+	putValue(t, d, "b", "k", "v")
+	err := d.Migrate([]Migration{{Version: 1, Description: "fail", Up: func(KVTx) error { return errors.New("boom") }}})
+	if err == nil || !strings.Contains(err.Error(), "backup is at (none)") {
+		t.Fatalf("got error %v, want a failure without backup", err)
+	}
+	if err := d.Migrate([]Migration{appendValue(1, "k", "1")}); err != nil {
+		t.Fatal(err)
+	}
+	if v := getValue(t, d, "b", "k"); v != "v1" {
+		t.Errorf("got %q, want v1", v)
+	}
+}
+
+func TestDropIndexesAndRewrite(t *testing.T) {
+	d := OpenMemory()
+	bk, err := d.BucketPath([]byte("p"), []byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bk.AddIndex(tagIndex); err != nil {
+		t.Fatal(err)
+	}
+	putValue(t, d, "flat", "a", "1")
+	putValue(t, d, "flat", "b", "2")
+	err = d.Update(func(tx KVTx) error {
+		if err := DropIndexes(tx); err != nil {
+			return err
+		}
+		return Rewrite(tx, []byte("flat"), func(k, v []byte) ([]byte, []byte, error) {
+			if string(k) == "b" {
+				return nil, nil, nil
+			}
+			return append([]byte("new-"), k...), append(v, '!'), nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The index bucket of p/root is its sibling, within p.
+	var names [][]byte
+	err = d.View(func(tx KVTx) error {
+		names, err = children(tx.Bucket([]byte("p")))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || string(names[0]) != "root" {
+		t.Errorf("p holds buckets %q, want only root", names)
+	}
+	if a, b, na := getValue(t, d, "flat", "a"), getValue(t, d, "flat", "b"), getValue(t, d, "flat", "new-a"); a != "" || b != "" || na != "1!" {
+		t.Errorf("rewritten bucket holds a=%q b=%q new-a=%q, want only new-a=1!", a, b, na)
+	}
+}
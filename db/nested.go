@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* -- NESTED BUCKETS -- */
+
+// resolve returns the bucket at `path` within tx, or nil if there's none.
+func resolve(tx KVTx, path [][]byte) KVBucket {
+	var c KVContainer = tx
+	var b KVBucket
+	for _, name := range path {
+		if b = c.Bucket(name); b == nil {
+			return nil
+		}
+		c = b
+	}
+	return b
+}
+
+// parentOf returns the container of the bucket at `path`, or nil if it
+// doesn't exist.
+func parentOf(tx KVTx, path [][]byte) KVContainer {
+	if len(path) <= 1 {
+		return tx
+	}
+	if b := resolve(tx, path[:len(path)-1]); b != nil {
+		return b
+	}
+	return nil
+}
+
+// children returns the names of the buckets directly within container c.
+func children(c KVContainer) (names [][]byte, err error) {
+	err = c.ForEachBucket(func(name []byte) error {
+		names = append(names, append([]byte(nil), name...))
+		return nil
+	})
+	return names, err
+}
+
+// pathString formats a bucket path for messages.
+func pathString(path [][]byte) string {
+	names := make([]string, len(path))
+	for i, name := range path {
+		names[i] = string(name)
+	}
+	return strings.Join(names, "/")
+}
+
+// BucketPath returns the bucket nested at `path`, creating any missing
+// bucket along it.
+func (db *DB) BucketPath(path ...[]byte) (*Bucket, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty bucket path")
+	}
+	err := db.Update(func(tx KVTx) error {
+		var c KVContainer = tx
+		for _, name := range path {
+			b, err := c.CreateBucketIfNotExists(name)
+			if err != nil {
+				return fmt.Errorf("bucket %s: %s", pathString(path), err)
+			}
+			c = b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newBucket(db, path), nil
+}
+
+// OpenBucketPath returns the existing bucket nested at `path`, or an error
+// wrapping ErrBucketNotFound if there's none. Unlike BucketPath it never
+// writes, so it works on databases opened read-only.
+func (db *DB) OpenBucketPath(path ...[]byte) (*Bucket, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty bucket path")
+	}
+	err := db.View(func(tx KVTx) error {
+		if resolve(tx, path) == nil {
+			return fmt.Errorf("bucket %s: %w", pathString(path), ErrBucketNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newBucket(db, path), nil
+}
+
+// Buckets returns the names of the top-level buckets, index buckets excluded.
+func (db *DB) Buckets() (names [][]byte, err error) {
+	err = db.View(func(tx KVTx) error {
+		all, err := children(tx)
+		for _, name := range all {
+			if !IsIndexBucket(name) {
+				names = append(names, name)
+			}
+		}
+		return err
+	})
+	return names, err
+}
+
+func newBucket(db *DB, path [][]byte) *Bucket {
+	path = append([][]byte(nil), path...)
+	return &Bucket{db: db, Name: path[len(path)-1], parents: path[:len(path)-1]}
+}
+
+// Path returns the names of the buckets leading to the bucket, starting with
+// a top-level bucket and ending with its own name.
+func (bk *Bucket) Path() [][]byte {
+	return append(append([][]byte(nil), bk.parents...), bk.Name)
+}
+
+// bucket returns the bucket within tx.
+func (bk *Bucket) bucket(tx KVTx) KVBucket {
+	return resolve(tx, bk.Path())
+}
+
+// open returns the bucket within tx, or an error if it's been deleted.
+func (bk *Bucket) open(tx KVTx) (KVBucket, error) {
+	b := bk.bucket(tx)
+	if b == nil {
+		return nil, fmt.Errorf("bucket %s: %w", pathString(bk.Path()), ErrBucketNotFound)
+	}
+	return b, nil
+}
+
+// Bucket returns the bucket nested in bk named `name`, creating it if it
+// doesn't exist.
+func (bk *Bucket) Bucket(name []byte) (*Bucket, error) {
+	return bk.db.BucketPath(append(bk.Path(), name)...)
+}
+
+// Buckets returns the names of the buckets nested in bk, index buckets
+// excluded.
+func (bk *Bucket) Buckets() (names [][]byte, err error) {
+	err = bk.db.View(func(tx KVTx) error {
+		b, err := bk.open(tx)
+		if err != nil {
+			return err
+		}
+		all, err := children(b)
+		for _, name := range all {
+			if !IsIndexBucket(name) {
+				names = append(names, name)
+			}
+		}
+		return err
+	})
+	return names, err
+}
+
+// DeleteBucket removes the bucket nested in bk named `name`, along with its
+// indexes and everything nested in it.
+func (bk *Bucket) DeleteBucket(name []byte) error {
+	return bk.db.Update(func(tx KVTx) error {
+		b, err := bk.open(tx)
+		if err != nil {
+			return err
+		}
+		if err := deleteIndexes(b, name); err != nil {
+			return err
+		}
+		return b.DeleteBucket(name)
+	})
+}
@@ -2,8 +2,7 @@ package db
 
 import (
 	"bytes"
-
-	bolt "go.etcd.io/bbolt"
+	"context"
 )
 
 // A PrefixScanner scans a bucket for keys with a given prefix.
@@ -11,44 +10,37 @@ type PrefixScanner struct {
 	db         *DB
 	BucketName []byte
 	Prefix     []byte
+	path       [][]byte
+}
+
+func (ps *PrefixScanner) in(k []byte) bool {
+	return bytes.HasPrefix(k, ps.Prefix)
+}
+
+// Iterate applies `do` on each key/value pair for keys with prefix, in key
+// order, until `do` returns an error or `ctx` is done.
+func (ps *PrefixScanner) Iterate(ctx context.Context, opts IterOptions, do func(k, v []byte) error) error {
+	return iterate(ctx, ps.db, ps.path, ps.Prefix, ps.in, opts, do)
 }
 
 // Map applies `do` on each key/value pair for keys with prefix.
 func (ps *PrefixScanner) Map(do func(k, v []byte) error) error {
-	pre := ps.Prefix
-	return ps.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(ps.BucketName).Cursor()
-		for k, v := c.Seek(pre); bytes.HasPrefix(k, pre); k, _ = c.Next() {
-			do(k, v)
-		}
-		return nil
-	})
+	return ps.Iterate(context.Background(), IterOptions{}, do)
 }
 
 // Count returns a count of the keys with prefix.
 func (ps *PrefixScanner) Count() (count int, err error) {
-	pre := ps.Prefix
-	err = ps.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(ps.BucketName).Cursor()
-		for k, _ := c.Seek(pre); bytes.HasPrefix(k, pre); k, _ = c.Next() {
-			count++
-		}
+	err = ps.Map(func(_, _ []byte) error {
+		count++
 		return nil
 	})
-	if err != nil {
-		return count, err
-	}
 	return count, err
 }
 
 // Keys returns a slice of keys with prefix.
 func (ps *PrefixScanner) Keys() (keys [][]byte, err error) {
-	pre := ps.Prefix
-	err = ps.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(ps.BucketName).Cursor()
-		for k, _ := c.Seek(pre); bytes.HasPrefix(k, pre); k, _ = c.Next() {
-			keys = append(keys, k)
-		}
+	err = ps.Map(func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
 		return nil
 	})
 	if err != nil {
@@ -59,12 +51,8 @@ func (ps *PrefixScanner) Keys() (keys [][]byte, err error) {
 
 // Values returns a slice of values for keys with prefix.
 func (ps *PrefixScanner) Values() (values [][]byte, err error) {
-	pre := ps.Prefix
-	err = ps.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(ps.BucketName).Cursor()
-		for k, v := c.Seek(pre); bytes.HasPrefix(k, pre); k, v = c.Next() {
-			values = append(values, v)
-		}
+	err = ps.Map(func(_, v []byte) error {
+		values = append(values, append([]byte(nil), v...))
 		return nil
 	})
 	if err != nil {
@@ -75,15 +63,7 @@ func (ps *PrefixScanner) Values() (values [][]byte, err error) {
 
 // Items returns a slice of key/value pairs for keys with prefix.
 func (ps *PrefixScanner) Items() (items []Item, err error) {
-	pre := ps.Prefix
-	err = ps.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(ps.BucketName).Cursor()
-		for k, v := c.Seek(pre); bytes.HasPrefix(k, pre); k, v = c.Next() {
-			items = append(items, Item{k, v})
-		}
-		return nil
-	})
-	if err != nil {
+	if err = ps.Map(collectItems(&items)); err != nil {
 		return nil, err
 	}
 	return items, err
@@ -92,13 +72,9 @@ func (ps *PrefixScanner) Items() (items []Item, err error) {
 // ItemMapping returns a map of key/value pairs for keys with prefix.
 // This only works with buckets whose keys are byte-sliced strings.
 func (ps *PrefixScanner) ItemMapping() (map[string][]byte, error) {
-	pre := ps.Prefix
 	items := make(map[string][]byte)
-	err := ps.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(ps.BucketName).Cursor()
-		for k, v := c.Seek(pre); bytes.HasPrefix(k, pre); k, v = c.Next() {
-			items[string(k)] = v
-		}
+	err := ps.Map(func(k, v []byte) error {
+		items[string(k)] = append([]byte(nil), v...)
 		return nil
 	})
 	if err != nil {
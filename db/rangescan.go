@@ -1,7 +1,7 @@
 package db
 
 import (
-	bolt "go.etcd.io/bbolt"
+	"context"
 )
 
 // A RangeScanner scans a bucket for keys within a given range.
@@ -10,41 +10,37 @@ type RangeScanner struct {
 	BucketName []byte
 	Min        []byte
 	Max        []byte
+	path       [][]byte
+}
+
+func (rs *RangeScanner) in(k []byte) bool {
+	return isBefore(k, rs.Max)
+}
+
+// Iterate applies `do` on each key/value pair for keys within range, in key
+// order, until `do` returns an error or `ctx` is done.
+func (rs *RangeScanner) Iterate(ctx context.Context, opts IterOptions, do func(k, v []byte) error) error {
+	return iterate(ctx, rs.db, rs.path, rs.Min, rs.in, opts, do)
 }
 
 // Map applies `do` on each key/value pair for keys within range.
 func (rs *RangeScanner) Map(do func(k, v []byte) error) error {
-	return rs.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(rs.BucketName).Cursor()
-		for k, v := c.Seek(rs.Min); isBefore(k, rs.Max); k, v = c.Next() {
-			do(k, v)
-		}
-		return nil
-	})
+	return rs.Iterate(context.Background(), IterOptions{}, do)
 }
 
 // Count returns a count of the keys within the range.
 func (rs *RangeScanner) Count() (count int, err error) {
-	err = rs.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(rs.BucketName).Cursor()
-		for k, _ := c.Seek(rs.Min); isBefore(k, rs.Max); k, _ = c.Next() {
-			count++
-		}
+	err = rs.Map(func(_, _ []byte) error {
+		count++
 		return nil
 	})
-	if err != nil {
-		return count, err
-	}
 	return count, err
 }
 
 // Keys returns a slice of keys within the range.
 func (rs *RangeScanner) Keys() (keys [][]byte, err error) {
-	err = rs.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(rs.BucketName).Cursor()
-		for k, _ := c.Seek(rs.Min); isBefore(k, rs.Max); k, _ = c.Next() {
-			keys = append(keys, k)
-		}
+	err = rs.Map(func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
 		return nil
 	})
 	if err != nil {
@@ -55,11 +51,8 @@ func (rs *RangeScanner) Keys() (keys [][]byte, err error) {
 
 // Values returns a slice of values for keys within the range.
 func (rs *RangeScanner) Values() (values [][]byte, err error) {
-	err = rs.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(rs.BucketName).Cursor()
-		for k, v := c.Seek(rs.Min); isBefore(k, rs.Max); k, v = c.Next() {
-			values = append(values, v)
-		}
+	err = rs.Map(func(_, v []byte) error {
+		values = append(values, append([]byte(nil), v...))
 		return nil
 	})
 	if err != nil {
@@ -69,16 +62,8 @@ func (rs *RangeScanner) Values() (values [][]byte, err error) {
 }
 
 // Items returns a slice of key/value pairs for keys within the range.
-// Note that the returned slice contains elements of type Item.
 func (rs *RangeScanner) Items() (items []Item, err error) {
-	err = rs.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(rs.BucketName).Cursor()
-		for k, v := c.Seek(rs.Min); isBefore(k, rs.Max); k, v = c.Next() {
-			items = append(items, Item{k, v})
-		}
-		return nil
-	})
-	if err != nil {
+	if err = rs.Map(collectItems(&items)); err != nil {
 		return nil, err
 	}
 	return items, err
@@ -88,11 +73,8 @@ func (rs *RangeScanner) Items() (items []Item, err error) {
 // This only works with buckets whose keys are byte-sliced strings.
 func (rs *RangeScanner) ItemMapping() (map[string][]byte, error) {
 	items := make(map[string][]byte)
-	err := rs.db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(rs.BucketName).Cursor()
-		for k, v := c.Seek(rs.Min); isBefore(k, rs.Max); k, v = c.Next() {
-			items[string(k)] = v
-		}
+	err := rs.Map(func(k, v []byte) error {
+		items[string(k)] = append([]byte(nil), v...)
 		return nil
 	})
 	if err != nil {
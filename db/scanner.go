@@ -1,8 +1,14 @@
 package db
 
+import "context"
+
 // A Scanner implements methods for scanning a subset of keys
 // in a bucket and retrieving data from or about those keys.
 type Scanner interface {
+	// Iterate applies a func on each key/value pair scanned, in key order,
+	// stopping when it returns an error or the context is done. Returning
+	// ErrStop ends the scan without error.
+	Iterate(ctx context.Context, opts IterOptions, do func(k, v []byte) error) error
 	// Map applies a func on each key/value pair scanned.
 	Map(func(k, v []byte) error) error
 	// Count returns a count of the scanned keys.
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/* -- TRANSACTION -- */
+
+// A Tx is a transaction spanning any number of buckets. Reads within a Tx see
+// its own writes, and its writes are committed together or not at all.
+type Tx struct {
+	db *DB
+	tx KVTx
+}
+
+// Tx runs `fn` in a read-write transaction, committed if `fn` returns nil
+// and rolled back otherwise.
+func (db *DB) Tx(fn func(tx *Tx) error) error {
+	return db.Update(func(tx KVTx) error {
+		return fn(&Tx{db, tx})
+	})
+}
+
+// ReadTx runs `fn` in a read-only transaction, which sees a consistent
+// snapshot of every bucket.
+func (db *DB) ReadTx(fn func(tx *Tx) error) error {
+	return db.View(func(tx KVTx) error {
+		return fn(&Tx{db, tx})
+	})
+}
+
+// Bucket returns bucket `bk` bound to the transaction. Writes through it
+// maintain `bk`'s indexes.
+func (tx *Tx) Bucket(bk *Bucket) (*TxBucket, error) {
+	if bk.db != tx.db {
+		return nil, fmt.Errorf("bucket %s belongs to another database", bk.Name)
+	}
+	if _, err := bk.open(tx.tx); err != nil {
+		return nil, err
+	}
+	return &TxBucket{tx.tx, bk}, nil
+}
+
+// A TxBucket is a Bucket bound to a transaction.
+type TxBucket struct {
+	tx KVTx
+	bk *Bucket
+}
+
+// Get retrieves the value for key `k`, or nil if there's none. An empty
+// value is returned as a non-nil empty slice.
+func (b *TxBucket) Get(k []byte) []byte {
+	v := b.bk.bucket(b.tx).Get(k)
+	if v == nil {
+		return nil
+	}
+	value := make([]byte, len(v))
+	copy(value, v)
+	return value
+}
+
+// Put inserts value `v` with key `k`.
+func (b *TxBucket) Put(k, v []byte) error {
+	return b.bk.put(b.tx, k, v)
+}
+
+// PutNX (put-if-not-exists) inserts value `v` with key `k` if the key
+// doesn't exist, and reports whether it did.
+func (b *TxBucket) PutNX(k, v []byte) (bool, error) {
+	if b.bk.bucket(b.tx).Get(k) != nil {
+		return false, nil
+	}
+	return true, b.bk.put(b.tx, k, v)
+}
+
+// CompareAndSwap replaces the value of key `k` with `v` if its current value
+// is `old`, and reports whether it did. A nil `old` matches a missing key, and
+// a nil `v` deletes the key.
+func (b *TxBucket) CompareAndSwap(k, old, v []byte) (bool, error) {
+	cur := b.bk.bucket(b.tx).Get(k)
+	if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	if v == nil {
+		return true, b.bk.del(b.tx, k)
+	}
+	return true, b.bk.put(b.tx, k, v)
+}
+
+// Delete removes key `k`.
+func (b *TxBucket) Delete(k []byte) error {
+	return b.bk.del(b.tx, k)
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// missing stands for a key without a value in the tables of tests.
+const missing = "<missing>"
+
+func bytesOf(s string) []byte {
+	if s == missing {
+		return nil
+	}
+	return []byte(s)
+}
+
+func stringOf(v []byte) string {
+	if v == nil {
+		return missing
+	}
+	return string(v)
+}
+
+func TestTxConditionalWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		// the value of the key before and after op, and op's report
+		before, after string
+		op            func(b *TxBucket, k []byte) (bool, error)
+		want          bool
+	}{
+		{"PutNX of a missing key", missing, "new", putNX("new"), true},
+		{"PutNX of a present key", "old", "old", putNX("new"), false},
+		{"PutNX of an empty value", "", "", putNX("new"), false},
+		{"PutNX of an empty value to a missing key", missing, "", putNX(""), true},
+
+		{"CAS from missing to missing key", missing, "new", cas(missing, "new"), true},
+		{"CAS from missing to present key", "old", "old", cas(missing, "new"), false},
+		{"CAS from missing to empty value", "", "", cas(missing, "new"), false},
+		{"CAS from empty to missing key", missing, missing, cas("", "new"), false},
+		{"CAS from empty to empty value", "", "new", cas("", "new"), true},
+		{"CAS from matching value", "old", "new", cas("old", "new"), true},
+		{"CAS from mismatching value", "old", "old", cas("other", "new"), false},
+		{"CAS from prefix of value", "old", "old", cas("ol", "new"), false},
+		{"CAS from value to missing key", missing, missing, cas("old", "new"), false},
+		{"CAS to the same value", "old", "old", cas("old", "old"), true},
+		{"CAS to an empty value", "old", "", cas("old", ""), true},
+		{"CAS deleting a matching value", "old", missing, cas("old", missing), true},
+		{"CAS deleting a mismatching value", "old", "old", cas("other", missing), false},
+		{"CAS deleting a missing key", missing, missing, cas(missing, missing), true},
+	}
+	testKVs(t, func(t *testing.T, kv KV) {
+		d := New(kv)
+		bk, err := d.Bucket([]byte("b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, tt := range tests {
+			k := []byte(fmt.Sprintf("k%02d", i))
+			if tt.before != missing {
+				if err := bk.Put(k, bytesOf(tt.before)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got bool
+			err := d.Tx(func(tx *Tx) error {
+				b, err := tx.Bucket(bk)
+				if err != nil {
+					return err
+				}
+				got, err = tt.op(b, k)
+				if err != nil {
+					return err
+				}
+				if v := stringOf(b.Get(k)); v != tt.after {
+					t.Errorf("%s: within the transaction, value is %q, want %q", tt.name, v, tt.after)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s: reported %v, want %v", tt.name, got, tt.want)
+			}
+			v, err := bk.Get(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stringOf(v) != tt.after {
+				t.Errorf("%s: value is %q, want %q", tt.name, stringOf(v), tt.after)
+			}
+		}
+	})
+}
+
+func putNX(v string) func(b *TxBucket, k []byte) (bool, error) {
+	return func(b *TxBucket, k []byte) (bool, error) {
+		return b.PutNX(k, bytesOf(v))
+	}
+}
+
+func cas(old, v string) func(b *TxBucket, k []byte) (bool, error) {
+	return func(b *TxBucket, k []byte) (bool, error) {
+		return b.CompareAndSwap(k, bytesOf(old), bytesOf(v))
+	}
+}
+
+func TestBucketConditionalWrites(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		bk, err := New(kv).Bucket([]byte("b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bk.AddIndex(tagIndex); err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a", "b"} {
+			if err := bk.PutNX([]byte(k), []byte(k+"1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := bk.PutNX([]byte("a"), []byte("a2")); err != nil {
+			t.Fatal(err)
+		}
+		err = bk.InsertNX([]struct{ Key, Value []byte }{
+			{[]byte("b"), []byte("b2")},
+			{[]byte("c"), []byte("c1")},
+			{[]byte("c"), []byte("c2")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range []struct {
+			k, old, v string
+			want      bool
+		}{
+			{"a", "a2", "a3", false},
+			{"a", "a1", "a3", true},
+			{"d", missing, "d1", true},
+			{"b", "b1", missing, true},
+		} {
+			k := []byte(c.k)
+			swapped, err := bk.CompareAndSwap(k, bytesOf(c.old), bytesOf(c.v))
+			if err != nil || swapped != c.want {
+				t.Errorf("CompareAndSwap(%s, %s, %s) = %v, %v, want %v", k, c.old, c.v, swapped, err, c.want)
+			}
+		}
+		items, err := bk.Items()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, item := range items {
+			got = append(got, fmt.Sprintf("%s=%s", item.Key, item.Value))
+		}
+		if want := []string{"a=a3", "c=c1", "d=d1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("bucket holds %q, want %q", got, want)
+		}
+		if got, want := indexEntries(t, bk, "tag"), []string{"a3=a", "c1=c", "d1=d"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("index holds %q, want %q", got, want)
+		}
+	})
+}
+
+func TestTxRollback(t *testing.T) {
+	testKVs(t, func(t *testing.T, kv KV) {
+		d := New(kv)
+		b1, err := d.Bucket([]byte("b1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b1.AddIndex(tagIndex); err != nil {
+			t.Fatal(err)
+		}
+		b2, err := d.BucketPath([]byte("p"), []byte("b2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b1.Put([]byte("k"), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Writes to several buckets land together, or not at all.
+		write := func(fail error) error {
+			return d.Tx(func(tx *Tx) error {
+				tb1, err := tx.Bucket(b1)
+				if err != nil {
+					return err
+				}
+				tb2, err := tx.Bucket(b2)
+				if err != nil {
+					return err
+				}
+				if ok, err := tb1.CompareAndSwap([]byte("k"), []byte("x"), []byte("y")); !ok || err != nil {
+					return fmt.Errorf("CompareAndSwap = %v, %v", ok, err)
+				}
+				if ok, err := tb1.PutNX([]byte("new"), []byte("z")); !ok || err != nil {
+					return fmt.Errorf("PutNX = %v, %v", ok, err)
+				}
+				if err := tb2.Put([]byte("k"), []byte("v")); err != nil {
+					return err
+				}
+				return fail
+			})
+		}
+		errFail := errors.New("fail")
+		if err := write(errFail); err != errFail {
+			t.Fatalf("Tx returned %v, want %v", err, errFail)
+		}
+		check := func(bk *Bucket, k, want string) {
+			t.Helper()
+			if v, err := bk.Get([]byte(k)); err != nil || stringOf(v) != want {
+				t.Errorf("%s of %s = %q, %v, want %q", k, bk.Name, stringOf(v), err, want)
+			}
+		}
+		check(b1, "k", "x")
+		check(b1, "new", missing)
+		check(b2, "k", missing)
+		if got, want := indexEntries(t, b1, "tag"), []string{"x=k"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("after rollback, index holds %q, want %q", got, want)
+		}
+
+		if err := write(nil); err != nil {
+			t.Fatal(err)
+		}
+		check(b1, "k", "y")
+		check(b1, "new", "z")
+		check(b2, "k", "v")
+		if got, want := indexEntries(t, b1, "tag"), []string{"y=k", "z=new"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("after commit, index holds %q, want %q", got, want)
+		}
+
+		// Read-only transactions refuse writes.
+		err = d.ReadTx(func(tx *Tx) error {
+			b, err := tx.Bucket(b1)
+			if err != nil {
+				return err
+			}
+			if _, err := b.PutNX([]byte("other"), []byte("v")); !errors.Is(err, ErrTxNotWritable) {
+				t.Errorf("PutNX in a read-only transaction: %v, want %v", err, ErrTxNotWritable)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Buckets must exist, and belong to the transaction's database.
+		other, err := New(newMemKV()).Bucket([]byte("b1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Delete([]byte("b1")); err != nil {
+			t.Fatal(err)
+		}
+		err = d.Tx(func(tx *Tx) error {
+			if _, err := tx.Bucket(other); err == nil {
+				t.Error("bound a bucket of another database")
+			}
+			_, err := tx.Bucket(b1)
+			return err
+		})
+		if !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("Tx on a deleted bucket: %v, want %v", err, ErrBucketNotFound)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package db
+
+import "context"
+
+/* -- TYPED BUCKET -- */
+
+// A TypedItem holds a decoded key/value pair.
+type TypedItem[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// A TypedBucket is a view of a Bucket whose keys and values are encoded from
+// and decoded to Go types, by a KeyEncoder and a Codec.
+type TypedBucket[K, V any] struct {
+	bucket *Bucket
+	keys   KeyEncoder[K]
+	codec  Codec[V]
+}
+
+// NewTypedBucket returns a typed view of bucket `b`.
+func NewTypedBucket[K, V any](b *Bucket, keys KeyEncoder[K], codec Codec[V]) *TypedBucket[K, V] {
+	return &TypedBucket[K, V]{b, keys, codec}
+}
+
+// Bucket returns the underlying bucket.
+func (tb *TypedBucket[K, V]) Bucket() *Bucket {
+	return tb.bucket
+}
+
+// Put inserts value `v` with key `k`.
+func (tb *TypedBucket[K, V]) Put(k K, v V) error {
+	data, err := tb.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return tb.bucket.Put(tb.keys.EncodeKey(k), data)
+}
+
+// Get retrieves the value for key `k`. `ok` is false if there's none.
+func (tb *TypedBucket[K, V]) Get(k K) (v V, ok bool, err error) {
+	data, err := tb.bucket.Get(tb.keys.EncodeKey(k))
+	if err != nil || data == nil {
+		return v, false, err
+	}
+	v, err = tb.codec.Decode(data)
+	return v, err == nil, err
+}
+
+// Delete removes key `k`.
+func (tb *TypedBucket[K, V]) Delete(k K) error {
+	return tb.bucket.Delete(tb.keys.EncodeKey(k))
+}
+
+// Insert puts each item in the bucket as part of a single transaction.
+func (tb *TypedBucket[K, V]) Insert(items []TypedItem[K, V]) error {
+	raw := make([]struct{ Key, Value []byte }, 0, len(items))
+	for _, item := range items {
+		data, err := tb.codec.Encode(item.Value)
+		if err != nil {
+			return err
+		}
+		raw = append(raw, struct{ Key, Value []byte }{tb.keys.EncodeKey(item.Key), data})
+	}
+	return tb.bucket.Insert(raw)
+}
+
+// Iterate applies `do` on each decoded key/value pair, in key order, until
+// `do` returns an error or `ctx` is done.
+func (tb *TypedBucket[K, V]) Iterate(ctx context.Context, opts IterOptions, do func(k K, v V) error) error {
+	return tb.bucket.Iterate(ctx, opts, tb.decoding(do))
+}
+
+// Map applies `do` on each decoded key/value pair, in key order.
+func (tb *TypedBucket[K, V]) Map(do func(k K, v V) error) error {
+	return tb.bucket.Map(tb.decoding(do))
+}
+
+// MapRange applies `do` on each decoded key/value pair with a key within
+// [min, max], in key order.
+func (tb *TypedBucket[K, V]) MapRange(do func(k K, v V) error, min, max K) error {
+	return tb.bucket.MapRange(tb.decoding(do), tb.keys.EncodeKey(min), tb.keys.EncodeKey(max))
+}
+
+// Items returns every decoded key/value pair, in key order.
+func (tb *TypedBucket[K, V]) Items() (items []TypedItem[K, V], err error) {
+	err = tb.Map(func(k K, v V) error {
+		items = append(items, TypedItem[K, V]{k, v})
+		return nil
+	})
+	return items, err
+}
+
+// NewRangeScanner initializes a typed scanner over keys within [min, max].
+func (tb *TypedBucket[K, V]) NewRangeScanner(min, max K) *TypedScanner[K, V] {
+	return tb.Scanner(tb.bucket.NewRangeScanner(tb.keys.EncodeKey(min), tb.keys.EncodeKey(max)))
+}
+
+// Scanner returns a typed view of a scanner over the bucket's keys.
+func (tb *TypedBucket[K, V]) Scanner(s Scanner) *TypedScanner[K, V] {
+	return &TypedScanner[K, V]{s, tb.keys, tb.codec}
+}
+
+// decoding adapts a typed callback to raw keys and values.
+func (tb *TypedBucket[K, V]) decoding(do func(k K, v V) error) func(k, v []byte) error {
+	return decoding(tb.keys, tb.codec, do)
+}
+
+func decoding[K, V any](keys KeyEncoder[K], codec Codec[V], do func(k K, v V) error) func(k, v []byte) error {
+	return func(k, v []byte) error {
+		// nested buckets have nil values
+		if v == nil {
+			return nil
+		}
+		key, err := keys.DecodeKey(k)
+		if err != nil {
+			return err
+		}
+		value, err := codec.Decode(v)
+		if err != nil {
+			return err
+		}
+		return do(key, value)
+	}
+}
+
+/* -- TYPED SCANNER -- */
+
+// A TypedScanner decodes the key/value pairs of a Scanner.
+type TypedScanner[K, V any] struct {
+	scanner Scanner
+	keys    KeyEncoder[K]
+	codec   Codec[V]
+}
+
+// Iterate applies `do` on each decoded key/value pair scanned, in key order,
+// until `do` returns an error or `ctx` is done.
+func (ts *TypedScanner[K, V]) Iterate(ctx context.Context, opts IterOptions, do func(k K, v V) error) error {
+	return ts.scanner.Iterate(ctx, opts, decoding(ts.keys, ts.codec, do))
+}
+
+// Map applies `do` on each decoded key/value pair scanned.
+func (ts *TypedScanner[K, V]) Map(do func(k K, v V) error) error {
+	return ts.scanner.Map(decoding(ts.keys, ts.codec, do))
+}
+
+// Count returns a count of the scanned keys.
+func (ts *TypedScanner[K, V]) Count() (int, error) {
+	return ts.scanner.Count()
+}
+
+// Keys returns a slice of the decoded scanned keys.
+func (ts *TypedScanner[K, V]) Keys() (keys []K, err error) {
+	raw, err := ts.scanner.Keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range raw {
+		key, err := ts.keys.DecodeKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Items returns a slice of decoded k/v pairs from scanned keys.
+func (ts *TypedScanner[K, V]) Items() (items []TypedItem[K, V], err error) {
+	err = ts.Map(func(k K, v V) error {
+		items = append(items, TypedItem[K, V]{k, v})
+		return nil
+	})
+	return items, err
+}
+
+/* -- TYPED BATCH WRITER -- */
+
+// A TypedBatchWriter is a BatchWriter encoding keys and values as its
+// TypedBucket does.
+type TypedBatchWriter[K, V any] struct {
+	writer *BatchWriter
+	keys   KeyEncoder[K]
+	codec  Codec[V]
+}
+
+// NewBatchWriter returns a TypedBatchWriter for the bucket.
+func (tb *TypedBucket[K, V]) NewBatchWriter(opts BatchOptions) *TypedBatchWriter[K, V] {
+	return &TypedBatchWriter[K, V]{tb.bucket.NewBatchWriter(opts), tb.keys, tb.codec}
+}
+
+// Put buffers value `v` with key `k`, committing the buffer if it's full.
+func (w *TypedBatchWriter[K, V]) Put(k K, v V) error {
+	data, err := w.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return w.writer.Put(w.keys.EncodeKey(k), data)
+}
+
+// Flush commits every buffered write.
+func (w *TypedBatchWriter[K, V]) Flush() error {
+	return w.writer.Flush()
+}
+
+// Stats returns the writer's commit metrics so far.
+func (w *TypedBatchWriter[K, V]) Stats() BatchStats {
+	return w.writer.Stats()
+}
+
+// Close commits every buffered write and stops the writer.
+func (w *TypedBatchWriter[K, V]) Close() error {
+	return w.writer.Close()
+}
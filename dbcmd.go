@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"golang.org/x/exp/slog"
+)
+
+// How long database commands wait for a scan holding the database open.
+const dbLockTimeout = 5 * time.Second
+
+// Bytes copied per transaction while compacting.
+const compactTxMaxSize = 64 << 20
+
+var dbCommand = &command{
+	name:    "db",
+	args:    "<subcommand> [args]",
+	summary: "back up, restore or compact the database",
+	help: `subcommands:
+  backup <file|->  write a consistent copy of the database to file, or stdout
+  restore <file>   replace the database with a backup, keeping the old one
+  compact          rewrite the database to reclaim space freed by deletions
+
+backup waits for a running scan to release the database, failing after a few
+seconds. To back up the database during a scan, send the scan SIGUSR1.
+`,
+	run: func(c *config.Config, args []string) error {
+		if len(args) == 0 {
+			return usageError("missing subcommand")
+		}
+		switch cmd, args := args[0], args[1:]; {
+		case cmd == "backup" && len(args) == 1:
+			return backupDB(c, args[0])
+		case cmd == "restore" && len(args) == 1:
+			return restoreDB(c, args[0])
+		case cmd == "compact" && len(args) == 0:
+			return compactDB(c)
+		default:
+			return usageError(fmt.Sprintf("bad subcommand: %s", strings.Join(append([]string{cmd}, args...), " ")))
+		}
+	},
+}
+
+func backupDB(c *config.Config, dest string) (err error) {
+	var w io.Writer = os.Stdout
+	if dest != "-" {
+		f, ferr := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			f.Close()
+			if err != nil {
+				os.Remove(dest)
+			}
+		}()
+		w = f
+	}
+	d, err := db.OpenReadOnly(c.DBFile, dbLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	start := time.Now()
+	n, err := d.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && dest != "-" {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	slog.Info("Done backing up database", "path", c.DBFile, "backup", dest,
+		"bytes", n, "elapsed", time.Since(start))
+	return nil
+}
+
+func restoreDB(c *config.Config, backup string) error {
+	// Make sure no scan has the database open before swapping it out.
+	if _, err := os.Stat(c.DBFile); err == nil {
+		d, err := db.OpenReadOnly(c.DBFile, dbLockTimeout)
+		if err != nil {
+			return err
+		}
+		d.Close()
+	}
+	previous, err := db.Restore(c.DBFile, backup)
+	if err != nil {
+		return err
+	}
+	slog.Info("Done restoring database", "path", c.DBFile, "backup", backup, "previous", previous)
+	return nil
+}
+
+func compactDB(c *config.Config) error {
+	start := time.Now()
+	before, after, err := db.Compact(c.DBFile, compactTxMaxSize)
+	if err != nil {
+		return err
+	}
+	slog.Info("Done compacting database", "path", c.DBFile, "before", before, "after", after,
+		"elapsed", time.Since(start))
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/digest"
+	"golang.org/x/exp/slog"
+)
+
+// Name of the bucket holding the duplicate groups found by the dedup engine.
+const duplicatesBucket = "duplicates"
+
+// Largest DedupConfig.PartialHashKB, reading 1 GiB at each end of a file.
+const maxPartialHashKB = 1 << 20
+
+// A DuplicateMember identifies one copy of a duplicated file.
+type DuplicateMember struct {
+	Provider string `json:"provider"`
+	Path     string `json:"path"`
+}
+
+// A DuplicateGroup is a set of files confirmed to have identical contents.
+type DuplicateGroup struct {
+	// Full content digest shared by all members, as "<algorithm>:<hex>".
+	Hash    string            `json:"hash"`
+	Size    int64             `json:"size"`
+	Members []DuplicateMember `json:"members"`
+}
+
+// Key returns the duplicates bucket key the group is stored under.
+func (g *DuplicateGroup) Key() []byte {
+	return []byte(g.Members[0].Provider + "/" + g.Hash)
+}
+
+// Wasted returns the bytes that would be reclaimed by keeping a single copy.
+func (g *DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Members)-1)
+}
+
+// -----------------------------------------------------------------------------
+// dedupEngine finds exact duplicates within a context's records in stages, so
+// that only files still colliding after each cheap stage pay for the next:
+//
+//  1. group records by size,
+//  2. hash the first and last PartialHashKB of same-size candidates, unless
+//     zero,
+//  3. fully hash the files whose partial hashes still collide.
+//
+// Full hashes already stored by the scan are reused rather than recomputed.
+type dedupEngine struct {
+	algo        digest.Algorithm
+	partialSize int64
+}
+
+func newDedupEngine(conf config.DedupConfig) (*dedupEngine, error) {
+	algos, err := digest.ParseAlgorithms([]string{conf.Algorithm})
+	if err != nil {
+		return nil, err
+	}
+	if len(algos) != 1 {
+		return nil, fmt.Errorf("dedup requires exactly one algorithm, got %q", conf.Algorithm)
+	}
+	if conf.PartialHashKB < 0 || conf.PartialHashKB > maxPartialHashKB {
+		return nil, fmt.Errorf("dedup partial hash must be within 0-%d KiB, got %d", maxPartialHashKB, conf.PartialHashKB)
+	}
+	return &dedupEngine{
+		algo:        algos[0],
+		partialSize: int64(conf.PartialHashKB) * 1024,
+	}, nil
+}
+
+// findDuplicates runs the dedup engine over the contexts and replaces their
+// groups in the duplicates bucket with the groups it finds. Other providers'
+// groups are kept, unless all is set, which empties the bucket first.
+func findDuplicates(d *db.DB, contexts []*StorageStrategyContext, conf config.DedupConfig, all bool) error {
+	e, err := newDedupEngine(conf)
+	if err != nil {
+		return err
+	}
+	var b *db.Bucket
+	if all {
+		b, err = d.Recreate([]byte(duplicatesBucket))
+	} else {
+		b, err = d.Bucket([]byte(duplicatesBucket))
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range contexts {
+		groups, err := e.run(c)
+		if err != nil {
+			slog.Error("dedup failed", err, "provider", c.name)
+			continue
+		}
+		if err := replaceDuplicateGroups(d, b, c.name, groups); err != nil {
+			return err
+		}
+		var wasted int64
+		for _, g := range groups {
+			wasted += g.Wasted()
+		}
+		slog.Info("Done finding duplicates", "provider", c.name,
+			"#groups", len(groups), "wasted", wasted)
+	}
+	return nil
+}
+
+// replaceDuplicateGroups replaces the groups of provider in bucket b with
+// groups, within a single transaction.
+func replaceDuplicateGroups(d *db.DB, b *db.Bucket, provider string, groups []*DuplicateGroup) error {
+	old, err := b.PrefixItems([]byte(provider + "/"))
+	if err != nil {
+		return err
+	}
+	codec := db.JSONCodec[*DuplicateGroup]{}
+	return d.Tx(func(tx *db.Tx) error {
+		tb, err := tx.Bucket(b)
+		if err != nil {
+			return err
+		}
+		for _, item := range old {
+			if err := tb.Delete(item.Key); err != nil {
+				return err
+			}
+		}
+		for _, g := range groups {
+			v, err := codec.Encode(g)
+			if err != nil {
+				return err
+			}
+			if err := tb.Put(g.Key(), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// run returns the duplicate groups among the records in c's bucket. Same-size
+// candidates are read from the bucket's size index, so only records sharing a
+// size with another are decoded.
+func (e *dedupEngine) run(c *StorageStrategyContext) ([]*DuplicateGroup, error) {
+	var runs [][][]byte
+	var last []byte
+	// Empty files are trivially identical and not worth reporting, so
+	// their records aren't even decoded.
+	empty := sizeKey(0)
+	err := c.bucket.MapIndex(sizeIndex, func(ik, k []byte) error {
+		if bytes.Equal(ik, empty) {
+			return nil
+		}
+		if !bytes.Equal(ik, last) {
+			runs = append(runs, nil)
+			last = ik
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ps, _ := c.storageStrategy.(ProviderStrategy)
+	var groups []*DuplicateGroup
+	for _, keys := range runs {
+		if len(keys) < 2 {
+			continue
+		}
+		var candidates []*FileRecord
+		for _, k := range keys {
+			r, err := c.record(k)
+			if err != nil {
+				slog.Error("skipping unreadable record", err, "key", string(k))
+				continue
+			}
+			candidates = append(candidates, r)
+		}
+		candidates = distinctFiles(candidates)
+		if len(candidates) < 2 {
+			continue
+		}
+		size := candidates[0].Size
+		for _, same := range e.confirm(c, ps, size, candidates) {
+			g := &DuplicateGroup{Hash: string(e.algo) + ":" + same[0].Hashes[string(e.algo)], Size: size}
+			for _, r := range same {
+				g.Members = append(g.Members, DuplicateMember{c.name, r.Path})
+			}
+			sort.Slice(g.Members, func(i, j int) bool { return g.Members[i].Path < g.Members[j].Path })
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Hash < groups[j].Hash })
+	return groups, nil
+}
+
+// distinctFiles drops the records of hard links to a file already recorded
+// under another path, which share its contents without wasting any space,
+// keeping the first of them.
+func distinctFiles(records []*FileRecord) []*FileRecord {
+	type fileID struct{ dev, ino uint64 }
+	seen := make(map[fileID]bool)
+	distinct := records[:0]
+	for _, r := range records {
+		if r.Inode != 0 {
+			id := fileID{r.Dev, r.Inode}
+			if seen[id] {
+				slog.Debug("skipping hard link", "path", r.Path)
+				continue
+			}
+			seen[id] = true
+		}
+		distinct = append(distinct, r)
+	}
+	return distinct
+}
+
+// confirm narrows a set of same-size candidates down to sets of records with
+// identical full hashes. The partial-hash stage is skipped where full hashes
+// are known, or cheap to get from the provider, and when it's disabled or
+// would read whole files.
+func (e *dedupEngine) confirm(c *StorageStrategyContext, ps ProviderStrategy,
+	size int64, candidates []*FileRecord) [][]*FileRecord {
+	colliding := [][]*FileRecord{candidates}
+	if e.partialSize > 0 && size > 2*e.partialSize && !allHashed(candidates, e.algo) && ps != nil &&
+		!ps.Provider().Capabilities().HasServerHash(e.algo) {
+		colliding = groupBy(candidates, func(r *FileRecord) (string, error) {
+			return e.partialHash(ps, r)
+		})
+	}
+	var confirmed [][]*FileRecord
+	for _, set := range colliding {
+		confirmed = append(confirmed, groupBy(set, func(r *FileRecord) (string, error) {
+			return e.fullHash(c, ps, r)
+		})...)
+	}
+	return confirmed
+}
+
+func allHashed(records []*FileRecord, algo digest.Algorithm) bool {
+	for _, r := range records {
+		if !r.HasHashes([]digest.Algorithm{algo}) {
+			return false
+		}
+	}
+	return true
+}
+
+// partialHash digests the first and last partialSize bytes of a file.
+func (e *dedupEngine) partialHash(ps ProviderStrategy, r *FileRecord) (string, error) {
+	path, err := ps.ProviderPath(r.Path)
+	if err != nil {
+		return "", err
+	}
+	f, err := ps.Provider().Open(context.Background(), path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, e.partialSize); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(-e.partialSize, io.SeekEnd); err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(h, f, e.partialSize); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fullHash returns the record's stored full hash, computing and persisting it
+// first if the scan didn't.
+func (e *dedupEngine) fullHash(c *StorageStrategyContext, ps ProviderStrategy, r *FileRecord) (string, error) {
+	if sum := r.Hashes[string(e.algo)]; sum != "" {
+		return sum, nil
+	}
+	if ps == nil {
+		return "", fmt.Errorf("provider %s cannot read %s", c.name, r.Path)
+	}
+	path, err := ps.ProviderPath(r.Path)
+	if err != nil {
+		return "", err
+	}
+	sum, err := ps.Provider().Hash(context.Background(), path, e.algo)
+	if err != nil {
+		return "", err
+	}
+	if r.Hashes == nil {
+		r.Hashes = make(map[string]string)
+	}
+	r.Hashes[string(e.algo)] = sum
+	if err := c.putRecord(r); err != nil {
+		slog.Error("cannot store file record", err, "path", r.Path)
+	}
+	return sum, nil
+}
+
+// groupBy partitions records by the key returned from `by`, keeping only the
+// partitions with more than one member. Records whose key can't be computed
+// are logged and dropped.
+func groupBy(records []*FileRecord, by func(*FileRecord) (string, error)) [][]*FileRecord {
+	groups := make(map[string][]*FileRecord)
+	var keys []string
+	for _, r := range records {
+		k, err := by(r)
+		if err != nil {
+			slog.Error("cannot hash file", err, "path", r.Path)
+			continue
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+	var sets [][]*FileRecord
+	for _, k := range keys {
+		if len(groups[k]) > 1 {
+			sets = append(sets, groups[k])
+		}
+	}
+	return sets
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+)
+
+func TestDedupPartialHashKB(t *testing.T) {
+	// a and b are duplicates, c differs from them in the middle only, which
+	// partial hashes don't read, and d at each end.
+	root := t.TempDir()
+	body := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	variant := func(i int, b byte) []byte {
+		v := append([]byte(nil), body...)
+		v[i] = b
+		return v
+	}
+	for name, data := range map[string][]byte{
+		"a": body,
+		"b": body,
+		"c": variant(len(body)/2, '!'),
+		"d": variant(0, '!'),
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := LocalStrategy{config.LocalConfig{RootPath: root}}
+	d := db.OpenMemory()
+	defer d.Close()
+	b, err := openRecordBucket(d, "local", s.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewStorageStrategyContext(s, "local")
+	c.SetBucket(b)
+	c.SetSession("s1")
+	c.SetBatchOptions(db.BatchOptions{Size: 10})
+	if stats := c.scanTree(context.Background()); stats.Files != 4 || stats.Errors != 0 {
+		t.Fatalf("scan counted %d files and %d errors", stats.Files, stats.Errors)
+	}
+
+	for _, kb := range []int{0, 1, 4, 8, 64, maxPartialHashKB} {
+		e, err := newDedupEngine(config.DedupConfig{Algorithm: "sha256", PartialHashKB: kb})
+		if err != nil {
+			t.Fatalf("%d KiB: %v", kb, err)
+		}
+		groups, err := e.run(c)
+		if err != nil {
+			t.Fatalf("%d KiB: %v", kb, err)
+		}
+		var got [][]string
+		for _, g := range groups {
+			var members []string
+			for _, m := range g.Members {
+				members = append(members, filepath.Base(m.Path))
+			}
+			got = append(got, members)
+		}
+		if want := [][]string{{"a", "b"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d KiB: found %q, want %q", kb, got, want)
+		}
+	}
+
+	for _, kb := range []int{-1, maxPartialHashKB + 1} {
+		conf := config.DedupConfig{Algorithm: "sha256", PartialHashKB: kb}
+		if _, err := newDedupEngine(conf); err == nil {
+			t.Errorf("dedup engine accepted %d KiB", kb)
+		}
+		var found bool
+		for _, p := range checkConfig(&config.Config{Dedup: conf}) {
+			found = found || strings.HasPrefix(p, "dedup partial hash:")
+		}
+		if !found {
+			t.Errorf("config check accepted %d KiB", kb)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package digest computes cryptographic content digests used to identify
+// files with identical contents.
+package digest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Algorithm names a supported digest algorithm. The name doubles as the key
+// under which a digest is stored alongside a file record.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256"
+	BLAKE3 Algorithm = "blake3"
+	// MD5 is not collision resistant, but is what several providers (eg:
+	// SmugMug's ArchivedMD5) report, so it's needed to match their files.
+	MD5 Algorithm = "md5"
+)
+
+// New returns a fresh hash.Hash for the algorithm.
+func New(a Algorithm) (hash.Hash, error) {
+	switch a {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(32, nil), nil
+	case MD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %q", a)
+	}
+}
+
+// ParseAlgorithms converts config strings (eg: "sha256", "BLAKE3") into
+// Algorithms, rejecting unknown names.
+func ParseAlgorithms(names []string) ([]Algorithm, error) {
+	var algos []Algorithm
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" {
+			continue
+		}
+		a := Algorithm(n)
+		if _, err := New(a); err != nil {
+			return nil, err
+		}
+		algos = append(algos, a)
+	}
+	return algos, nil
+}
+
+// Sum reads r to EOF, feeding every requested algorithm in a single pass, and
+// returns the hex-encoded digests keyed by algorithm name.
+func Sum(r io.Reader, algos []Algorithm) (map[string]string, error) {
+	hashes := make([]hash.Hash, len(algos))
+	writers := make([]io.Writer, len(algos))
+	for i, a := range algos {
+		h, err := New(a)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+		writers[i] = h
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string, len(algos))
+	for i, a := range algos {
+		sums[string(a)] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums, nil
+}
+
+// SumFile opens the file at path and returns its digests.
+func SumFile(path string, algos []Algorithm) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Sum(f, algos)
+}
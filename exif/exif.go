@@ -0,0 +1,258 @@
+// Package exif is a pure Go reader for the EXIF metadata embedded in JPEG
+// files and TIFF-based images, including most camera raw formats.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoExif is returned for images that carry no EXIF metadata.
+var ErrNoExif = errors.New("no EXIF metadata")
+
+// Metadata holds the EXIF fields of an image useful for picking the best of
+// several duplicates and for organizing images by when they were taken.
+type Metadata struct {
+	// When the picture was taken. EXIF times without an offset are wall
+	// clock times in an unknown zone, and are reported as UTC.
+	CaptureTime *time.Time `json:"capture_time,omitempty"`
+	Make        string     `json:"make,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	Lens        string     `json:"lens,omitempty"`
+	// Orientation is the EXIF orientation (1-8), or 0 if unspecified.
+	Orientation int  `json:"orientation,omitempty"`
+	Width       int  `json:"width,omitempty"`
+	Height      int  `json:"height,omitempty"`
+	GPS         *GPS `json:"gps,omitempty"`
+}
+
+// GPS holds a location in decimal degrees, and meters above sea level.
+type GPS struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Altitude  float64 `json:"alt,omitempty"`
+}
+
+// Tags read from IFD0, the Exif sub-IFD and the GPS sub-IFD.
+const (
+	tagImageWidth        = 0x0100
+	tagImageLength       = 0x0101
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagOffsetTimeOrig    = 0x9011
+	tagSubSecTimeOrig    = 0x9291
+	tagPixelXDimension   = 0xA002
+	tagPixelYDimension   = 0xA003
+	tagLensMake          = 0xA433
+	tagLensModel         = 0xA434
+
+	tagGPSLatitudeRef  = 0x1
+	tagGPSLatitude     = 0x2
+	tagGPSLongitudeRef = 0x3
+	tagGPSLongitude    = 0x4
+	tagGPSAltitudeRef  = 0x5
+	tagGPSAltitude     = 0x6
+)
+
+// File reads the EXIF metadata of the image at path.
+func File(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// Decode reads the EXIF metadata of a JPEG or TIFF-based image.
+func Decode(r io.ReaderAt) (*Metadata, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrNoExif
+		}
+		return nil, err
+	}
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		payload, err := jpegExif(io.NewSectionReader(r, 0, 1<<62))
+		if err != nil {
+			return nil, err
+		}
+		return decodeTIFF(bytes.NewReader(payload))
+	case string(magic[:2]) == "II" || string(magic[:2]) == "MM":
+		return decodeTIFF(r)
+	default:
+		return nil, ErrNoExif
+	}
+}
+
+// jpegExif returns the TIFF structure held in a JPEG's APP1 Exif segment.
+func jpegExif(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr[:2]); err != nil {
+		return nil, err
+	}
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, ErrNoExif
+			}
+			return nil, err
+		}
+		if hdr[0] != 0xFF {
+			return nil, ErrNoExif
+		}
+		marker := hdr[1]
+		// Start of scan or end of image: metadata segments come before both.
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoExif
+		}
+		length := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if length < 0 {
+			return nil, ErrNoExif
+		}
+		seg := make([]byte, length)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return nil, err
+		}
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:], nil
+		}
+	}
+}
+
+func decodeTIFF(r io.ReaderAt) (*Metadata, error) {
+	t, offset, err := newTIFF(r)
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := t.readIFD(offset)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{
+		Make:  t.string(ifd0[tagMake]),
+		Model: t.string(ifd0[tagModel]),
+	}
+	if v, err := t.uint(ifd0[tagOrientation], 0); err == nil {
+		m.Orientation = int(v)
+	}
+	if v, err := t.uint(ifd0[tagImageWidth], 0); err == nil {
+		m.Width = int(v)
+	}
+	if v, err := t.uint(ifd0[tagImageLength], 0); err == nil {
+		m.Height = int(v)
+	}
+	captured := t.string(ifd0[tagDateTime])
+	var offsetTime, subSec string
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if off, err := t.uint(e, 0); err == nil {
+			if sub, err := t.readIFD(off); err == nil {
+				for _, tag := range []uint16{tagDateTimeDigitized, tagDateTimeOriginal} {
+					if s := t.string(sub[tag]); s != "" {
+						captured = s
+					}
+				}
+				offsetTime = t.string(sub[tagOffsetTimeOrig])
+				subSec = t.string(sub[tagSubSecTimeOrig])
+				if v, err := t.uint(sub[tagPixelXDimension], 0); err == nil {
+					m.Width = int(v)
+				}
+				if v, err := t.uint(sub[tagPixelYDimension], 0); err == nil {
+					m.Height = int(v)
+				}
+				m.Lens = t.string(sub[tagLensModel])
+				if lm := t.string(sub[tagLensMake]); lm != "" && m.Lens != "" &&
+					!strings.HasPrefix(m.Lens, lm) {
+					m.Lens = lm + " " + m.Lens
+				}
+			}
+		}
+	}
+	if ts, ok := parseTime(captured, subSec, offsetTime); ok {
+		m.CaptureTime = &ts
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if off, err := t.uint(e, 0); err == nil {
+			if gps, err := t.readIFD(off); err == nil {
+				m.GPS = decodeGPS(t, gps)
+			}
+		}
+	}
+	return m, nil
+}
+
+func decodeGPS(t *tiff, gps map[uint16]entry) *GPS {
+	lat, err := degrees(t, gps[tagGPSLatitude])
+	if err != nil {
+		return nil
+	}
+	lon, err := degrees(t, gps[tagGPSLongitude])
+	if err != nil {
+		return nil
+	}
+	if t.string(gps[tagGPSLatitudeRef]) == "S" {
+		lat = -lat
+	}
+	if t.string(gps[tagGPSLongitudeRef]) == "W" {
+		lon = -lon
+	}
+	g := &GPS{Latitude: lat, Longitude: lon}
+	if alt, err := t.rational(gps[tagGPSAltitude], 0); err == nil {
+		if ref, err := t.uint(gps[tagGPSAltitudeRef], 0); err == nil && ref == 1 {
+			alt = -alt
+		}
+		g.Altitude = alt
+	}
+	return g
+}
+
+// degrees converts an EXIF degrees/minutes/seconds triple to decimal degrees.
+func degrees(t *tiff, e entry) (float64, error) {
+	var dms [3]float64
+	for i := range dms {
+		v, err := t.rational(e, i)
+		if err != nil {
+			return 0, err
+		}
+		dms[i] = v
+	}
+	return dms[0] + dms[1]/60 + dms[2]/3600, nil
+}
+
+// parseTime parses an EXIF "YYYY:MM:DD HH:MM:SS" time with optional
+// sub-second digits and "+HH:MM" offset.
+func parseTime(s, subSec, offset string) (time.Time, bool) {
+	if s == "" || strings.HasPrefix(s, "0000") {
+		return time.Time{}, false
+	}
+	if subSec != "" {
+		s += "." + subSec
+	}
+	loc := time.UTC
+	if offset != "" {
+		if o, err := time.Parse("-07:00", offset); err == nil {
+			_, secs := o.Zone()
+			loc = time.FixedZone(offset, secs)
+		}
+	}
+	ts, err := time.ParseInLocation("2006:01:02 15:04:05", s, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
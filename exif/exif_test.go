@@ -0,0 +1,177 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	tests := []struct {
+		file     string
+		want     Metadata
+		captured string
+		gps      *GPS
+	}{
+		{
+			file: "canon.jpg",
+			want: Metadata{Make: "Canon", Model: "Canon EOS 5D Mark IV", Lens: "Canon EF50mm f/1.8 STM",
+				Orientation: 6, Width: 16, Height: 16},
+			// DateTimeOriginal with its sub-seconds and offset, not DateTime.
+			captured: "2019-07-04T10:11:12.25-07:00",
+			gps:      &GPS{Latitude: 37.808333, Longitude: -122.42, Altitude: 10.5},
+		},
+		{
+			file:     "nikon.tif",
+			want:     Metadata{Make: "NIKON CORPORATION", Model: "NIKON D750", Orientation: 1, Width: 8, Height: 8},
+			captured: "2020-01-02T03:04:05Z",
+			gps:      &GPS{Latitude: -33.865, Longitude: 151.21, Altitude: -5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			m, err := File(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := *m
+			got.CaptureTime, got.GPS = nil, nil
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if m.CaptureTime == nil || m.CaptureTime.Format(time.RFC3339Nano) != tt.captured {
+				t.Errorf("capture time %v, want %s", m.CaptureTime, tt.captured)
+			}
+			if m.GPS == nil {
+				t.Fatalf("no GPS, want %+v", tt.gps)
+			}
+			for _, c := range []struct{ got, want float64 }{
+				{m.GPS.Latitude, tt.gps.Latitude},
+				{m.GPS.Longitude, tt.gps.Longitude},
+				{m.GPS.Altitude, tt.gps.Altitude},
+			} {
+				if math.Abs(c.got-c.want) > 1e-6 {
+					t.Errorf("GPS %+v, want %+v", *m.GPS, *tt.gps)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeNoExif(t *testing.T) {
+	jpg, err := os.ReadFile(filepath.Join("testdata", "noexif.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"jpeg without APP1", jpg},
+		{"empty", nil},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")},
+		{"tiff with bad magic", []byte("II\x2b\x00\x08\x00\x00\x00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(bytes.NewReader(tt.data)); !errors.Is(err, ErrNoExif) {
+				t.Errorf("got %v, want %v", err, ErrNoExif)
+			}
+		})
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	jpg, err := os.ReadFile(filepath.Join("testdata", "canon.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"APP1 cut short", jpg[:40]},
+		{"IFD past the end", []byte("II\x2a\x00\xff\x00\x00\x00")},
+		{"too many entries", append([]byte("II\x2a\x00\x08\x00\x00\x00"), le.AppendUint16(nil, maxEntries+1)...)},
+		{"entries cut short", append([]byte("II\x2a\x00\x08\x00\x00\x00"), 2, 0, 0x0f, 0x01)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := Decode(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("got %+v, want an error", m)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		s, subSec, offset string
+		want              string // RFC 3339, or empty if unparsable
+	}{
+		{"2021:12:31 23:59:58", "", "", "2021-12-31T23:59:58Z"},
+		{"2021:12:31 23:59:58", "5", "+05:30", "2021-12-31T23:59:58.5+05:30"},
+		{"2021:12:31 23:59:58", "", "garbage", "2021-12-31T23:59:58Z"},
+		{"0000:00:00 00:00:00", "", "", ""},
+		{"", "", "", ""},
+		{"31/12/2021", "", "", ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseTime(tt.s, tt.subSec, tt.offset)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("parseTime(%q, %q, %q) = %v, want none", tt.s, tt.subSec, tt.offset, got)
+			}
+			continue
+		}
+		if !ok || got.Format(time.RFC3339Nano) != tt.want {
+			t.Errorf("parseTime(%q, %q, %q) = %v, %v, want %s", tt.s, tt.subSec, tt.offset, got, ok, tt.want)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, file := range []string{"canon.jpg", "nikon.tif", "noexif.jpg"} {
+		data, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Decode(bytes.NewReader(data))
+	})
+}
+
+func FuzzReadIFD(f *testing.F) {
+	data, err := os.ReadFile(filepath.Join("testdata", "nikon.tif"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data, uint32(8), true)
+	f.Add([]byte("\x01\x00\x0f\x01\x02\x00\xff\xff\xff\xff\x00\x00\x00\x00"), uint32(0), false)
+	f.Fuzz(func(t *testing.T, data []byte, offset uint32, bigEndian bool) {
+		tf := &tiff{r: bytes.NewReader(data), order: binary.LittleEndian}
+		if bigEndian {
+			tf.order = binary.BigEndian
+		}
+		entries, err := tf.readIFD(offset)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			for i := 0; i <= int(e.count) && i < 16; i++ {
+				tf.uint(e, i)
+				tf.rational(e, i)
+			}
+			tf.string(e)
+		}
+	})
+}
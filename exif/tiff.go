@@ -0,0 +1,165 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TIFF field types.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+var typeSizes = map[uint16]uint32{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+const (
+	// Limits guarding against corrupt or hostile files.
+	maxEntries    = 1024
+	maxValueBytes = 64 * 1024
+)
+
+// tiff reads IFDs from a TIFF structure, whose offsets are relative to the
+// start of r.
+type tiff struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+// An entry is one field of an IFD.
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	raw   []byte
+}
+
+func newTIFF(r io.ReaderAt) (*tiff, uint32, error) {
+	hdr := make([]byte, 8)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, 0, err
+	}
+	t := &tiff{r: r}
+	switch string(hdr[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, ErrNoExif
+	}
+	if t.order.Uint16(hdr[2:4]) != 42 {
+		return nil, 0, ErrNoExif
+	}
+	return t, t.order.Uint32(hdr[4:8]), nil
+}
+
+// readIFD returns the entries of the IFD at offset, keyed by tag.
+func (t *tiff) readIFD(offset uint32) (map[uint16]entry, error) {
+	buf := make([]byte, 2)
+	if _, err := t.r.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	n := t.order.Uint16(buf)
+	if n > maxEntries {
+		return nil, fmt.Errorf("IFD at %d has %d entries", offset, n)
+	}
+	buf = make([]byte, 12*int(n))
+	if _, err := t.r.ReadAt(buf, int64(offset)+2); err != nil {
+		return nil, err
+	}
+	entries := make(map[uint16]entry, n)
+	for i := 0; i < int(n); i++ {
+		e := buf[i*12 : i*12+12]
+		en := entry{
+			tag:   t.order.Uint16(e[0:2]),
+			typ:   t.order.Uint16(e[2:4]),
+			count: t.order.Uint32(e[4:8]),
+		}
+		size, ok := typeSizes[en.typ]
+		if !ok || en.count == 0 || uint64(size)*uint64(en.count) > maxValueBytes {
+			continue
+		}
+		length := size * en.count
+		if length <= 4 {
+			en.raw = e[8 : 8+length]
+		} else {
+			en.raw = make([]byte, length)
+			if _, err := t.r.ReadAt(en.raw, int64(t.order.Uint32(e[8:12]))); err != nil {
+				continue
+			}
+		}
+		entries[en.tag] = en
+	}
+	return entries, nil
+}
+
+// errType is returned when an entry's type doesn't suit the requested value.
+var errType = errors.New("unexpected TIFF field type")
+
+func (t *tiff) uint(e entry, i int) (uint32, error) {
+	if uint32(i) >= e.count {
+		return 0, io.ErrUnexpectedEOF
+	}
+	switch e.typ {
+	case typeByte, typeUndefined:
+		return uint32(e.raw[i]), nil
+	case typeShort:
+		return uint32(t.order.Uint16(e.raw[2*i:])), nil
+	case typeLong, typeSLong:
+		return t.order.Uint32(e.raw[4*i:]), nil
+	default:
+		return 0, errType
+	}
+}
+
+func (t *tiff) rational(e entry, i int) (float64, error) {
+	if uint32(i) >= e.count {
+		return 0, io.ErrUnexpectedEOF
+	}
+	switch e.typ {
+	case typeRational:
+		num, den := t.order.Uint32(e.raw[8*i:]), t.order.Uint32(e.raw[8*i+4:])
+		if den == 0 {
+			return 0, nil
+		}
+		return float64(num) / float64(den), nil
+	case typeSRational:
+		num, den := int32(t.order.Uint32(e.raw[8*i:])), int32(t.order.Uint32(e.raw[8*i+4:]))
+		if den == 0 {
+			return 0, nil
+		}
+		return float64(num) / float64(den), nil
+	default:
+		return 0, errType
+	}
+}
+
+func (t *tiff) string(e entry) string {
+	if e.typ != typeASCII && e.typ != typeUndefined {
+		return ""
+	}
+	s := string(e.raw)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"golang.org/x/exp/slog"
+)
+
+var exportCommand = &command{
+	name:    "export",
+	args:    "<format> <dest>",
+	summary: "export file records, duplicate groups and scan statistics",
+	help: `Exports the database for ad-hoc analysis, never overwriting existing files.
+See docs/export.md for the schema.
+
+formats:
+  csv     one <table>.csv file per table, in directory dest
+  ndjson  one <table>.ndjson file per table, in directory dest
+  sqlite  a SQLite database file dest
+`,
+	run: func(c *config.Config, args []string) error {
+		if len(args) != 2 {
+			return usageError("expected a format and a destination")
+		}
+		format, dest := args[0], args[1]
+		switch format {
+		case "csv", "ndjson", "sqlite":
+		default:
+			return usageError(fmt.Sprintf("unknown export format %q", format))
+		}
+		return exportDB(c, format, dest)
+	},
+}
+
+// An exportColumn is a column of an exported table, with its SQLite type.
+type exportColumn struct {
+	name string
+	typ  string // TEXT, INTEGER or REAL
+}
+
+// An exportTable describes a table written by the export command. Values of
+// its rows are strings, int64s, float64s or nil for missing values.
+type exportTable struct {
+	name    string
+	columns []exportColumn
+	key     []string
+	// indexes lists the columns of each index of the SQLite table.
+	indexes [][]string
+}
+
+// Tables written by the export command. docs/export.md documents them, and
+// must be kept in sync.
+var (
+	filesTable = &exportTable{
+		name: "files",
+		columns: []exportColumn{
+			{"provider", "TEXT"}, {"root", "TEXT"}, {"path", "TEXT"},
+			{"size", "INTEGER"}, {"mode", "TEXT"}, {"mtime", "TEXT"}, {"ctime", "TEXT"},
+			{"mime", "TEXT"}, {"category", "TEXT"}, {"session", "TEXT"},
+			{"dev", "INTEGER"}, {"inode", "INTEGER"},
+			{"captured", "TEXT"}, {"camera_make", "TEXT"}, {"camera_model", "TEXT"},
+			{"width", "INTEGER"}, {"height", "INTEGER"}, {"duration", "REAL"},
+			{"latitude", "REAL"}, {"longitude", "REAL"},
+			{"error", "TEXT"},
+		},
+		key:     []string{"provider", "root", "path"},
+		indexes: [][]string{{"size"}, {"category"}, {"captured"}},
+	}
+	hashesTable = &exportTable{
+		name: "file_hashes",
+		columns: []exportColumn{
+			{"provider", "TEXT"}, {"root", "TEXT"}, {"path", "TEXT"},
+			{"source", "TEXT"}, {"algorithm", "TEXT"}, {"value", "TEXT"},
+		},
+		key:     []string{"provider", "root", "path", "source", "algorithm"},
+		indexes: [][]string{{"algorithm", "value"}},
+	}
+	groupsTable = &exportTable{
+		name: "duplicate_groups",
+		columns: []exportColumn{
+			{"kind", "TEXT"}, {"group_id", "TEXT"}, {"hash", "TEXT"},
+			{"size", "INTEGER"}, {"members", "INTEGER"}, {"wasted", "INTEGER"},
+		},
+		key: []string{"kind", "group_id"},
+	}
+	membersTable = &exportTable{
+		name: "duplicate_members",
+		columns: []exportColumn{
+			{"kind", "TEXT"}, {"group_id", "TEXT"}, {"provider", "TEXT"}, {"path", "TEXT"},
+		},
+		key:     []string{"kind", "group_id", "provider", "path"},
+		indexes: [][]string{{"provider", "path"}},
+	}
+	scansTable = &exportTable{
+		name: "scans",
+		columns: []exportColumn{
+			{"session", "TEXT"}, {"provider", "TEXT"}, {"root", "TEXT"},
+			{"started", "TEXT"}, {"finished", "TEXT"},
+			{"nodes", "INTEGER"}, {"files", "INTEGER"}, {"unchanged", "INTEGER"}, {"removed", "INTEGER"}, {"errors", "INTEGER"},
+		},
+		key: []string{"session", "provider", "root"},
+	}
+	exportTables = []*exportTable{filesTable, hashesTable, groupsTable, membersTable, scansTable}
+)
+
+// Kinds of duplicate groups in the duplicate tables.
+const (
+	exactGroup         = "exact"
+	crossProviderGroup = "cross-provider"
+	nearGroup          = "near"
+)
+
+// An exportSink writes the rows of every table in some format.
+type exportSink interface {
+	row(t *exportTable, values []any) error
+	// close finishes writing the tables.
+	close() error
+	// abort stops writing and removes what was written.
+	abort()
+}
+
+func newExportSink(format, dest string) (exportSink, error) {
+	switch format {
+	case "csv":
+		return newDirSink(dest, "csv", newCSVEncoder, exportTables)
+	case "ndjson":
+		return newDirSink(dest, "ndjson", newNDJSONEncoder, exportTables)
+	case "sqlite":
+		return newSQLiteSink(dest, exportTables)
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// An exporter converts stored records to rows, counting them by table.
+type exporter struct {
+	sink   exportSink
+	counts map[string]int
+}
+
+func (x *exporter) row(t *exportTable, values ...any) error {
+	for i, v := range values {
+		values[i] = exportValue(v)
+	}
+	x.counts[t.name]++
+	return x.sink.row(t, values)
+}
+
+func exportDB(c *config.Config, format, dest string) (err error) {
+	d, err := db.OpenReadOnly(c.DBFile, dbLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	sink, err := newExportSink(format, dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			sink.abort()
+		}
+	}()
+	start := time.Now()
+	x := &exporter{sink: sink, counts: make(map[string]int)}
+	if err := x.records(d); err != nil {
+		return err
+	}
+	if err := x.groups(d); err != nil {
+		return err
+	}
+	if err := x.scans(d); err != nil {
+		return err
+	}
+	if err := sink.close(); err != nil {
+		return err
+	}
+	args := []any{"path", c.DBFile, "format", format, "dest", dest}
+	for _, t := range exportTables {
+		args = append(args, "#"+t.name, x.counts[t.name])
+	}
+	slog.Info("Done exporting database", append(args, "elapsed", time.Since(start))...)
+	return nil
+}
+
+// Top-level buckets that don't hold a provider's records.
+var nonRecordBuckets = map[string]bool{
+	duplicatesBucket:     true,
+	crossProviderBucket:  true,
+	nearDuplicatesBucket: true,
+	scansBucket:          true,
+}
+
+// records exports the records of every root of every provider.
+func (x *exporter) records(d *db.DB) error {
+	providers, err := d.Buckets()
+	if err != nil {
+		return err
+	}
+	for _, provider := range providers {
+		if nonRecordBuckets[string(provider)] || db.IsMetaBucket(provider) {
+			continue
+		}
+		pb, err := d.OpenBucketPath(provider)
+		if err != nil {
+			return err
+		}
+		roots, err := pb.Buckets()
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			rb, err := d.OpenBucketPath(provider, root)
+			if err != nil {
+				return err
+			}
+			err = rb.Map(func(k, v []byte) error {
+				r, err := UnmarshalFileRecord(v)
+				if err != nil {
+					slog.Error("skipping undecodable record", err, "key", string(k))
+					return nil
+				}
+				return x.record(string(provider), string(root), r)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (x *exporter) record(provider, root string, r *FileRecord) error {
+	var captured *time.Time
+	var cameraMake, cameraModel string
+	var width, height, duration, lat, lon any
+	if e := r.Exif; e != nil {
+		captured, cameraMake, cameraModel = e.CaptureTime, e.Make, e.Model
+		width, height = nonZero(e.Width), nonZero(e.Height)
+		if e.GPS != nil {
+			lat, lon = e.GPS.Latitude, e.GPS.Longitude
+		}
+	}
+	if v := r.Video; v != nil {
+		if captured == nil {
+			captured = v.CreationTime
+		}
+		width, height, duration = nonZero(v.Width), nonZero(v.Height), v.Duration
+		if v.Location != nil {
+			lat, lon = v.Location.Latitude, v.Location.Longitude
+		}
+	}
+	var category string
+	if r.Category != 0 {
+		category = r.Category.String()
+	}
+	var mode string
+	if r.Error == "" || r.Mode != 0 {
+		mode = r.Mode.String()
+	}
+	err := x.row(filesTable, provider, root, r.Path,
+		r.Size, mode, r.ModTime, r.CTime,
+		r.MimeType, category, r.Session,
+		nonZero(r.Dev), nonZero(r.Inode),
+		captured, cameraMake, cameraModel,
+		width, height, duration,
+		lat, lon,
+		r.Error)
+	if err != nil {
+		return err
+	}
+	for _, src := range []struct {
+		name string
+		sums map[string]string
+	}{{"digest", r.Hashes}, {"checksum", r.Checksums}} {
+		for _, algo := range sortedKeys(src.sums) {
+			if err := x.row(hashesTable, provider, root, r.Path, src.name, algo, src.sums[algo]); err != nil {
+				return err
+			}
+		}
+	}
+	if h := r.ImageHashes; h != nil {
+		for _, ph := range []struct {
+			name string
+			hash uint64
+		}{{"ahash", h.AHash}, {"dhash", h.DHash}, {"phash", h.PHash}} {
+			err := x.row(hashesTable, provider, root, r.Path, "perceptual", ph.name, fmt.Sprintf("%016x", ph.hash))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// groups exports the groups found by the last dedup, correlation and
+// near-duplicate runs.
+func (x *exporter) groups(d *db.DB) error {
+	return forEachGroup(d, func(g *ReportGroup) error {
+		var size, wasted any = g.Size, g.Wasted
+		if g.Kind == nearGroup {
+			size, wasted = nil, nil
+		}
+		if err := x.row(groupsTable, g.Kind, g.ID, g.Hash, size, len(g.Members), wasted); err != nil {
+			return err
+		}
+		for _, m := range g.Members {
+			if err := x.row(membersTable, g.Kind, g.ID, m.Provider, m.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// openResults returns the named top-level bucket, or nil if it doesn't
+// exist yet.
+func openResults(d *db.DB, name string) (*db.Bucket, error) {
+	b, err := d.OpenBucketPath([]byte(name))
+	if errors.Is(err, db.ErrBucketNotFound) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (x *exporter) scans(d *db.DB) error {
+	return forEachScan(d, func(s *ScanStats) error {
+		return x.row(scansTable, s.Session, s.Provider, s.Root, s.Started, s.Finished,
+			s.Nodes, s.Files, s.Unchanged, s.Removed, s.Errors)
+	})
+}
+
+// exportValue converts a field to a value of an exported row: empty strings,
+// zero times and nil pointers are missing values, and times are written in
+// UTC as RFC 3339.
+func exportValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return v
+	case int:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return exportValue(*v)
+	default:
+		return v
+	}
+}
+
+// nonZero returns v, or nil for the zero value meaning it's unknown.
+func nonZero[T int | uint64](v T) any {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
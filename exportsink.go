@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// -----------------------------------------------------------------------------
+// dirSink writes each table to its own file in a directory, in a format
+// chosen by its encoder. Existing files are never overwritten.
+type dirSink struct {
+	dir     string
+	created bool
+	files   []*os.File
+	writers map[*exportTable]*bufio.Writer
+	encs    map[*exportTable]rowEncoder
+}
+
+// A rowEncoder writes the rows of a table to a file.
+type rowEncoder interface {
+	row(values []any) error
+	// flush writes any buffered row.
+	flush() error
+}
+
+func newDirSink(dir, ext string, newEncoder func(io.Writer, *exportTable) (rowEncoder, error),
+	tables []*exportTable) (_ *dirSink, err error) {
+	_, serr := os.Stat(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &dirSink{
+		dir:     dir,
+		created: os.IsNotExist(serr),
+		writers: make(map[*exportTable]*bufio.Writer),
+		encs:    make(map[*exportTable]rowEncoder),
+	}
+	defer func() {
+		if err != nil {
+			s.abort()
+		}
+	}()
+	for _, t := range tables {
+		f, err := os.OpenFile(filepath.Join(dir, t.name+"."+ext), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		s.files = append(s.files, f)
+		w := bufio.NewWriter(f)
+		enc, err := newEncoder(w, t)
+		if err != nil {
+			return nil, err
+		}
+		s.writers[t] = w
+		s.encs[t] = enc
+	}
+	return s, nil
+}
+
+func (s *dirSink) row(t *exportTable, values []any) error {
+	return s.encs[t].row(values)
+}
+
+func (s *dirSink) close() error {
+	var err error
+	for t, enc := range s.encs {
+		if ferr := enc.flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if ferr := s.writers[t].Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	for _, f := range s.files {
+		if ferr := f.Sync(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if ferr := f.Close(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+func (s *dirSink) abort() {
+	for _, f := range s.files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if s.created {
+		os.Remove(s.dir)
+	}
+}
+
+// csvEncoder writes a header of column names, then rows with missing values
+// left empty.
+type csvEncoder struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVEncoder(w io.Writer, t *exportTable) (rowEncoder, error) {
+	enc := &csvEncoder{w: csv.NewWriter(w), record: make([]string, len(t.columns))}
+	for i, c := range t.columns {
+		enc.record[i] = c.name
+	}
+	return enc, enc.w.Write(enc.record)
+}
+
+func (enc *csvEncoder) row(values []any) error {
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+			enc.record[i] = ""
+		case string:
+			enc.record[i] = v
+		case int64:
+			enc.record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			enc.record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			enc.record[i] = fmt.Sprint(v)
+		}
+	}
+	return enc.w.Write(enc.record)
+}
+
+func (enc *csvEncoder) flush() error {
+	enc.w.Flush()
+	return enc.w.Error()
+}
+
+// ndjsonEncoder writes a JSON object per row, with the table's columns in
+// order and missing values null.
+type ndjsonEncoder struct {
+	w    io.Writer
+	keys [][]byte
+	line []byte
+}
+
+func newNDJSONEncoder(w io.Writer, t *exportTable) (rowEncoder, error) {
+	enc := &ndjsonEncoder{w: w}
+	for _, c := range t.columns {
+		k, err := json.Marshal(c.name)
+		if err != nil {
+			return nil, err
+		}
+		enc.keys = append(enc.keys, k)
+	}
+	return enc, nil
+}
+
+func (enc *ndjsonEncoder) row(values []any) error {
+	line := append(enc.line[:0], '{')
+	for i, v := range values {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = append(append(line, enc.keys[i]...), ':')
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		line = append(line, b...)
+	}
+	line = append(line, '}', '\n')
+	enc.line = line
+	_, err := enc.w.Write(line)
+	return err
+}
+
+func (enc *ndjsonEncoder) flush() error {
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// sqliteSink writes every table to a new SQLite database file, in a single
+// transaction, then indexes them.
+type sqliteSink struct {
+	path   string
+	db     *sql.DB
+	tx     *sql.Tx
+	tables []*exportTable
+	stmts  map[*exportTable]*sql.Stmt
+}
+
+func newSQLiteSink(path string, tables []*exportTable) (_ *sqliteSink, err error) {
+	// sqlite would happily add tables to an existing database
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	s := &sqliteSink{path: path, tables: tables, stmts: make(map[*exportTable]*sql.Stmt)}
+	defer func() {
+		if err != nil {
+			s.abort()
+		}
+	}()
+	if s.db, err = sql.Open("sqlite", path); err != nil {
+		return nil, err
+	}
+	if s.tx, err = s.db.Begin(); err != nil {
+		return nil, err
+	}
+	for _, t := range tables {
+		cols := make([]string, len(t.columns))
+		params := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			cols[i] = c.name + " " + c.typ
+			params[i] = "?"
+		}
+		if len(t.key) > 0 {
+			cols = append(cols, "PRIMARY KEY ("+strings.Join(t.key, ", ")+")")
+		}
+		if _, err := s.tx.Exec(fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", t.name, strings.Join(cols, ",\n  "))); err != nil {
+			return nil, fmt.Errorf("creating table %s: %s", t.name, err)
+		}
+		stmt, err := s.tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", t.name, strings.Join(params, ", ")))
+		if err != nil {
+			return nil, err
+		}
+		s.stmts[t] = stmt
+	}
+	return s, nil
+}
+
+func (s *sqliteSink) row(t *exportTable, values []any) error {
+	_, err := s.stmts[t].Exec(values...)
+	return err
+}
+
+func (s *sqliteSink) close() error {
+	for _, t := range s.tables {
+		for _, cols := range t.indexes {
+			name := t.name + "_" + strings.Join(cols, "_")
+			q := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, t.name, strings.Join(cols, ", "))
+			if _, err := s.tx.Exec(q); err != nil {
+				return fmt.Errorf("creating index %s: %s", name, err)
+			}
+		}
+	}
+	if err := s.tx.Commit(); err != nil {
+		return err
+	}
+	return s.db.Close()
+}
+
+func (s *sqliteSink) abort() {
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+	if s.db != nil {
+		s.db.Close()
+	}
+	os.Remove(s.path)
+	os.Remove(s.path + "-journal")
+}
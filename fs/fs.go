@@ -0,0 +1,117 @@
+// Package fs defines Provider, the operations on the files of a storage
+// provider that dedup and sync logic is written against, and implements it
+// for the local filesystem.
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"time"
+
+	"github.com/timblaktu/wupdedup/digest"
+)
+
+// Errors of Provider operations, to be checked with errors.Is.
+var (
+	// ErrNotSupported is returned by operations a provider's Capabilities
+	// don't include.
+	ErrNotSupported = errors.New("not supported by this provider")
+	ErrNotExist     = iofs.ErrNotExist
+	ErrExist        = iofs.ErrExist
+	ErrInvalid      = iofs.ErrInvalid
+)
+
+// A Provider gives access to the files of a tree held by a storage provider,
+// eg: a local directory or a cloud photo library.
+//
+// Paths are slash-separated and relative to the root of the tree, as in
+// io/fs: "." is the root, and paths never start with a slash nor hold "." or
+// ".." elements.
+type Provider interface {
+	// Capabilities reports the optional operations the provider supports.
+	Capabilities() Capabilities
+
+	// List calls fn with every entry of directory dir, in lexical order, and
+	// of its subdirectories, after their own entry, if recursive is set.
+	// Entries that can't be listed are reported with a non-nil err, and
+	// skipped if fn returns nil. An error returned by fn stops the listing,
+	// and is returned by List.
+	List(ctx context.Context, dir string, recursive bool, fn func(e *Entry, err error) error) error
+
+	// Stat returns the entry of path.
+	Stat(ctx context.Context, path string) (*Entry, error)
+
+	// Open opens the regular file at path for reading. Providers without
+	// random access emulate Seek, eg: with range requests.
+	Open(ctx context.Context, path string) (io.ReadSeekCloser, error)
+
+	// Hash returns the hex-encoded digest of the contents of the file at path,
+	// computed by the provider if Capabilities lists the algorithm in
+	// ServerHashes, or else by reading the file.
+	Hash(ctx context.Context, path string, algo digest.Algorithm) (string, error)
+
+	// Delete deletes the regular file at path.
+	Delete(ctx context.Context, path string) error
+
+	// Move moves the file at path from to path to, which mustn't exist,
+	// creating its parent directories.
+	Move(ctx context.Context, from, to string) error
+
+	// Upload creates the file at path, which mustn't exist, with the contents
+	// read from r, creating its parent directories, and returns its entry.
+	Upload(ctx context.Context, path string, r io.Reader) (*Entry, error)
+}
+
+// Capabilities lists the optional operations of a Provider. Unsupported ones
+// return an error wrapping ErrNotSupported.
+type Capabilities struct {
+	// ServerHashes lists the algorithms of the digests the provider knows
+	// without the contents of files being read, eg: from its API's metadata.
+	ServerHashes []digest.Algorithm
+	Delete       bool
+	Move         bool
+	Upload       bool
+	// RecursiveList reports whether List can list a whole tree at once,
+	// rather than one directory per call.
+	RecursiveList bool
+}
+
+// HasServerHash reports whether the provider knows the digests of algorithm
+// algo without reading the contents of files.
+func (c Capabilities) HasServerHash(algo digest.Algorithm) bool {
+	for _, a := range c.ServerHashes {
+		if a == algo {
+			return true
+		}
+	}
+	return false
+}
+
+// An Entry describes a file or directory of a Provider.
+type Entry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Mode    iofs.FileMode
+	// Hashes holds the digests the provider knows without reading the file,
+	// keyed by algorithm, as listed by Capabilities.ServerHashes.
+	Hashes map[string]string
+	// Sys holds the provider's own data about the file, if any, eg: the
+	// *syscall.Stat_t of local files, as returned by io/fs FileInfo.Sys.
+	Sys any
+}
+
+// IsDir reports whether the entry is a directory.
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// checkPath returns an error wrapping ErrInvalid if path isn't valid for op.
+func checkPath(op, path string) error {
+	if !iofs.ValidPath(path) {
+		return &iofs.PathError{Op: op, Path: path, Err: ErrInvalid}
+	}
+	return nil
+}
@@ -1,7 +1,280 @@
-// package fs
+package fs
 
-// import "fmt"
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
 
-// type local interface {
+	"github.com/timblaktu/wupdedup/digest"
+)
 
-// }
+// Local is the Provider of a directory tree of the local filesystem.
+type Local struct {
+	root string
+}
+
+// NewLocal returns the Provider of the tree rooted at directory root.
+func NewLocal(root string) *Local {
+	return &Local{filepath.Clean(root)}
+}
+
+// Root returns the directory the provider's tree is rooted at.
+func (l *Local) Root() string {
+	return l.root
+}
+
+// Rel returns the path within the provider's tree of local file name.
+func (l *Local) Rel(name string) (string, error) {
+	rel, err := filepath.Rel(l.root, name)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if err := checkPath("rel", rel); err != nil {
+		return "", &iofs.PathError{Op: "rel", Path: name, Err: ErrInvalid}
+	}
+	return rel, nil
+}
+
+// name returns the local file name of path, which must be valid.
+func (l *Local) name(op, path string) (string, error) {
+	if err := checkPath(op, path); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(path)), nil
+}
+
+func (l *Local) Capabilities() Capabilities {
+	return Capabilities{
+		Delete:        true,
+		Move:          true,
+		Upload:        true,
+		RecursiveList: true,
+	}
+}
+
+func (l *Local) List(ctx context.Context, dir string, recursive bool, fn func(e *Entry, err error) error) error {
+	root, err := l.name("list", dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(name string, d iofs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if name == root {
+			// the directory listed itself isn't an entry, but failing to
+			// read it fails the listing
+			return err
+		}
+		rel, _ := l.Rel(name)
+		var e *Entry
+		if err == nil {
+			var fi iofs.FileInfo
+			if fi, err = d.Info(); err == nil {
+				e = newEntry(rel, fi)
+			}
+		}
+		if err != nil {
+			return fn(&Entry{Path: rel}, err)
+		}
+		if err := fn(e, nil); err != nil {
+			return err
+		}
+		if d.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (l *Local) Stat(ctx context.Context, path string) (*Entry, error) {
+	name, err := l.name("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return newEntry(path, fi), nil
+}
+
+func (l *Local) Open(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+	name, err := l.name("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (l *Local) Hash(ctx context.Context, path string, algo digest.Algorithm) (string, error) {
+	name, err := l.name("hash", path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sums, err := digest.Sum(ContextReader(ctx, f), []digest.Algorithm{algo})
+	if err != nil {
+		return "", err
+	}
+	return sums[string(algo)], nil
+}
+
+func (l *Local) Delete(ctx context.Context, path string) error {
+	name, err := l.name("delete", path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return &iofs.PathError{Op: "delete", Path: path, Err: ErrInvalid}
+	}
+	return os.Remove(name)
+}
+
+func (l *Local) Move(ctx context.Context, from, to string) error {
+	src, err := l.name("move", from)
+	if err != nil {
+		return err
+	}
+	dst, err := l.name("move", to)
+	if err != nil {
+		return err
+	}
+	if err := l.mkdirAll(to); err != nil {
+		return err
+	}
+	// linking fails if dst exists, unlike renaming, which would replace it
+	err = os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	} else if errors.Is(err, iofs.ErrExist) {
+		return err
+	}
+	// Links aren't supported by every filesystem (eg: FAT, exFAT and SMB
+	// shares), nor across devices, which trees may span through mount points.
+	if _, err := os.Lstat(dst); err == nil {
+		return &iofs.PathError{Op: "move", Path: to, Err: ErrExist}
+	} else if !errors.Is(err, iofs.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	return copyRemove(ctx, src, dst)
+}
+
+// copyRemove moves regular file src to dst, which mustn't exist, by copying
+// it, syncing the copy to disk, and only then removing src.
+func copyRemove(ctx context.Context, src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return &iofs.PathError{Op: "move", Path: src, Err: ErrNotSupported}
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	if _, err := io.Copy(out, ContextReader(ctx, in)); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (l *Local) Upload(ctx context.Context, path string, r io.Reader) (_ *Entry, err error) {
+	name, err := l.name("upload", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.mkdirAll(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+	if _, err := io.Copy(f, ContextReader(ctx, r)); err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return newEntry(path, fi), nil
+}
+
+// mkdirAll creates the parent directories of path.
+func (l *Local) mkdirAll(p string) error {
+	return os.MkdirAll(filepath.Join(l.root, filepath.FromSlash(path.Dir(p))), 0755)
+}
+
+func newEntry(path string, fi iofs.FileInfo) *Entry {
+	return &Entry{
+		Path:    path,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Mode:    fi.Mode(),
+		Sys:     fi.Sys(),
+	}
+}
+
+// ContextReader returns a reader of r that fails with the error of ctx once
+// it's done, so that long reads, eg: hashing a file, can be canceled.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return contextReader{ctx, r}
+}
+
+// A contextReader stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,634 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/timblaktu/wupdedup/digest"
+)
+
+func TestOAuthSignature(t *testing.T) {
+	// The example of appendix A.5 of the OAuth Core 1.0 specification.
+	s := NewSmugMug(SmugMugCredentials{
+		APIKey:     "dpf43f3p2l4k3l03",
+		APISecret:  "kd94hf93k423kf44",
+		UserToken:  "nnch734d00sl2jdk",
+		UserSecret: "pfkkdhi9sl3r4s00",
+	})
+	oauth := func() map[string]string {
+		return map[string]string{
+			"oauth_consumer_key":     "dpf43f3p2l4k3l03",
+			"oauth_nonce":            "kllo9940pd9333jh",
+			"oauth_signature_method": "HMAC-SHA1",
+			"oauth_timestamp":        "1191242096",
+			"oauth_token":            "nnch734d00sl2jdk",
+			"oauth_version":          "1.0",
+		}
+	}
+	wantBase := "GET&http%3A%2F%2Fphotos.example.net%2Fphotos&file%3Dvacation.jpg%26" +
+		"oauth_consumer_key%3Ddpf43f3p2l4k3l03%26oauth_nonce%3Dkllo9940pd9333jh%26" +
+		"oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D1191242096%26" +
+		"oauth_token%3Dnnch734d00sl2jdk%26oauth_version%3D1.0%26size%3Doriginal"
+	for _, raw := range []string{
+		"http://photos.example.net/photos?file=vacation.jpg&size=original",
+		"HTTP://Photos.Example.NET:80/photos?size=original&file=vacation.jpg",
+	} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base := oauthBaseString("GET", u, oauth()); base != wantBase {
+			t.Errorf("base string of %s:\n%s\nwant\n%s", raw, base, wantBase)
+		}
+		auth := s.authorization("GET", u, oauth())
+		if want := `oauth_signature="tR3%2BTy81lMeYAr%2FFid0kMTYa%2FWM%3D"`; !strings.Contains(auth, want) {
+			t.Errorf("Authorization of %s = %s, want it to hold %s", raw, auth, want)
+		}
+	}
+
+	for s, want := range map[string]string{
+		"abcABC123-._~": "abcABC123-._~",
+		"a b+c":         "a%20b%2Bc",
+		"=&%/!*'()":     "%3D%26%25%2F%21%2A%27%28%29",
+		"é":             "%C3%A9",
+	} {
+		if got := oauthEscape(s); got != want {
+			t.Errorf("oauthEscape(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+var testCreds = SmugMugCredentials{APIKey: "key", APISecret: "secret&", UserToken: "token", UserSecret: "user secret"}
+
+type fakeImage struct {
+	key, name string
+	data      []byte
+	// md5 is reported by the API, if set
+	md5 string
+}
+
+type fakeAlbum struct {
+	key, path string
+	images    []*fakeImage
+}
+
+// fakeSmugMug serves the parts of the SmugMug API the provider uses, paging
+// collections by pageSize items, and checking the signature of every request.
+type fakeSmugMug struct {
+	t        *testing.T
+	srv      *httptest.Server
+	pageSize int
+
+	mu       sync.Mutex
+	albums   []*fakeAlbum
+	requests []string
+	// fail maps request paths to the status they fail with
+	fail map[string]int
+	// uploadMessage, if set, fails uploads with a "fail" stat
+	uploadMessage string
+	uploads       []http.Header
+}
+
+func newFakeSmugMug(t *testing.T, albums ...*fakeAlbum) (*fakeSmugMug, *SmugMug) {
+	f := &fakeSmugMug{t: t, pageSize: 2, albums: albums, fail: make(map[string]int)}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	s := NewSmugMug(testCreds)
+	s.client = f.srv.Client()
+	s.api = f.srv.URL
+	s.upload = f.srv.URL + "/upload/"
+	return f, s
+}
+
+// failWith fails the requests of path with status, or stops failing them if
+// status is 0.
+func (f *fakeSmugMug) failWith(path string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail[path] = status
+}
+
+// uploaded returns the headers of the uploads received.
+func (f *fakeSmugMug) uploaded() []http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.uploads
+}
+
+// requested returns the requests served since the last call.
+func (f *fakeSmugMug) requested() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.requests
+	f.requests = nil
+	return r
+}
+
+func (f *fakeSmugMug) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	if err := checkSignature(r); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, `{"Message":"bad signature"}`, http.StatusUnauthorized)
+		return
+	}
+	if status := f.fail[r.URL.Path]; status != 0 {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"Message":"try again later"}`)
+		return
+	}
+
+	p := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && p == "/api/v2!authuser":
+		respond(w, map[string]any{"User": map[string]any{"Uris": map[string]any{
+			"UserAlbums": map[string]any{"Uri": "/api/v2/user/bob!albums"}}}})
+	case r.Method == http.MethodGet && p == "/api/v2/user/bob!albums":
+		var albums []any
+		for _, a := range f.albums {
+			albums = append(albums, map[string]any{
+				"AlbumKey": a.key,
+				"UrlPath":  "/" + a.path,
+				"Uri":      "/api/v2/album/" + a.key,
+				"Uris":     map[string]any{"AlbumImages": map[string]any{"Uri": "/api/v2/album/" + a.key + "!images"}},
+			})
+		}
+		f.page(w, r, "Album", albums)
+	case r.Method == http.MethodGet && strings.HasSuffix(p, "!images"):
+		a := f.album(strings.TrimSuffix(strings.TrimPrefix(p, "/api/v2/album/"), "!images"))
+		if a == nil {
+			http.NotFound(w, r)
+			return
+		}
+		var images []any
+		for _, img := range a.images {
+			images = append(images, map[string]any{
+				"ImageKey":     img.key,
+				"FileName":     img.name,
+				"ArchivedUri":  f.srv.URL + "/photos/" + img.key,
+				"ArchivedSize": len(img.data),
+				"ArchivedMD5":  img.md5,
+				"LastUpdated":  "2023-04-05T06:07:08+00:00",
+				"Uri":          "/api/v2/album/" + a.key + "/image/" + img.key + "-0",
+			})
+		}
+		f.page(w, r, "AlbumImage", images)
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "/photos/"):
+		for _, a := range f.albums {
+			for _, img := range a.images {
+				if img.key == strings.TrimPrefix(p, "/photos/") {
+					http.ServeContent(w, r, img.name, time.Time{}, bytes.NewReader(img.data))
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(p, "/api/v2/album/"):
+		for _, a := range f.albums {
+			for i, img := range a.images {
+				if p == "/api/v2/album/"+a.key+"/image/"+img.key+"-0" {
+					a.images = append(a.images[:i], a.images[i+1:]...)
+					respond(w, map[string]any{})
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	case r.Method == http.MethodPost && p == "/upload/":
+		f.uploads = append(f.uploads, r.Header.Clone())
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			f.t.Errorf("upload of %d bytes announced as %d: %v", len(data), r.ContentLength, err)
+		}
+		sum := md5.Sum(data)
+		if got := r.Header.Get("Content-Md5"); got != hex.EncodeToString(sum[:]) {
+			f.t.Errorf("upload announced with MD5 %s, want %x", got, sum)
+		}
+		if f.uploadMessage != "" {
+			json.NewEncoder(w).Encode(map[string]string{"stat": "fail", "message": f.uploadMessage})
+			return
+		}
+		a := f.album(strings.TrimPrefix(r.Header.Get("X-Smug-AlbumUri"), "/api/v2/album/"))
+		if a == nil {
+			json.NewEncoder(w).Encode(map[string]string{"stat": "fail", "message": "no album"})
+			return
+		}
+		a.images = append(a.images, &fakeImage{
+			key:  fmt.Sprintf("U%d", len(f.uploads)),
+			name: r.Header.Get("X-Smug-FileName"),
+			data: data,
+			md5:  hex.EncodeToString(sum[:]),
+		})
+		json.NewEncoder(w).Encode(map[string]string{"stat": "ok"})
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSmugMug) album(key string) *fakeAlbum {
+	for _, a := range f.albums {
+		if a.key == key {
+			return a
+		}
+	}
+	return nil
+}
+
+// page responds with the page of items of the collection named name that the
+// start and count parameters of r select, in pages of pageSize items at most.
+func (f *fakeSmugMug) page(w http.ResponseWriter, r *http.Request, name string, items []any) {
+	start, count := 1, f.pageSize
+	if s := r.URL.Query().Get("start"); s != "" {
+		start, _ = strconv.Atoi(s)
+	}
+	if c, _ := strconv.Atoi(r.URL.Query().Get("count")); c > 0 && c < count {
+		count = c
+	}
+	end := start - 1 + count
+	if end > len(items) {
+		end = len(items)
+	}
+	pages := map[string]any{"Total": len(items), "Start": start}
+	if end < len(items) {
+		pages["NextPage"] = fmt.Sprintf("%s?start=%d&count=%d", r.URL.Path, end+1, count)
+	}
+	respond(w, map[string]any{name: items[start-1 : end], "Pages": pages})
+}
+
+func respond(w http.ResponseWriter, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"Response": response, "Code": 200})
+}
+
+// checkSignature checks the OAuth signature of r against testCreds.
+func checkSignature(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "OAuth ") {
+		return errors.New("unsigned request")
+	}
+	auth = strings.TrimPrefix(auth, "OAuth ")
+	oauth := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		k, v, _ := strings.Cut(field, "=")
+		v, err := url.PathUnescape(strings.Trim(v, `"`))
+		if err != nil {
+			return err
+		}
+		oauth[k] = v
+	}
+	sig := oauth["oauth_signature"]
+	delete(oauth, "oauth_signature")
+	if oauth["oauth_consumer_key"] != testCreds.APIKey || oauth["oauth_token"] != testCreds.UserToken {
+		return fmt.Errorf("signed for %s/%s", oauth["oauth_consumer_key"], oauth["oauth_token"])
+	}
+	u := *r.URL
+	u.Scheme, u.Host = "http", r.Host
+	want := (&SmugMug{creds: testCreds}).authorization(r.Method, &u, oauth)
+	if !strings.Contains(want, fmt.Sprintf(`oauth_signature="%s"`, oauthEscape(sig))) {
+		return fmt.Errorf("signature %s doesn't match %s", sig, want)
+	}
+	return nil
+}
+
+func testAlbums() []*fakeAlbum {
+	return []*fakeAlbum{
+		{key: "A1", path: "Family/2020/Beach", images: []*fakeImage{
+			{key: "I1", name: "b.jpg", data: []byte("beach b"), md5: "00112233445566778899aabbccddeeff"},
+			{key: "I2", name: "a.jpg", data: []byte("beach a")},
+			{key: "I3", name: "a.jpg", data: []byte("beach a, again")},
+		}},
+		{key: "A2", path: "Family/Xmas", images: []*fakeImage{
+			{key: "I4", name: "tree.mov", data: []byte("tree")},
+		}},
+		{key: "A3", path: "Travel"},
+	}
+}
+
+// listed formats the entries List passes fn.
+func listed(t *testing.T, s *SmugMug, dir string, recursive bool) []string {
+	t.Helper()
+	var got []string
+	err := s.List(context.Background(), dir, recursive, func(e *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			got = append(got, e.Path+"/")
+		} else {
+			got = append(got, fmt.Sprintf("%s %d %s", e.Path, e.Size, e.Hashes[string(digest.MD5)]))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestSmugMugList(t *testing.T) {
+	f, s := newFakeSmugMug(t, testAlbums()...)
+	got := listed(t, s, ".", true)
+	want := []string{
+		"Family/",
+		"Family/2020/",
+		"Family/2020/Beach/",
+		"Family/2020/Beach/I3-a.jpg 14 ",
+		"Family/2020/Beach/a.jpg 7 ",
+		"Family/2020/Beach/b.jpg 7 00112233445566778899aabbccddeeff",
+		"Family/Xmas/",
+		"Family/Xmas/tree.mov 4 ",
+		"Travel/",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List(., recursive) =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// Albums are listed once, in pages, and then images in pages of their own.
+	wantRequests := []string{
+		"GET /api/v2!authuser",
+		"GET /api/v2/user/bob!albums?count=100",
+		"GET /api/v2/user/bob!albums?start=3&count=2",
+		"GET /api/v2/album/A1!images?count=100",
+		"GET /api/v2/album/A1!images?start=3&count=2",
+		"GET /api/v2/album/A2!images?count=100",
+		"GET /api/v2/album/A3!images?count=100",
+	}
+	if got := f.requested(); !reflect.DeepEqual(got, wantRequests) {
+		t.Errorf("List requested\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(wantRequests, "\n"))
+	}
+
+	if got, want := listed(t, s, "Family", false), []string{"Family/2020/", "Family/Xmas/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(Family) = %q, want %q", got, want)
+	}
+	if got, want := listed(t, s, "Family/Xmas", false), []string{"Family/Xmas/tree.mov 4 "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(Family/Xmas) = %q, want %q", got, want)
+	}
+	if got := f.requested(); len(got) != 1 || !strings.Contains(got[0], "A2!images") {
+		t.Errorf("listing again requested %q, want the album's images alone", got)
+	}
+
+	for _, dir := range []string{"Missing", "Family/Xmas/tree.mov"} {
+		if err := s.List(context.Background(), dir, false, nil); !errors.Is(err, ErrNotExist) {
+			t.Errorf("List(%s): %v, want %v", dir, err, ErrNotExist)
+		}
+	}
+	if err := s.List(context.Background(), "/Family", false, nil); !errors.Is(err, ErrInvalid) {
+		t.Errorf("List(/Family): %v, want %v", err, ErrInvalid)
+	}
+
+	// Albums whose images can't be listed are reported, and skipped.
+	f.failWith("/api/v2/album/A1!images", http.StatusInternalServerError)
+	var failed []string
+	err := s.List(context.Background(), ".", true, func(e *Entry, err error) error {
+		if err != nil {
+			failed = append(failed, e.Path+": "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || !strings.HasPrefix(failed[0], "Family/2020/Beach: ") || !strings.Contains(failed[0], "try again later") {
+		t.Errorf("List reported %q, want the failure of Family/2020/Beach", failed)
+	}
+}
+
+func TestSmugMugListErrors(t *testing.T) {
+	f, s := newFakeSmugMug(t, testAlbums()...)
+	f.failWith("/api/v2/user/bob!albums", http.StatusUnauthorized)
+	err := s.List(context.Background(), ".", true, func(*Entry, error) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("List with albums failing: %v, want a 401 error", err)
+	}
+	// Failures aren't cached.
+	f.failWith("/api/v2/user/bob!albums", 0)
+	if got := listed(t, s, "Travel", false); len(got) != 0 {
+		t.Errorf("List(Travel) = %q, want nothing", got)
+	}
+}
+
+func TestSmugMugStatHash(t *testing.T) {
+	f, s := newFakeSmugMug(t, testAlbums()...)
+	ctx := context.Background()
+	e, err := s.Stat(ctx, "Family/2020/Beach/b.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Entry{
+		Path:    "Family/2020/Beach/b.jpg",
+		Size:    7,
+		ModTime: time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC),
+		Mode:    0644,
+		Hashes:  map[string]string{"md5": "00112233445566778899aabbccddeeff"},
+	}
+	if !e.ModTime.Equal(want.ModTime) {
+		t.Errorf("Stat ModTime = %v, want %v", e.ModTime, want.ModTime)
+	}
+	e.ModTime = want.ModTime
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Stat = %+v, want %+v", e, want)
+	}
+	if e, err := s.Stat(ctx, "Family/2020/Beach/a.jpg"); err != nil || e.Hashes != nil {
+		t.Errorf("Stat of an image without MD5 = %+v, %v, want no hashes", e, err)
+	}
+	for _, dir := range []string{".", "Family", "Travel"} {
+		if e, err := s.Stat(ctx, dir); err != nil || !e.IsDir() || e.Path != dir {
+			t.Errorf("Stat(%s) = %+v, %v, want a directory", dir, e, err)
+		}
+	}
+	for _, p := range []string{"Missing", "Travel/x.jpg", "Family/a.jpg"} {
+		if _, err := s.Stat(ctx, p); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat(%s): %v, want %v", p, err, ErrNotExist)
+		}
+	}
+	f.requested()
+
+	// MD5s reported by the API are used as they are, without downloading.
+	if sum, err := s.Hash(ctx, "Family/2020/Beach/b.jpg", digest.MD5); err != nil || sum != "00112233445566778899aabbccddeeff" {
+		t.Errorf("Hash(b.jpg, md5) = %s, %v", sum, err)
+	}
+	for _, r := range f.requested() {
+		if strings.Contains(r, "/photos/") {
+			t.Errorf("Hash of a reported MD5 downloaded the image: %s", r)
+		}
+	}
+	// Other digests are computed from the image's contents.
+	for _, c := range []struct {
+		path string
+		algo digest.Algorithm
+		data []byte
+	}{
+		{"Family/2020/Beach/a.jpg", digest.MD5, []byte("beach a")},
+		{"Family/2020/Beach/I3-a.jpg", digest.MD5, []byte("beach a, again")},
+		{"Family/2020/Beach/b.jpg", digest.SHA256, []byte("beach b")},
+	} {
+		var want string
+		if c.algo == digest.MD5 {
+			sum := md5.Sum(c.data)
+			want = hex.EncodeToString(sum[:])
+		} else {
+			sum := sha256.Sum256(c.data)
+			want = hex.EncodeToString(sum[:])
+		}
+		if sum, err := s.Hash(ctx, c.path, c.algo); err != nil || sum != want {
+			t.Errorf("Hash(%s, %s) = %s, %v, want %s", c.path, c.algo, sum, err, want)
+		}
+	}
+	if _, err := s.Hash(ctx, "Family/2020/Beach/c.jpg", digest.MD5); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Hash of a missing image: %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestSmugMugOpen(t *testing.T) {
+	f, s := newFakeSmugMug(t, testAlbums()...)
+	r, err := s.Open(context.Background(), "Family/2020/Beach/I3-a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	f.requested()
+	if data, err := io.ReadAll(r); err != nil || string(data) != "again" {
+		t.Errorf("read %q, %v after seeking 5 bytes from the end, want again", data, err)
+	}
+	if got := f.requested(); len(got) != 1 || !strings.HasPrefix(got[0], "GET /photos/I3") {
+		t.Errorf("read after seeking requested %q", got)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "beach a, again" {
+		t.Errorf("read %q, %v after seeking to the start", data, err)
+	}
+}
+
+func TestSmugMugDelete(t *testing.T) {
+	f, s := newFakeSmugMug(t, testAlbums()...)
+	ctx := context.Background()
+	if err := s.Delete(ctx, "Family/Xmas/tree.mov"); err != nil {
+		t.Fatal(err)
+	}
+	got := f.requested()
+	if len(got) == 0 || got[len(got)-1] != "DELETE /api/v2/album/A2/image/I4-0" {
+		t.Errorf("Delete requested %q, want it to end with the DELETE of the album's image", got)
+	}
+	if _, err := s.Stat(ctx, "Family/Xmas/tree.mov"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after Delete: %v, want %v", err, ErrNotExist)
+	}
+
+	// Nothing is deleted when the image isn't found.
+	for _, p := range []string{"Family/2020/Beach/missing.jpg", "Family/2020/Beach", "Travel", "/Travel/x.jpg"} {
+		f.requested()
+		if err := s.Delete(ctx, p); !errors.Is(err, ErrNotExist) && !errors.Is(err, ErrInvalid) {
+			t.Errorf("Delete(%s): %v, want %v", p, err, ErrNotExist)
+		}
+		for _, r := range f.requested() {
+			if strings.HasPrefix(r, "DELETE") {
+				t.Errorf("Delete(%s) requested %s", p, r)
+			}
+		}
+	}
+
+	f.failWith("/api/v2/album/A1/image/I1-0", http.StatusInternalServerError)
+	err := s.Delete(ctx, "Family/2020/Beach/b.jpg")
+	if err == nil || !strings.Contains(err.Error(), "500") || !strings.Contains(err.Error(), "try again later") {
+		t.Errorf("Delete failing on the server: %v, want its status and message", err)
+	}
+	if _, err := s.Stat(ctx, "Family/2020/Beach/b.jpg"); err != nil {
+		t.Errorf("Stat after a failed Delete: %v", err)
+	}
+	// Images gone since they were listed aren't found.
+	f.failWith("/api/v2/album/A1/image/I1-0", http.StatusNotFound)
+	if err := s.Delete(ctx, "Family/2020/Beach/b.jpg"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Delete of an image the server doesn't find: %v, want %v", err, ErrNotExist)
+	}
+}
+
+func TestSmugMugUpload(t *testing.T) {
+	f, s := newFakeSmugMug(t, testAlbums()...)
+	ctx := context.Background()
+	data := []byte("new photo")
+	e, err := s.Upload(ctx, "Travel/new.jpg", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(data)
+	if e.Path != "Travel/new.jpg" || e.Size != int64(len(data)) || e.Hashes["md5"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("Upload = %+v", e)
+	}
+	uploads := f.uploaded()
+	if len(uploads) != 1 {
+		t.Fatalf("Upload sent %d uploads, want 1", len(uploads))
+	}
+	h := uploads[0]
+	for k, want := range map[string]string{
+		"X-Smug-AlbumUri":     "/api/v2/album/A3",
+		"X-Smug-FileName":     "new.jpg",
+		"X-Smug-ResponseType": "JSON",
+		"X-Smug-Version":      "v2",
+	} {
+		if got := h.Get(k); got != want {
+			t.Errorf("upload header %s = %q, want %q", k, got, want)
+		}
+	}
+	if got, err := s.Stat(ctx, "Travel/new.jpg"); err != nil || got.Size != int64(len(data)) {
+		t.Errorf("Stat after Upload = %+v, %v", got, err)
+	}
+
+	for _, c := range []struct {
+		path string
+		want error
+	}{
+		{"Travel/new.jpg", ErrExist},
+		{"Family/2020/Beach/a.jpg", ErrExist},
+		{"Nowhere/new.jpg", ErrNotExist},
+		{"Family/new.jpg", ErrNotExist},
+		{"Travel/../new.jpg", ErrInvalid},
+	} {
+		if _, err := s.Upload(ctx, c.path, strings.NewReader("x")); !errors.Is(err, c.want) {
+			t.Errorf("Upload(%s): %v, want %v", c.path, err, c.want)
+		}
+	}
+	if n := len(f.uploaded()); n != 1 {
+		t.Errorf("refused uploads were sent: %d uploads, want 1", n)
+	}
+
+	f.mu.Lock()
+	f.uploadMessage = "file type not allowed"
+	f.mu.Unlock()
+	if _, err := s.Upload(ctx, "Travel/notes.txt", strings.NewReader("x")); err == nil || !strings.Contains(err.Error(), "file type not allowed") {
+		t.Errorf("Upload refused by SmugMug: %v, want its message", err)
+	}
+	f.failWith("/upload/", http.StatusServiceUnavailable)
+	if _, err := s.Upload(ctx, "Travel/later.jpg", strings.NewReader("x")); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Upload failing on the server: %v, want its status", err)
+	}
+	if _, err := s.Stat(ctx, "Travel/notes.txt"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after failed uploads: %v, want %v", err, ErrNotExist)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.Upload(ctx, "Travel/canceled.jpg", strings.NewReader("x")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Upload with a canceled context: %v, want %v", err, context.Canceled)
+	}
+}
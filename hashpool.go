@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/timblaktu/wupdedup/content"
+	"github.com/timblaktu/wupdedup/digest"
+	"github.com/timblaktu/wupdedup/exif"
+	wfs "github.com/timblaktu/wupdedup/fs"
+	"github.com/timblaktu/wupdedup/imagehash"
+	"github.com/timblaktu/wupdedup/quicktime"
+	"golang.org/x/exp/slog"
+)
+
+// -----------------------------------------------------------------------------
+// hashPool detects the type of the files found by a tree walk, digests them
+// and extracts their metadata on a bounded set of worker goroutines,
+// persisting each record once all of its content has been read.
+type hashPool struct {
+	ctx         context.Context
+	c           *StorageStrategyContext
+	ps          ProviderStrategy
+	algos       []digest.Algorithm
+	imageHashes bool
+	metadata    bool
+	jobs        chan *FileRecord
+	wg          sync.WaitGroup
+}
+
+// newHashPool starts `workers` hashing goroutines (one per CPU if zero),
+// reading files through the provider of ps until ctx is done. Callers must
+// Submit every record and then Wait for the pool to drain.
+func newHashPool(ctx context.Context, c *StorageStrategyContext, ps ProviderStrategy, workers int,
+	algos []digest.Algorithm, imageHashes, metadata bool) *hashPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &hashPool{
+		ctx:         ctx,
+		c:           c,
+		ps:          ps,
+		algos:       algos,
+		imageHashes: imageHashes,
+		metadata:    metadata,
+		jobs:        make(chan *FileRecord, workers*2),
+	}
+	slog.Debug("starting hash workers", "workers", workers, "algos", algos,
+		"imageHashes", imageHashes, "metadata", metadata)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// sniffLen is how much of a file's start is read to detect its type, which
+// is all http.DetectContentType considers.
+const sniffLen = 512
+
+func (p *hashPool) work() {
+	defer p.wg.Done()
+	buf := make([]byte, sniffLen)
+	for r := range p.jobs {
+		if err := p.process(r, buf); err != nil {
+			if p.ctx.Err() != nil {
+				// The scan was canceled, not the file unreadable, so
+				// its previous record is kept.
+				continue
+			}
+			slog.Warn("cannot read file", "path", r.Path, "err", err)
+			r.Error = err.Error()
+			p.c.errorCount.Add(1)
+		}
+		if err := p.c.putRecord(r); err != nil {
+			slog.Error("cannot store file record", err, "path", r.Path)
+			p.c.errorCount.Add(1)
+		}
+	}
+}
+
+// process reads everything r still needs through a single handle on the file:
+// the header sniffed for its type is replayed into the digests ahead of the
+// rest of the file, and metadata is read at random offsets of the same handle.
+func (p *hashPool) process(r *FileRecord, buf []byte) error {
+	path, err := p.ps.ProviderPath(r.Path)
+	if err != nil {
+		return err
+	}
+	f, err := p.ps.Provider().Open(p.ctx, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ra := readerAt(f)
+	// Short and empty files are fine; they just yield a shorter header.
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	hdr := buf[:n]
+	if r.Category == content.Unknown {
+		r.MimeType, r.Category = content.Classify(hdr, r.Path)
+	}
+	if !r.HasHashes(p.algos) {
+		sums, err := digest.Sum(wfs.ContextReader(p.ctx, io.MultiReader(bytes.NewReader(hdr), f)), p.algos)
+		if err != nil {
+			return err
+		}
+		r.Hashes = sums
+	}
+	if p.needsImageHashes(r) {
+		h, err := imagehash.Decode(io.NewSectionReader(ra, 0, r.Size))
+		if err != nil {
+			slog.Warn("cannot hash image", "path", r.Path, "err", err)
+			r.ImageError = err.Error()
+		} else {
+			r.ImageHashes = &h
+		}
+	}
+	if p.needsExif(r) {
+		m, err := exif.Decode(ra)
+		if err != nil {
+			if err != exif.ErrNoExif {
+				slog.Warn("cannot read EXIF", "path", r.Path, "err", err)
+			}
+			m = &exif.Metadata{}
+		}
+		r.Exif = m
+	}
+	if p.needsVideo(r) {
+		m, err := quicktime.Decode(ra, r.Size)
+		if err != nil {
+			slog.Warn("cannot read movie metadata", "path", r.Path, "err", err)
+			m = &quicktime.Metadata{}
+		}
+		r.Video = m
+	}
+	return nil
+}
+
+// readerAt returns f as an io.ReaderAt, emulated by seeking and reading for
+// providers whose files don't implement it, which isn't safe for concurrent
+// use, nor needed to be, as a file is read by a single worker.
+func readerAt(f io.ReadSeeker) io.ReaderAt {
+	if ra, ok := f.(io.ReaderAt); ok {
+		return ra
+	}
+	return seekReaderAt{f}
+}
+
+type seekReaderAt struct {
+	f io.ReadSeeker
+}
+
+func (r seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Needs reports whether the pool has any work to do for r.
+func (p *hashPool) Needs(r *FileRecord) bool {
+	return r.Mode.IsRegular() && (r.Category == content.Unknown ||
+		!r.HasHashes(p.algos) || p.needsImageHashes(r) || p.needsExif(r) ||
+		p.needsVideo(r))
+}
+
+func (p *hashPool) needsImageHashes(r *FileRecord) bool {
+	return p.imageHashes && r.ImageHashes == nil && r.ImageError == "" &&
+		imagehash.Decodable(r.MimeType)
+}
+
+func (p *hashPool) needsExif(r *FileRecord) bool {
+	return p.metadata && r.Exif == nil &&
+		(r.Category == content.Image || r.Category == content.RawImage)
+}
+
+func (p *hashPool) needsVideo(r *FileRecord) bool {
+	return p.metadata && r.Video == nil && quicktime.Decodable(r.MimeType)
+}
+
+// Submit queues a record for reading, blocking while all workers are busy.
+func (p *hashPool) Submit(r *FileRecord) {
+	p.jobs <- r
+}
+
+// Wait stops accepting records and blocks until every queued one is stored.
+func (p *hashPool) Wait() {
+	close(p.jobs)
+	p.wg.Wait()
+}
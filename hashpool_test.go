@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/digest"
+	wfs "github.com/timblaktu/wupdedup/fs"
+)
+
+// brokenProvider fails to open the file at path unreadable, and lists an
+// unlistable entry ahead of the others.
+type brokenProvider struct {
+	wfs.Provider
+	unreadable string
+}
+
+func (p brokenProvider) Open(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+	if path == p.unreadable {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EACCES}
+	}
+	return p.Provider.Open(ctx, path)
+}
+
+func (p brokenProvider) List(ctx context.Context, dir string, recursive bool, fn func(e *wfs.Entry, err error) error) error {
+	if dir == "." {
+		err := &fs.PathError{Op: "readdir", Path: "locked", Err: syscall.EACCES}
+		if err := fn(&wfs.Entry{Path: "locked", Mode: fs.ModeDir}, err); err != nil {
+			return err
+		}
+	}
+	return p.Provider.List(ctx, dir, recursive, fn)
+}
+
+// brokenStrategy scans a local tree through a brokenProvider.
+type brokenStrategy struct {
+	LocalStrategy
+	unreadable string
+}
+
+func (s brokenStrategy) Provider() wfs.Provider {
+	return brokenProvider{s.LocalStrategy.Provider(), s.unreadable}
+}
+
+func (s brokenStrategy) ScanTree(ctx context.Context, c *StorageStrategyContext) error {
+	pool := newHashPool(ctx, c, s, 2, []digest.Algorithm{digest.SHA256}, false, false)
+	err := c.scanProvider(ctx, s, pool)
+	pool.Wait()
+	return err
+}
+
+func TestScanCountsErrors(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := brokenStrategy{LocalStrategy{config.LocalConfig{RootPath: root}}, "b.txt"}
+	d := db.OpenMemory()
+	defer d.Close()
+	b, err := openRecordBucket(d, "local", s.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewStorageStrategyContext(s, "local")
+	c.SetBucket(b)
+	c.SetSession("s1")
+	c.SetBatchOptions(db.BatchOptions{Size: 2})
+
+	stats := c.scanTree(context.Background())
+	if stats.Files != 3 || stats.Errors != 2 {
+		t.Errorf("scan counted %d files and %d errors, want 3 files and 2 errors", stats.Files, stats.Errors)
+	}
+	for name, wantErr := range map[string]bool{"a.txt": false, "b.txt": true, "c.txt": false, "locked": true} {
+		r, ok, err := c.records.Get(filepath.Join(root, name))
+		if err != nil || !ok {
+			t.Errorf("record of %s: %v, %v", name, ok, err)
+			continue
+		}
+		if (r.Error != "") != wantErr {
+			t.Errorf("record of %s has error %q", name, r.Error)
+		}
+		if hashed := r.Hashes[string(digest.SHA256)] != ""; hashed == wantErr {
+			t.Errorf("record of %s has hashes %v", name, r.Hashes)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package imagehash
+
+// A Tree is a BK-tree indexing hashes by Hamming distance, so that finding all
+// hashes within a small distance of a query visits only a fraction of them.
+type Tree struct {
+	root *node
+	size int
+}
+
+type node struct {
+	hash     uint64
+	ids      []int
+	children map[int]*node
+}
+
+// Len returns the number of ids added to the tree.
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// Add indexes hash under the caller-defined id.
+func (t *Tree) Add(hash uint64, id int) {
+	t.size++
+	if t.root == nil {
+		t.root = &node{hash: hash, ids: []int{id}}
+		return
+	}
+	n := t.root
+	for {
+		d := Distance(n.hash, hash)
+		if d == 0 {
+			n.ids = append(n.ids, id)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*node)
+			}
+			n.children[d] = &node{hash: hash, ids: []int{id}}
+			return
+		}
+		n = child
+	}
+}
+
+// Search calls found with the id and distance of every indexed hash within
+// maxDistance of hash.
+func (t *Tree) Search(hash uint64, maxDistance int, found func(id, distance int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*node{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := Distance(n.hash, hash)
+		if d <= maxDistance {
+			for _, id := range n.ids {
+				found(id, d)
+			}
+		}
+		// By the triangle inequality only children whose edge distance is
+		// within maxDistance of d can hold matches.
+		for cd, child := range n.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package imagehash computes perceptual hashes of images, which stay close in
+// Hamming distance when an image is resized, recompressed or re-exported.
+//
+// Decoding is pure Go and supports JPEG, PNG, GIF and WebP.
+package imagehash
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"sync"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Kind names one of the perceptual hash algorithms.
+type Kind string
+
+const (
+	// AHash (average hash) sets a bit for each pixel of an 8x8 thumbnail
+	// brighter than the thumbnail's mean.
+	AHash Kind = "ahash"
+	// DHash (difference hash) sets a bit for each pixel of a 9x8 thumbnail
+	// brighter than its right-hand neighbour.
+	DHash Kind = "dhash"
+	// PHash sets a bit for each of the 64 lowest-frequency DCT coefficients
+	// of a 32x32 thumbnail above their median.
+	PHash Kind = "phash"
+)
+
+// Hashes holds every perceptual hash of one image.
+type Hashes struct {
+	AHash uint64 `json:"ahash"`
+	DHash uint64 `json:"dhash"`
+	PHash uint64 `json:"phash"`
+}
+
+// Get returns the hash of the given kind.
+func (h Hashes) Get(k Kind) (uint64, error) {
+	switch k {
+	case AHash:
+		return h.AHash, nil
+	case DHash:
+		return h.DHash, nil
+	case PHash:
+		return h.PHash, nil
+	default:
+		return 0, fmt.Errorf("unknown perceptual hash %q", k)
+	}
+}
+
+// Decodable reports whether images of the given MIME type can be decoded
+// and hashed.
+func Decodable(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// Distance returns the Hamming distance between two hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Compute returns all perceptual hashes of img.
+func Compute(img image.Image) Hashes {
+	return Hashes{
+		AHash: averageHash(img),
+		DHash: differenceHash(img),
+		PHash: dctHash(img),
+	}
+}
+
+// MaxPixels is the largest number of pixels of the images Decode decodes, as
+// decoding allocates memory in proportion, whatever the size of the file.
+// It's also the most pixels decoded at once by concurrent Decodes, which
+// thus hold about MaxPixels × 4 bytes (400 MiB) of images at most, however
+// many goroutines call it.
+const MaxPixels = 100 << 20
+
+// decoding is the budget of pixels of the images being decoded.
+var decoding = newPixelBudget(MaxPixels)
+
+// A pixelBudget is a semaphore weighted by the pixels of images.
+type pixelBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	free int64
+}
+
+func newPixelBudget(n int64) *pixelBudget {
+	b := &pixelBudget{free: n}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits for n pixels to be free, and takes them.
+func (b *pixelBudget) acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.free < n {
+		b.cond.Wait()
+	}
+	b.free -= n
+}
+
+// release frees n pixels taken by acquire.
+func (b *pixelBudget) release(n int64) {
+	b.mu.Lock()
+	b.free += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// ErrTooLarge is returned by Decode for images of more than MaxPixels pixels.
+var ErrTooLarge = errors.New("imagehash: image too large")
+
+// Decode decodes an image from r and returns its hashes. Its dimensions are
+// read first, so that images larger than MaxPixels aren't decoded, and that
+// decoding waits while other goroutines decode too many pixels.
+func Decode(r io.ReadSeeker) (Hashes, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return Hashes{}, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return Hashes{}, fmt.Errorf("imagehash: image is %dx%d", cfg.Width, cfg.Height)
+	}
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if pixels > MaxPixels {
+		return Hashes{}, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Hashes{}, err
+	}
+	decoding.acquire(pixels)
+	defer decoding.release(pixels)
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return Hashes{}, err
+	}
+	return Compute(img), nil
+}
+
+// File decodes the image at path and returns its hashes.
+func File(path string) (Hashes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hashes{}, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+func averageHash(img image.Image) uint64 {
+	px := thumbnail(img, 8, 8)
+	var sum float64
+	for _, p := range px {
+		sum += p
+	}
+	mean := sum / float64(len(px))
+	var h uint64
+	for i, p := range px {
+		if p > mean {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+func differenceHash(img image.Image) uint64 {
+	px := thumbnail(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if px[y*9+x] > px[y*9+x+1] {
+				h |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
+
+func dctHash(img image.Image) uint64 {
+	const n = 32
+	coeffs := dct2(thumbnail(img, n, n), n)
+	// Keep the 8x8 lowest frequencies, skipping the DC term which only
+	// reflects overall brightness.
+	low := make([]float64, 0, 64)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			low = append(low, coeffs[y*n+x])
+		}
+	}
+	sorted := append([]float64(nil), low[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var h uint64
+	for i, c := range low {
+		if c > median {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// dct2 returns the 2D type-II DCT of an n x n row-major matrix.
+func dct2(px []float64, n int) []float64 {
+	cos := make([]float64, n*n)
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			cos[k*n+i] = math.Cos(math.Pi / float64(n) * (float64(i) + 0.5) * float64(k))
+		}
+	}
+	rows := make([]float64, n*n)
+	for y := 0; y < n; y++ {
+		for k := 0; k < n; k++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += px[y*n+x] * cos[k*n+x]
+			}
+			rows[y*n+k] = s
+		}
+	}
+	out := make([]float64, n*n)
+	for x := 0; x < n; x++ {
+		for k := 0; k < n; k++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += rows[y*n+x] * cos[k*n+y]
+			}
+			out[k*n+x] = s
+		}
+	}
+	return out
+}
+
+// thumbnail downsamples img to a w x h grayscale image by averaging the
+// luminance of the source pixels covered by each thumbnail pixel, returning
+// the row-major luminance values.
+func thumbnail(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sums := make([]float64, w*h)
+	counts := make([]float64, w*h)
+	luma := lumaFunc(img)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		ty := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			tx := (x - b.Min.X) * w / b.Dx()
+			sums[ty*w+tx] += luma(x, y)
+			counts[ty*w+tx]++
+		}
+	}
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= counts[i]
+		}
+	}
+	return sums
+}
+
+// lumaFunc returns a function yielding the 8-bit luminance of a pixel, reading
+// the luma plane directly for the common decoded image types.
+func lumaFunc(img image.Image) func(x, y int) float64 {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) float64 { return float64(m.Y[m.YOffset(x, y)]) }
+	case *image.Gray:
+		return func(x, y int) float64 { return float64(m.Pix[m.PixOffset(x, y)]) }
+	default:
+		return func(x, y int) float64 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// ITU-R BT.601 weights, scaled down from 16-bit channels.
+			return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+}
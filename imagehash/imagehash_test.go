@@ -0,0 +1,100 @@
+package imagehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG of width×height pixels, up to its
+// IHDR chunk, which is all DecodeConfig reads.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	copy(ihdr[12:], []byte{8, 6, 0, 0, 0}) // 8-bit RGBA
+	b := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0d")
+	b = append(b, ihdr...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestDecode(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetGray(x, y, color.Gray{uint8(x * 4)})
+		}
+	}
+	h, err := Decode(bytes.NewReader(encodePNG(t, img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Compute(img); h != want {
+		t.Errorf("Decode = %+v, want %+v", h, want)
+	}
+	if decoding.free != MaxPixels {
+		t.Errorf("Decode left %d pixels of its budget free, want %d", decoding.free, MaxPixels)
+	}
+
+	side := uint32(1)
+	for int64(side)*int64(side) <= MaxPixels {
+		side *= 2
+	}
+	if _, err := Decode(bytes.NewReader(pngHeader(side, side))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decode of a %dx%d image: %v, want %v", side, side, err, ErrTooLarge)
+	}
+	// Images that fit are decoded, their truncated data failing to.
+	if _, err := Decode(bytes.NewReader(pngHeader(side/2, side/2))); err == nil || errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decode of a truncated %dx%d image: %v", side/2, side/2, err)
+	}
+	if decoding.free != MaxPixels {
+		t.Errorf("failed Decodes left %d pixels of the budget free, want %d", decoding.free, MaxPixels)
+	}
+}
+
+func TestPixelBudget(t *testing.T) {
+	b := newPixelBudget(10)
+	b.acquire(6)
+	b.acquire(4)
+	acquired := make(chan int64)
+	for _, n := range []int64{5, 3} {
+		go func(n int64) {
+			b.acquire(n)
+			acquired <- n
+		}(n)
+	}
+	select {
+	case n := <-acquired:
+		t.Fatalf("acquired %d pixels of an exhausted budget", n)
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.release(4)
+	if n := <-acquired; n != 3 {
+		t.Fatalf("acquired %d pixels, with only 4 free", n)
+	}
+	b.release(6)
+	if n := <-acquired; n != 5 {
+		t.Fatalf("acquired %d pixels, want 5", n)
+	}
+	b.release(5)
+	b.release(3)
+	if b.free != 10 {
+		t.Errorf("%d pixels free after every release, want 10", b.free)
+	}
+}
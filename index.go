@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/timblaktu/wupdedup/content"
+	"github.com/timblaktu/wupdedup/db"
+	"golang.org/x/exp/slog"
+)
+
+// Names of the secondary indexes kept on every provider bucket.
+const (
+	// "<algorithm>:<hex>" of each digest and provider checksum of a file.
+	hashIndex = "hash"
+	// Big-endian uint64 size of regular files, so sizes sort numerically.
+	sizeIndex = "size"
+	// Content category name, eg: "raw-image".
+	categoryIndex = "category"
+	// "YYYY-MM-DD" date a photo was taken or a video recorded.
+	capturedIndex = "captured"
+)
+
+var fileRecordIndexes = []db.Index{
+	{Name: hashIndex, Keys: recordIndexKeys(func(r *FileRecord) (keys [][]byte) {
+		for _, sums := range []map[string]string{r.Hashes, r.Checksums} {
+			for algo, sum := range sums {
+				keys = append(keys, []byte(algo+":"+sum))
+			}
+		}
+		return keys
+	})},
+	{Name: sizeIndex, Keys: recordIndexKeys(func(r *FileRecord) [][]byte {
+		if !r.Mode.IsRegular() || r.Error != "" {
+			return nil
+		}
+		return [][]byte{sizeKey(r.Size)}
+	})},
+	{Name: categoryIndex, Keys: recordIndexKeys(func(r *FileRecord) [][]byte {
+		if r.Category == content.Unknown {
+			return nil
+		}
+		return [][]byte{[]byte(r.Category.String())}
+	})},
+	{Name: capturedIndex, Keys: recordIndexKeys(func(r *FileRecord) [][]byte {
+		switch {
+		case r.Exif != nil && r.Exif.CaptureTime != nil:
+			return [][]byte{[]byte(r.Exif.CaptureTime.Format("2006-01-02"))}
+		case r.Video != nil && r.Video.CreationTime != nil:
+			return [][]byte{[]byte(r.Video.CreationTime.Format("2006-01-02"))}
+		default:
+			return nil
+		}
+	})},
+}
+
+// recordIndexKeys adapts a function of a FileRecord to an index's Keys.
+func recordIndexKeys(keys func(*FileRecord) [][]byte) func(k, v []byte) [][]byte {
+	return func(k, v []byte) [][]byte {
+		r, err := UnmarshalFileRecord(v)
+		if err != nil {
+			slog.Warn("not indexing undecodable record", "key", string(k), "err", err)
+			return nil
+		}
+		return keys(r)
+	}
+}
+
+func sizeKey(size int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(size))
+	return k
+}
+
+// openRecordBucket opens the bucket of FileRecords of a provider's root, with
+// its indexes.
+func openRecordBucket(d *db.DB, provider, root string) (*db.Bucket, error) {
+	b, err := d.BucketPath([]byte(provider), []byte(root))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range fileRecordIndexes {
+		if err := b.AddIndex(idx); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -1,67 +1,74 @@
 package main
 
 import (
-	"io/fs"
-	"os"
+	"context"
+	"fmt"
 	"path/filepath"
 
 	"github.com/timblaktu/wupdedup/config"
-	"github.com/timblaktu/wupdedup/content"
+	"github.com/timblaktu/wupdedup/digest"
+	wfs "github.com/timblaktu/wupdedup/fs"
 	"golang.org/x/exp/slog"
 )
 
+func init() {
+	registerStorageStrategyType("local", "a directory tree of the local filesystem",
+		func(conf *config.LocalConfig) (StorageStrategy, error) {
+			if err := conf.Valid(); err != nil {
+				return nil, err
+			}
+			if _, err := digest.ParseAlgorithms(conf.HashAlgorithms); err != nil {
+				return nil, fmt.Errorf("hash algorithms: %s", err)
+			}
+			if conf.HashWorkers < 0 {
+				return nil, fmt.Errorf("hash workers: must not be negative, got %d", conf.HashWorkers)
+			}
+			return LocalStrategy{*conf}, nil
+		})
+}
+
 // Concrete type that implements StorageStrategy interface for Local Storage
 type LocalStrategy struct {
 	conf config.LocalConfig
 }
 
-// var wg sync.WaitGroup
-
-func (s LocalStrategy) scanTree(c *StorageStrategyContext) {
-	slog.Info("scanning local tree..", "path", s.conf.RootPath)
-	// fileSystem := os.DirFS(s.conf.RootPath)
-	// fs.WalkDir(fileSystem, ".", func(path string, d fs.DirEntry, err error) error {
-	filepath.WalkDir(s.conf.RootPath, func(path string, d fs.DirEntry, err error) error {
-		fi, _ := d.Info()
-		slog.Debug("visiting", "path", path, "de.name", d.Name(),
-			"de.isdir", d.IsDir(), "de.type", d.Type(), "fi.name", fi.Name(),
-			"fi.size", fi.Size(), "fi.mode", fi.Mode().String(),
-			"fi.modtime", fi.ModTime(), "fi.isdir", fi.IsDir(), "fi.sys", fi.Sys())
-		c.nodeCount++
-		// defer wg.Done()
-		if d.IsDir() {
-			slog.Debug("ignoring bc isdir")
-			return nil
-		}
-		c.fileCount++
-		fullPath := path
-		// fullPath := filepath.Join(d.Name(), path)
-		ft, err := getType(fullPath)
-		if err != nil {
-			return err
-		}
-		slog.Debug("visited", "fullPath", fullPath, "filetype", ft, "#nodes",
-			c.nodeCount, "#files", c.fileCount, "DirEntry", d)
-		return nil
-	})
-	slog.Info("Done scanning local tree", "path", s.conf.RootPath,
-		"#nodes", c.nodeCount, "#files", c.fileCount)
+func (s LocalStrategy) Root() string {
+	return filepath.Clean(s.conf.RootPath)
 }
 
-// TODO: optimize this - faster way to get file mime type?
-func getType(path string) (string, error) {
-	f, err := os.Open(path)
+func (s LocalStrategy) ScanTree(ctx context.Context, c *StorageStrategyContext) error {
+	slog.Info("scanning local tree..", "path", s.conf.RootPath)
+	algos, err := digest.ParseAlgorithms(s.conf.HashAlgorithms)
 	if err != nil {
-		slog.Error("cannot open file", err, "path", path)
-		return "", err
+		return fmt.Errorf("hash algorithms: %s", err)
 	}
-	defer f.Close()
-	buf := make([]byte, 512)
-	_, err = f.Read(buf)
+	pool := newHashPool(ctx, c, s, s.conf.HashWorkers, algos, s.conf.ImageHashes,
+		s.conf.ExtractMetadata)
+	err = c.scanProvider(ctx, s, pool)
+	pool.Wait()
 	if err != nil {
-		slog.Error("cannot read 512 byte header from file", err, "path", path)
-		return "", err
+		return err
+	}
+	// Files being hashed when the scan was canceled weren't recorded.
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	ft := content.GetType(buf)
-	return ft, nil
+	slog.Info("Done scanning local tree", "path", s.conf.RootPath,
+		"#nodes", c.nodeCount, "#files", c.fileCount, "#unchanged", c.reuseCount,
+		"#errors", c.errorCount.Load())
+	return nil
+}
+
+func (s LocalStrategy) Provider() wfs.Provider {
+	return wfs.NewLocal(s.Root())
+}
+
+func (s LocalStrategy) ProviderPath(path string) (string, error) {
+	return wfs.NewLocal(s.Root()).Rel(path)
+}
+
+// RecordPath returns the local file name of path, which local files are
+// recorded at.
+func (s LocalStrategy) RecordPath(path string) string {
+	return filepath.Join(s.Root(), filepath.FromSlash(path))
 }
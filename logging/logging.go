@@ -2,12 +2,18 @@ package logging
 
 import (
 	"context"
+	"io"
 	"os"
 
 	"golang.org/x/exp/slog"
 )
 
 func Init(lvl string) {
+	InitTo(lvl, os.Stdout)
+}
+
+// InitTo is Init with logs written to w, eg: to keep stdout free for data.
+func InitTo(lvl string, w io.Writer) {
 	var sl slog.Level
 	// convert string (from .env/envconfig) to a slog.Level for slog API
 	sl.UnmarshalText([]byte(lvl))
@@ -23,7 +29,7 @@ func Init(lvl string) {
 			return a
 		},
 	}
-	th := opts.NewTextHandler(os.Stdout)
+	th := opts.NewTextHandler(w)
 
 	// "bind" constant attr key/val to all log records handled
 	// th := th.WithAttrs([]slog.Attr{slog.String("version", "v0.0.1-beta")})
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/timblaktu/wupdedup/db"
+)
+
+// migrations upgrade databases written by older builds, and must be appended
+// to, never edited, whenever the layout of stored records or keys changes.
+// Upgrading keeps scan state that can take days to rebuild from remote
+// providers.
+var migrations = []db.Migration{
+	{
+		Version:     1,
+		Description: "rebuild indexes of unversioned databases",
+		// Databases predating versioning hold version 1 FileRecords keyed by
+		// path, possibly without indexes, or with indexes from builds that
+		// indexed fewer fields.
+		Up: func(tx db.KVTx) error {
+			return db.DropIndexes(tx)
+		},
+	},
+	{
+		Version:     2,
+		Description: "nest records in per-root buckets",
+		// Records used to live directly in their provider's top-level bucket.
+		// Each provider scanned a single root, so they all move to the bucket
+		// of the root they're found to share, whatever is configured now.
+		Up: func(tx db.KVTx) error {
+			if err := db.DropIndexes(tx); err != nil {
+				return err
+			}
+			var providers [][]byte
+			err := tx.ForEachBucket(func(name []byte) error {
+				if !db.IsMetaBucket(name) && !nonRecordBuckets[string(name)] {
+					providers = append(providers, append([]byte(nil), name...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, provider := range providers {
+				if err := nestRecords(tx, provider); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// nestRecords moves the records of top-level bucket `provider` into the
+// nested bucket of the root they were scanned from. It fails if the bucket
+// holds anything but the provider's records, which would be lost.
+func nestRecords(tx db.KVTx, provider []byte) error {
+	b := tx.Bucket(provider)
+	var items []db.Item
+	var paths []string
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		r, err := UnmarshalFileRecord(v)
+		if err != nil || r.Provider != string(provider) {
+			return fmt.Errorf("bucket %s holds unknown value %q", provider, k)
+		}
+		items = append(items, db.Item{Key: append([]byte(nil), k...), Value: append([]byte(nil), v...)})
+		paths = append(paths, r.Path)
+		return nil
+	})
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	nested, err := b.CreateBucketIfNotExists([]byte(recordsRoot(paths)))
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := nested.Put(item.Key, item.Value); err != nil {
+			return err
+		}
+		if err := b.Delete(item.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordsRoot returns the root the records of paths were scanned from, as
+// the deepest directory holding them all. Only a root whose files all lie in
+// a single subdirectory is mistaken for that subdirectory, whose records are
+// then scanned again into the bucket of the root.
+func recordsRoot(paths []string) string {
+	root := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for !within(p, root) && root != filepath.Dir(root) {
+			root = filepath.Dir(root)
+		}
+	}
+	return root
+}
+
+// within reports whether path p lies under directory dir.
+func within(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/imagehash"
+	"golang.org/x/exp/slog"
+)
+
+// Name of the bucket holding groups of visually similar images.
+const nearDuplicatesBucket = "near-duplicates"
+
+// A NearDuplicateGroup is a set of images whose perceptual hashes are within
+// the configured Hamming distance of at least one other member.
+type NearDuplicateGroup struct {
+	// Perceptual hash kind compared, and its value for the first member.
+	Kind    imagehash.Kind    `json:"kind"`
+	Hash    string            `json:"hash"`
+	Members []DuplicateMember `json:"members"`
+}
+
+// Key returns the bucket key the group is stored under.
+func (g *NearDuplicateGroup) Key() []byte {
+	return []byte(fmt.Sprintf("%s:%s/%s/%s", g.Kind, g.Hash, g.Members[0].Provider, g.Members[0].Path))
+}
+
+// findNearDuplicates indexes the perceptual hashes of every image in every
+// context and replaces the contents of the near-duplicates bucket with the
+// groups of images within conf.NearDistance of one another.
+func findNearDuplicates(d *db.DB, contexts []*StorageStrategyContext, conf config.DedupConfig) error {
+	kind := imagehash.Kind(conf.NearHash)
+	if _, err := (imagehash.Hashes{}).Get(kind); err != nil {
+		return err
+	}
+	var members []DuplicateMember
+	var hashes []uint64
+	var tree imagehash.Tree
+	for _, c := range contexts {
+		err := c.bucket.Map(func(k, v []byte) error {
+			r, err := UnmarshalFileRecord(v)
+			if err != nil {
+				slog.Error("skipping undecodable record", err, "key", string(k))
+				return nil
+			}
+			if r.ImageHashes == nil {
+				return nil
+			}
+			h, _ := r.ImageHashes.Get(kind)
+			tree.Add(h, len(members))
+			members = append(members, DuplicateMember{c.name, r.Path})
+			hashes = append(hashes, h)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sets := newDisjointSets()
+	for range members {
+		sets.add()
+	}
+	for i, h := range hashes {
+		tree.Search(h, conf.NearDistance, func(j, distance int) {
+			sets.union(i, j)
+		})
+	}
+	clusters := make(map[int][]int)
+	for i := range members {
+		root := sets.find(i)
+		clusters[root] = append(clusters[root], i)
+	}
+
+	b, err := d.Recreate([]byte(nearDuplicatesBucket))
+	if err != nil {
+		return err
+	}
+	out := db.NewTypedBucket[string, *NearDuplicateGroup](b, db.StringKeys{}, db.JSONCodec[*NearDuplicateGroup]{})
+	var items []db.TypedItem[string, *NearDuplicateGroup]
+	for _, ids := range clusters {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Slice(ids, func(a, b int) bool {
+			ma, mb := members[ids[a]], members[ids[b]]
+			if ma.Provider != mb.Provider {
+				return ma.Provider < mb.Provider
+			}
+			return ma.Path < mb.Path
+		})
+		g := &NearDuplicateGroup{Kind: kind, Hash: fmt.Sprintf("%016x", hashes[ids[0]])}
+		for _, i := range ids {
+			g.Members = append(g.Members, members[i])
+		}
+		items = append(items, db.TypedItem[string, *NearDuplicateGroup]{Key: string(g.Key()), Value: g})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	if err := out.Insert(items); err != nil {
+		return err
+	}
+	slog.Info("Done finding near-duplicates", "#images", tree.Len(), "#groups", len(items),
+		"hash", kind, "distance", conf.NearDistance)
+	return nil
+}
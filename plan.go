@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/digest"
+	"github.com/timblaktu/wupdedup/fs"
+	"golang.org/x/exp/slog"
+)
+
+// A Plan lists the deletions that reclaim the space wasted by exact
+// duplicates, keeping one copy of each group.
+type Plan struct {
+	Created time.Time `json:"created"`
+	// Keep is the policy that chose the copy kept.
+	Keep    string        `json:"keep"`
+	Actions []*PlanAction `json:"actions"`
+}
+
+// A PlanAction deletes a copy of a file, provided it and the copy kept still
+// hold the contents they had when the plan was made.
+type PlanAction struct {
+	Action   string `json:"action"`
+	Provider string `json:"provider"`
+	Root     string `json:"root"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	// Hash is the "<algorithm>:<hex>" digest both copies must have.
+	Hash string `json:"hash"`
+	// Keep is the path of the copy kept.
+	Keep string `json:"keep"`
+}
+
+// Actions of a PlanAction.
+const deleteAction = "delete"
+
+// Policies choosing the copy of a group to keep.
+var keepPolicies = map[string]func(a, b *FileRecord) bool{
+	"oldest": func(a, b *FileRecord) bool { return a.ModTime.Before(b.ModTime) },
+	"newest": func(a, b *FileRecord) bool { return a.ModTime.After(b.ModTime) },
+	"shortest-path": func(a, b *FileRecord) bool {
+		return len(a.Path) < len(b.Path)
+	},
+}
+
+var planOptions struct {
+	providers []string
+	keep      string
+	output    string
+}
+
+var planCommand = &command{
+	name:    "plan",
+	summary: "plan the deletions reclaiming the space wasted by exact duplicates",
+	help: `Writes a JSON plan keeping one copy of each group of identical files within
+a provider, to be reviewed and carried out by the apply command. Duplicates
+held by different providers are left alone. Run dupes first.
+`,
+	flags: func(fs *flag.FlagSet, c *config.Config) {
+		providerFlag(fs, &planOptions.providers)
+		fs.StringVar(&planOptions.keep, "keep", "oldest", "`policy` choosing the copy kept: oldest, newest or shortest-path")
+		fs.StringVar(&planOptions.output, "o", "-", "`file` the plan is written to, or - for stdout")
+	},
+	run: noArgs(func(c *config.Config) error {
+		less, ok := keepPolicies[planOptions.keep]
+		if !ok {
+			return usageError(fmt.Sprintf("unknown keep policy %q", planOptions.keep))
+		}
+		all, err := loadStorageStrategyContexts(c)
+		if err != nil {
+			return err
+		}
+		contexts, err := selectContexts(all, planOptions.providers)
+		if err != nil {
+			return err
+		}
+		d, err := db.OpenReadOnly(c.DBFile, dbLockTimeout)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		p, err := makePlan(d, contexts, planOptions.keep, less)
+		if err != nil {
+			return err
+		}
+		return writePlan(p, planOptions.output)
+	}),
+}
+
+// makePlan plans the deletion of all but one copy of every exact duplicate
+// group of the contexts, keeping the copy first ordered by `less`, or with
+// the shortest then first path among equals.
+func makePlan(d *db.DB, contexts []*StorageStrategyContext, keep string,
+	less func(a, b *FileRecord) bool) (*Plan, error) {
+	records := make(map[string]*db.TypedBucket[string, *FileRecord])
+	roots := make(map[string]string)
+	for _, c := range contexts {
+		root := c.storageStrategy.Root()
+		b, err := d.OpenBucketPath([]byte(c.name), []byte(root))
+		if errors.Is(err, db.ErrBucketNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		records[c.name] = db.NewTypedBucket[string, *FileRecord](b, db.StringKeys{}, fileRecordCodec{})
+		roots[c.name] = root
+	}
+	p := &Plan{Created: time.Now().UTC(), Keep: keep}
+	var reclaimed int64
+	err := forEachGroup(d, func(g *ReportGroup) error {
+		provider := g.Members[0].Provider
+		if g.Kind != exactGroup || records[provider] == nil {
+			return nil
+		}
+		var copies []*FileRecord
+		for _, m := range g.Members {
+			r, ok, err := records[provider].Get(m.Path)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				slog.Warn("skipping group with a member no longer recorded, rerun dupes",
+					"hash", g.Hash, "path", m.Path)
+				return nil
+			}
+			copies = append(copies, r)
+		}
+		sort.SliceStable(copies, func(i, j int) bool {
+			a, b := copies[i], copies[j]
+			switch {
+			case less(a, b):
+				return true
+			case less(b, a):
+				return false
+			case len(a.Path) != len(b.Path):
+				return len(a.Path) < len(b.Path)
+			default:
+				return a.Path < b.Path
+			}
+		})
+		for _, r := range copies[1:] {
+			p.Actions = append(p.Actions, &PlanAction{
+				Action:   deleteAction,
+				Provider: provider,
+				Root:     roots[provider],
+				Path:     r.Path,
+				Size:     r.Size,
+				Hash:     g.Hash,
+				Keep:     copies[0].Path,
+			})
+			reclaimed += r.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Done planning", "#actions", len(p.Actions), "reclaimed", reclaimed)
+	return p, nil
+}
+
+func writePlan(p *Plan, path string) (err error) {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, ferr := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if ferr != nil {
+			return ferr
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+			}
+		}()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func readPlan(path string) (*Plan, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var p Plan
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("reading plan %s: %s", path, err)
+	}
+	return &p, nil
+}
+
+var applyOptions struct {
+	dryRun bool
+}
+
+var applyCommand = &command{
+	name:    "apply",
+	args:    "<plan|->",
+	summary: "carry out a plan made by the plan command",
+	help: `Before deleting a copy, checks that both it and the copy kept still hold the
+planned contents, by hashing them again. Copies failing the check are left
+alone. Deleted copies are dropped from the database.
+`,
+	flags: func(fs *flag.FlagSet, c *config.Config) {
+		fs.BoolVar(&applyOptions.dryRun, "dry-run", false, "check the plan without deleting anything")
+	},
+	run: func(c *config.Config, args []string) error {
+		if len(args) != 1 {
+			return usageError("expected a plan file, or - for stdin")
+		}
+		p, err := readPlan(args[0])
+		if err != nil {
+			return err
+		}
+		d, contexts, err := openStore(c, nil)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		return applyPlan(p, contexts, applyOptions.dryRun)
+	},
+}
+
+func applyPlan(p *Plan, contexts []*StorageStrategyContext, dryRun bool) error {
+	var done, failed int
+	var reclaimed int64
+	for _, a := range p.Actions {
+		if err := applyAction(a, contexts, dryRun); err != nil {
+			slog.Error("skipping plan action", err, "action", a.Action, "provider", a.Provider, "path", a.Path)
+			failed++
+			continue
+		}
+		done++
+		reclaimed += a.Size
+	}
+	slog.Info("Done applying plan", "dryRun", dryRun, "#done", done, "#failed", failed, "reclaimed", reclaimed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d plan actions failed", failed, len(p.Actions))
+	}
+	return nil
+}
+
+func applyAction(a *PlanAction, contexts []*StorageStrategyContext, dryRun bool) error {
+	if a.Action != deleteAction {
+		return fmt.Errorf("unknown action %q", a.Action)
+	}
+	var c *StorageStrategyContext
+	for _, context := range contexts {
+		if context.name == a.Provider && context.storageStrategy.Root() == a.Root {
+			c = context
+		}
+	}
+	if c == nil {
+		return fmt.Errorf("root %s of provider %s isn't configured", a.Root, a.Provider)
+	}
+	ps, _ := c.storageStrategy.(ProviderStrategy)
+	if ps == nil || !ps.Provider().Capabilities().Delete {
+		return fmt.Errorf("provider %s can't delete files", a.Provider)
+	}
+	p := ps.Provider()
+	keep, err := ps.ProviderPath(a.Keep)
+	if err != nil {
+		return err
+	}
+	path, err := ps.ProviderPath(a.Path)
+	if err != nil {
+		return err
+	}
+	if err := checkDistinct(p, keep, path); err != nil {
+		return err
+	}
+	if err := checkContent(p, keep, a.Size, a.Hash); err != nil {
+		return fmt.Errorf("copy kept: %s", err)
+	}
+	if err := checkContent(p, path, a.Size, a.Hash); err != nil {
+		return err
+	}
+	if dryRun {
+		slog.Info("would delete", "provider", a.Provider, "path", a.Path, "keep", a.Keep)
+		return nil
+	}
+	if err := p.Delete(context.Background(), path); err != nil {
+		return err
+	}
+	slog.Info("deleted", "provider", a.Provider, "path", a.Path, "keep", a.Keep)
+	if err := c.records.Delete(a.Path); err != nil {
+		slog.Error("cannot drop record of deleted file", err, "path", a.Path)
+	}
+	return nil
+}
+
+// checkDistinct checks that the copy kept and the copy deleted are different
+// files, rather than the same path or hard links to the same file, whose
+// deletion would reclaim nothing, or lose the contents.
+func checkDistinct(p fs.Provider, keep, path string) error {
+	if keep == path {
+		return fmt.Errorf("%s is the copy kept", path)
+	}
+	ctx := context.Background()
+	k, err := p.Stat(ctx, keep)
+	if err != nil {
+		return fmt.Errorf("copy kept: %s", err)
+	}
+	e, err := p.Stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	kdev, kino, _ := fileIdentity(k.Sys)
+	dev, ino, _ := fileIdentity(e.Sys)
+	if ino != 0 && dev == kdev && ino == kino {
+		return fmt.Errorf("%s is a hard link to the copy kept %s", path, keep)
+	}
+	return nil
+}
+
+// checkContent checks that the file at `path` of provider p has `size` bytes,
+// and the "<algorithm>:<hex>" digest `hash`.
+func checkContent(p fs.Provider, path string, size int64, hash string) error {
+	algo, sum, ok := strings.Cut(hash, ":")
+	if !ok {
+		return fmt.Errorf("malformed hash %q", hash)
+	}
+	algos, err := digest.ParseAlgorithms([]string{algo})
+	if err != nil {
+		return err
+	} else if len(algos) != 1 {
+		return fmt.Errorf("malformed hash %q", hash)
+	}
+	ctx := context.Background()
+	e, err := p.Stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !e.Mode.IsRegular() {
+		return fmt.Errorf("%s changed: not a regular file anymore", path)
+	} else if e.Size != size {
+		return fmt.Errorf("%s changed: %d bytes, planned %d", path, e.Size, size)
+	}
+	if got, err := p.Hash(ctx, path, algos[0]); err != nil {
+		return err
+	} else if got != sum {
+		return fmt.Errorf("%s changed: %s digest differs from the planned one", path, algo)
+	}
+	return nil
+}
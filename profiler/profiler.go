@@ -43,24 +43,24 @@ func NewProfilerMode(s string) ProfilerMode {
 
 type ProfileConfig struct {
 	// List of selected Profiler Modes
-	Modes []string `required:"false" split_words:"true" default:"Cpu,"`
+	Modes []string `required:"false" split_words:"true" default:"Cpu," yaml:"modes" toml:"modes"`
 
 	// Directory path to dump the profile output to. Default is current directory.
-	DirPath string `required:"false" split_words:"true" default:"."`
+	DirPath string `required:"false" split_words:"true" default:"." yaml:"dir_path" toml:"dir_path"`
 
 	// Quiet disables info log output.
-	Quiet bool `required:"false" split_words:"true" default:true`
+	Quiet bool `required:"false" split_words:"true" yaml:"quiet" toml:"quiet"`
 
 	// NoShutdownHook controls whether the profiling package should
 	// hook SIGINT to automatically Stop().
-	NoShutdownHook bool `required:"false" split_words:"true" default:true`
+	NoShutdownHook bool `required:"false" split_words:"true" yaml:"no_shutdown_hook" toml:"no_shutdown_hook"`
 
 	// MemProfileRate is the rate for the memory profiler. Default is 4096.
 	// To include every allocated block in the profile, set MemProfileRate to 1.
-	MemProfileRate int `required:"false" split_words:"true" default:4096`
+	MemProfileRate int `required:"false" split_words:"true" yaml:"mem_profile_rate" toml:"mem_profile_rate"`
 
 	// MemProfileType = heap or alloc. Default is heap.
-	MemProfileType string `required:"false" split_words:"true" default:"heap"`
+	MemProfileType string `required:"false" split_words:"true" default:"heap" yaml:"mem_profile_type" toml:"mem_profile_type"`
 }
 
 func (c *ProfileConfig) Specified() bool {
@@ -0,0 +1,65 @@
+package quicktime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errStop ends a walk early without reporting an error.
+var errStop = errors.New("stop walking")
+
+// Largest atom payload read into memory.
+const maxAtomRead = 1 << 20
+
+// A box is an atom, located by the offsets of its payload.
+type box struct {
+	typ        string
+	start, end int64
+}
+
+// read returns the first n bytes of the box's payload.
+func (b box) read(r io.ReaderAt, n int) ([]byte, error) {
+	if int64(n) > b.end-b.start || n > maxAtomRead {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, b.start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// walk calls fn for each atom between start and end.
+func walk(r io.ReaderAt, start, end int64, fn func(box) error) error {
+	hdr := make([]byte, 16)
+	for off := start; off+8 <= end; {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr))
+		b := box{typ: string(hdr[4:8]), start: off + 8}
+		switch size {
+		case 0:
+			// extends to the end of the enclosing atom
+			size = end - off
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:]))
+			b.start += 8
+		}
+		// size is compared with what's left, as off+size could overflow.
+		if size < b.start-off || size > end-off {
+			return fmt.Errorf("malformed %q atom at offset %d", b.typ, off)
+		}
+		b.end = off + size
+		if err := fn(b); err != nil {
+			return err
+		}
+		off = b.end
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+// Package quicktime is a pure Go reader for the metadata of QuickTime and
+// ISO base media (MP4, M4V, 3GP) files, as recorded by phones and cameras.
+package quicktime
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ErrNoMovie is returned for files without a movie (moov) atom.
+var ErrNoMovie = errors.New("no QuickTime movie atom")
+
+// Metadata holds the properties of a movie useful for telling videos apart
+// and organizing them by when and where they were recorded.
+type Metadata struct {
+	// Duration in seconds.
+	Duration float64 `json:"duration,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	// Codec is the sample entry fourcc of the first video track (eg: "avc1").
+	Codec        string     `json:"codec,omitempty"`
+	CreationTime *time.Time `json:"creation_time,omitempty"`
+	Location     *Location  `json:"location,omitempty"`
+}
+
+// Location holds a recording location in decimal degrees, and meters.
+type Location struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Altitude  float64 `json:"alt,omitempty"`
+}
+
+// Decodable reports whether files of the given MIME type are ISO base media
+// files this package can read.
+func Decodable(mimeType string) bool {
+	switch mimeType {
+	case "video/mp4", "video/quicktime", "video/x-m4v", "video/3gpp", "video/3gpp2":
+		return true
+	default:
+		return false
+	}
+}
+
+// File reads the metadata of the movie at path.
+func File(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Decode(f, fi.Size())
+}
+
+// Decode reads the metadata of a movie of the given size.
+func Decode(r io.ReaderAt, size int64) (*Metadata, error) {
+	var moov *box
+	err := walk(r, 0, size, func(b box) error {
+		if b.typ == "moov" {
+			moov = &b
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	if moov == nil {
+		return nil, ErrNoMovie
+	}
+	m := &Metadata{}
+	d := &decoder{r: r, m: m}
+	if err := walk(r, moov.start, moov.end, d.moovChild); err != nil {
+		return nil, err
+	}
+	if d.created != nil {
+		m.CreationTime = d.created
+	}
+	return m, nil
+}
+
+// 1904-01-01, the epoch of QuickTime timestamps.
+var epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type decoder struct {
+	r       io.ReaderAt
+	m       *Metadata
+	created *time.Time
+	// Per-track state while walking a trak atom.
+	handler string
+	width   int
+	height  int
+	codec   string
+}
+
+func (d *decoder) moovChild(b box) error {
+	switch b.typ {
+	case "mvhd":
+		return d.mvhd(b)
+	case "trak":
+		d.handler, d.width, d.height, d.codec = "", 0, 0, ""
+		if err := walk(d.r, b.start, b.end, d.trakChild); err != nil {
+			return err
+		}
+		if d.handler == "vide" && d.m.Codec == "" {
+			d.m.Width, d.m.Height, d.m.Codec = d.width, d.height, d.codec
+		}
+	case "udta":
+		return walk(d.r, b.start, b.end, d.udtaChild)
+	case "meta":
+		return d.meta(b)
+	}
+	return nil
+}
+
+func (d *decoder) trakChild(b box) error {
+	switch b.typ {
+	case "tkhd":
+		return d.tkhd(b)
+	case "mdia", "minf", "stbl":
+		return walk(d.r, b.start, b.end, d.trakChild)
+	case "hdlr":
+		buf, err := b.read(d.r, 12)
+		if err != nil {
+			return nil
+		}
+		d.handler = string(buf[8:12])
+	case "stsd":
+		// version/flags, entry count, then the first entry's size and format.
+		buf, err := b.read(d.r, 16)
+		if err != nil {
+			return nil
+		}
+		d.codec = string(buf[12:16])
+	}
+	return nil
+}
+
+func (d *decoder) mvhd(b box) error {
+	buf, err := b.read(d.r, 32)
+	if err != nil {
+		return nil
+	}
+	var created uint64
+	var timescale uint32
+	var duration uint64
+	if buf[0] == 1 {
+		created = binary.BigEndian.Uint64(buf[4:])
+		timescale = binary.BigEndian.Uint32(buf[20:])
+		duration = binary.BigEndian.Uint64(buf[24:])
+	} else {
+		created = uint64(binary.BigEndian.Uint32(buf[4:]))
+		timescale = binary.BigEndian.Uint32(buf[12:])
+		duration = uint64(binary.BigEndian.Uint32(buf[16:]))
+	}
+	if timescale > 0 {
+		d.m.Duration = float64(duration) / float64(timescale)
+	}
+	if created > 0 && d.created == nil {
+		t := epoch.Add(time.Duration(created) * time.Second)
+		d.created = &t
+	}
+	return nil
+}
+
+func (d *decoder) tkhd(b box) error {
+	// The 16.16 fixed point width and height close the atom, whose earlier
+	// fields are wider in version 1.
+	n, off := 84, 76
+	if v, err := b.read(d.r, 1); err == nil && v[0] == 1 {
+		n, off = 96, 88
+	}
+	buf, err := b.read(d.r, n)
+	if err != nil {
+		return nil
+	}
+	d.width = int(binary.BigEndian.Uint32(buf[off:]) >> 16)
+	d.height = int(binary.BigEndian.Uint32(buf[off+4:]) >> 16)
+	return nil
+}
+
+func (d *decoder) udtaChild(b box) error {
+	switch b.typ {
+	case "\xa9xyz":
+		// 16-bit string length, 16-bit language code, then an ISO 6709 string.
+		buf, err := b.read(d.r, int(b.end-b.start))
+		if err != nil || len(buf) < 4 {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(buf))
+		if n > len(buf)-4 {
+			n = len(buf) - 4
+		}
+		if loc, ok := parseISO6709(string(buf[4 : 4+n])); ok {
+			d.m.Location = loc
+		}
+	case "meta":
+		return d.meta(b)
+	}
+	return nil
+}
+
+// meta reads Apple's keyed metadata: a keys atom naming each key, and an ilst
+// atom holding one child per key, typed with the key's 1-based index.
+func (d *decoder) meta(b box) error {
+	start := b.start
+	// In ISO files meta is a full atom, with version and flags preceding its
+	// children; in QuickTime files the children come first.
+	if hdr, err := b.read(d.r, 8); err == nil {
+		switch string(hdr[4:8]) {
+		case "hdlr", "keys", "ilst":
+		default:
+			start += 4
+		}
+	}
+	var keys []string
+	values := make(map[uint32][]byte)
+	err := walk(d.r, start, b.end, func(c box) error {
+		switch c.typ {
+		case "keys":
+			buf, err := c.read(d.r, int(c.end-c.start))
+			if err != nil || len(buf) < 8 {
+				return nil
+			}
+			n := binary.BigEndian.Uint32(buf[4:])
+			buf = buf[8:]
+			for i := uint32(0); i < n && len(buf) >= 8; i++ {
+				size := int(binary.BigEndian.Uint32(buf))
+				if size < 8 || size > len(buf) {
+					break
+				}
+				keys = append(keys, string(buf[8:size]))
+				buf = buf[size:]
+			}
+		case "ilst":
+			return walk(d.r, c.start, c.end, func(item box) error {
+				idx := binary.BigEndian.Uint32([]byte(item.typ))
+				return walk(d.r, item.start, item.end, func(data box) error {
+					if data.typ != "data" {
+						return nil
+					}
+					buf, err := data.read(d.r, int(data.end-data.start))
+					if err == nil && len(buf) > 8 {
+						// type indicator and locale precede the value
+						values[idx] = buf[8:]
+					}
+					return nil
+				})
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		v, ok := values[uint32(i+1)]
+		if !ok {
+			continue
+		}
+		switch key {
+		case "com.apple.quicktime.location.ISO6709":
+			if loc, ok := parseISO6709(string(v)); ok {
+				d.m.Location = loc
+			}
+		case "com.apple.quicktime.creationdate":
+			if t, err := time.Parse("2006-01-02T15:04:05-0700", string(v)); err == nil {
+				d.created = &t
+			}
+		}
+	}
+	return nil
+}
+
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+// parseISO6709 parses the decimal degrees form of an ISO 6709 location,
+// eg: "+47.6097-122.3331+050.000/".
+func parseISO6709(s string) (*Location, bool) {
+	m := iso6709.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil {
+		return nil, false
+	}
+	loc := &Location{Latitude: lat, Longitude: lon}
+	if m[3] != "" {
+		loc.Altitude, _ = strconv.ParseFloat(m[3], 64)
+	}
+	return loc, true
+}
@@ -0,0 +1,248 @@
+package quicktime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// atom returns an atom of type typ holding the concatenated payloads.
+func atom(typ string, payloads ...[]byte) []byte {
+	body := bytes.Join(payloads, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// atom64 returns an atom with a 64-bit size.
+func atom64(typ string, payloads ...[]byte) []byte {
+	body := bytes.Join(payloads, nil)
+	b := append(binary.BigEndian.AppendUint32(nil, 1), typ...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(body)))
+	return append(b, body...)
+}
+
+// atom0 returns an atom whose size is 0, extending to the end of its parent.
+func atom0(typ string, payloads ...[]byte) []byte {
+	return append(append(make([]byte, 4), typ...), bytes.Join(payloads, nil)...)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// secs returns the QuickTime timestamp of t.
+func secs(t time.Time) uint64 {
+	return uint64(t.Sub(epoch) / time.Second)
+}
+
+var created = time.Date(2021, 6, 5, 4, 3, 2, 0, time.UTC)
+
+func mvhd0(timescale, duration uint32) []byte {
+	return atom("mvhd", u32(0), u32(uint32(secs(created))), u32(0), u32(timescale), u32(duration), make([]byte, 80))
+}
+
+func mvhd1(timescale uint32, duration uint64) []byte {
+	return atom("mvhd", u32(1<<24), u64(secs(created)), u64(0), u32(timescale), u64(duration), make([]byte, 80))
+}
+
+// tkhd returns a track header of the given version, whose width and height
+// are 16.16 fixed point numbers after the wider fields of version 1.
+func tkhd(version byte, width, height uint16) []byte {
+	fields := 72
+	if version == 1 {
+		fields = 84
+	}
+	return atom("tkhd", []byte{version, 0, 0, 7}, make([]byte, fields),
+		u32(uint32(width)<<16), u32(uint32(height)<<16))
+}
+
+func videoTrak(version byte, width, height uint16, codec string) []byte {
+	return atom("trak", tkhd(version, width, height),
+		atom("mdia",
+			atom("hdlr", u32(0), []byte("mhlrvide"), make([]byte, 12)),
+			atom("minf", atom("stbl", atom("stsd", u32(0), u32(1), u32(86), []byte(codec), make([]byte, 78))))))
+}
+
+func soundTrak() []byte {
+	return atom("trak", tkhd(0, 0, 0),
+		atom("mdia", atom("hdlr", u32(0), []byte("mhlrsoun"), make([]byte, 12)),
+			atom("minf", atom("stbl", atom("stsd", u32(0), u32(1), u32(36), []byte("mp4a"), make([]byte, 20))))))
+}
+
+func xyz(s string) []byte {
+	return atom("\xa9xyz", u16(uint16(len(s))), u16(0x15c7), []byte(s))
+}
+
+// keyedMeta returns Apple keyed metadata, full atom if iso.
+func keyedMeta(iso bool, kv ...string) []byte {
+	var keys, items [][]byte
+	for i := 0; i < len(kv); i += 2 {
+		keys = append(keys, atom("mdta", []byte(kv[i])))
+		items = append(items, atom(string(u32(uint32(i/2+1))), atom("data", u32(1), u32(0), []byte(kv[i+1]))))
+	}
+	children := [][]byte{
+		atom("hdlr", u32(0), u32(0), []byte("mdta"), make([]byte, 13)),
+		atom("keys", append(append(u32(0), u32(uint32(len(keys)))...), bytes.Join(keys, nil)...)),
+		atom("ilst", items...),
+	}
+	if iso {
+		children = append([][]byte{u32(0)}, children...)
+	}
+	return atom("meta", children...)
+}
+
+func movie(moov ...[]byte) []byte {
+	return bytes.Join([][]byte{
+		atom("ftyp", []byte("qt  "), u32(0), []byte("qt  ")),
+		atom("wide"),
+		atom("mdat", make([]byte, 64)),
+		atom("moov", moov...),
+	}, nil)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     []byte
+		want     Metadata
+		created  time.Time
+		location *Location
+	}{
+		{
+			name:    "version 0 headers",
+			file:    movie(mvhd0(600, 9000), soundTrak(), videoTrak(0, 1920, 1080, "avc1")),
+			want:    Metadata{Duration: 15, Width: 1920, Height: 1080, Codec: "avc1"},
+			created: created,
+		},
+		{
+			name:    "version 1 headers",
+			file:    movie(mvhd1(90000, 1<<33), videoTrak(1, 3840, 2160, "hvc1")),
+			want:    Metadata{Duration: float64(1<<33) / 90000, Width: 3840, Height: 2160, Codec: "hvc1"},
+			created: created,
+		},
+		{
+			name:    "first video track",
+			file:    movie(mvhd0(1000, 500), videoTrak(0, 640, 480, "jpeg"), videoTrak(0, 320, 240, "avc1")),
+			want:    Metadata{Duration: 0.5, Width: 640, Height: 480, Codec: "jpeg"},
+			created: created,
+		},
+		{
+			name:     "udta location",
+			file:     movie(mvhd0(1, 1), atom("udta", xyz("+47.6097-122.3331+050.000/"))),
+			want:     Metadata{Duration: 1},
+			created:  created,
+			location: &Location{Latitude: 47.6097, Longitude: -122.3331, Altitude: 50},
+		},
+		{
+			name: "keyed metadata",
+			file: movie(mvhd0(1, 1), keyedMeta(false,
+				"com.apple.quicktime.make", "Apple",
+				"com.apple.quicktime.location.ISO6709", "+35.6895+139.6917/",
+				"com.apple.quicktime.creationdate", "2022-03-04T05:06:07+0900")),
+			want:     Metadata{Duration: 1},
+			created:  time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("", 9*3600)),
+			location: &Location{Latitude: 35.6895, Longitude: 139.6917},
+		},
+		{
+			name: "ISO keyed metadata in udta",
+			file: movie(mvhd0(1, 1), atom("udta", keyedMeta(true,
+				"com.apple.quicktime.location.ISO6709", "-33.8688+151.2093+012.5/"))),
+			want:     Metadata{Duration: 1},
+			created:  created,
+			location: &Location{Latitude: -33.8688, Longitude: 151.2093, Altitude: 12.5},
+		},
+		{
+			name:    "64-bit and open-ended sizes",
+			file:    append(atom64("mdat", make([]byte, 32)), atom0("moov", mvhd0(2, 3), atom0("trak", tkhd(0, 720, 576)))...),
+			want:    Metadata{Duration: 1.5},
+			created: created,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Decode(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := *m
+			got.CreationTime, got.Location = nil, nil
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if m.CreationTime == nil || !m.CreationTime.Equal(tt.created) {
+				t.Errorf("creation time %v, want %v", m.CreationTime, tt.created)
+			}
+			switch {
+			case tt.location == nil && m.Location != nil:
+				t.Errorf("location %+v, want none", *m.Location)
+			case tt.location != nil && m.Location == nil:
+				t.Errorf("no location, want %+v", *tt.location)
+			case tt.location != nil && (math.Abs(m.Location.Latitude-tt.location.Latitude) > 1e-9 ||
+				math.Abs(m.Location.Longitude-tt.location.Longitude) > 1e-9 ||
+				math.Abs(m.Location.Altitude-tt.location.Altitude) > 1e-9):
+				t.Errorf("location %+v, want %+v", *m.Location, *tt.location)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	huge := append(u32(1), "moov"...)
+	huge = append(huge, u64(math.MaxInt64)...)
+	tests := []struct {
+		name   string
+		file   []byte
+		noMoov bool
+	}{
+		{name: "no movie", file: atom("ftyp", []byte("isom")), noMoov: true},
+		{name: "empty", file: nil, noMoov: true},
+		{name: "size past the end", file: append(u32(1000), "moov"...)},
+		{name: "size under the header", file: append(u32(4), "moov"...)},
+		{name: "64-bit size overflowing the offset", file: append(atom("free"), huge...)},
+		{name: "64-bit size wrapping negative", file: append(append(u32(1), "mdat"...), u64(math.MaxUint64)...)},
+		{name: "movie cut short", file: movie(mvhd0(1, 1))[:60]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Decode(bytes.NewReader(tt.file), int64(len(tt.file)))
+			switch {
+			case tt.noMoov && !errors.Is(err, ErrNoMovie):
+				t.Errorf("got %+v, %v, want %v", m, err, ErrNoMovie)
+			case !tt.noMoov && (err == nil || errors.Is(err, ErrNoMovie)):
+				t.Errorf("got %+v, %v, want a decoding error", m, err)
+			}
+		})
+	}
+}
+
+func TestParseISO6709(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *Location
+	}{
+		{"+47.6097-122.3331+050.000/", &Location{47.6097, -122.3331, 50}},
+		{"-12.5+045/", &Location{-12.5, 45, 0}},
+		{"+47.6097", nil},
+		{"", nil},
+		{"47.6097 -122.3331", nil},
+	}
+	for _, tt := range tests {
+		got, ok := parseISO6709(tt.s)
+		if (tt.want == nil) != !ok || ok && *got != *tt.want {
+			t.Errorf("parseISO6709(%q) = %+v, %v, want %+v", tt.s, got, ok, tt.want)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(movie(mvhd0(600, 9000), soundTrak(), videoTrak(0, 1920, 1080, "avc1")))
+	f.Add(movie(mvhd1(90000, 1<<33), videoTrak(1, 3840, 2160, "hvc1"), atom("udta", xyz("+1+2/"))))
+	f.Add(movie(keyedMeta(true, "com.apple.quicktime.creationdate", "2022-03-04T05:06:07+0900")))
+	f.Add(append(atom64("mdat", make([]byte, 8)), atom0("moov", mvhd0(1, 1))...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Decode(bytes.NewReader(data), int64(len(data)))
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/timblaktu/wupdedup/content"
+	"github.com/timblaktu/wupdedup/digest"
+	"github.com/timblaktu/wupdedup/exif"
+	wfs "github.com/timblaktu/wupdedup/fs"
+	"github.com/timblaktu/wupdedup/imagehash"
+	"github.com/timblaktu/wupdedup/quicktime"
+)
+
+// fileRecordVersion is the version of the FileRecord schema written by this
+// build. Bump it whenever the encoded layout of FileRecord changes.
+const fileRecordVersion = 1
+
+// -----------------------------------------------------------------------------
+// FileRecord is the per-file entry a StorageStrategy persists in its context's
+// bucket, keyed by the file's path.
+type FileRecord struct {
+	Version  int              `json:"version"`
+	Path     string           `json:"path"`
+	Size     int64            `json:"size"`
+	Mode     fs.FileMode      `json:"mode"`
+	ModTime  time.Time        `json:"mtime"`
+	MimeType string           `json:"mime"`
+	Category content.Category `json:"category,omitempty"`
+	Provider string           `json:"provider"`
+	Session  string           `json:"session"`
+
+	// Device, inode and status-change time, where the platform provides them.
+	Dev   uint64    `json:"dev,omitempty"`
+	Inode uint64    `json:"ino,omitempty"`
+	CTime time.Time `json:"ctime"`
+
+	// Hex-encoded content digests keyed by algorithm name (eg: "sha256").
+	Hashes map[string]string `json:"hashes,omitempty"`
+
+	// Hex-encoded checksums reported by the provider itself rather than
+	// computed by us (eg: SmugMug's ArchivedMD5), keyed by algorithm name.
+	Checksums map[string]string `json:"checksums,omitempty"`
+
+	// Perceptual hashes, for images only.
+	ImageHashes *imagehash.Hashes `json:"imagehashes,omitempty"`
+
+	// Why the image couldn't be decoded, so that it isn't retried until the
+	// file changes.
+	ImageError string `json:"imageerror,omitempty"`
+
+	// Embedded EXIF metadata, for images only. Empty if the image has none.
+	Exif *exif.Metadata `json:"exif,omitempty"`
+
+	// Movie metadata, for QuickTime/MP4 videos only. Empty if unreadable.
+	Video *quicktime.Metadata `json:"video,omitempty"`
+
+	// Why the file couldn't be fully read, if it couldn't.
+	Error string `json:"error,omitempty"`
+}
+
+// NewFileRecord returns the record, stored at path, of the file listed by its
+// provider as entry e, holding the checksums the provider reported.
+func NewFileRecord(c *StorageStrategyContext, path string, e *wfs.Entry) *FileRecord {
+	dev, ino, ctime := fileIdentity(e.Sys)
+	return &FileRecord{
+		Version:   fileRecordVersion,
+		Path:      path,
+		Size:      e.Size,
+		Mode:      e.Mode,
+		ModTime:   e.ModTime,
+		Provider:  c.name,
+		Session:   c.session,
+		Dev:       dev,
+		Inode:     ino,
+		CTime:     ctime,
+		Checksums: e.Hashes,
+	}
+}
+
+// Unchanged reports whether r describes the same, unmodified file as the
+// record prev stored by an earlier scan. Files that previously failed to be
+// read are never considered unchanged, so they're retried.
+func (r *FileRecord) Unchanged(prev *FileRecord) bool {
+	return prev.Error == "" && r.Dev == prev.Dev && r.Inode == prev.Inode && r.Size == prev.Size &&
+		r.ModTime.Equal(prev.ModTime) && r.CTime.Equal(prev.CTime)
+}
+
+// Reuse copies the content-derived fields of an unchanged file's previous
+// record into r.
+func (r *FileRecord) Reuse(prev *FileRecord) {
+	r.MimeType = prev.MimeType
+	r.Category = prev.Category
+	r.Hashes = prev.Hashes
+	r.ImageHashes = prev.ImageHashes
+	r.ImageError = prev.ImageError
+	r.Exif = prev.Exif
+	r.Video = prev.Video
+}
+
+// NewErrorRecord returns the record of a file that couldn't even be stat'ed.
+func NewErrorRecord(c *StorageStrategyContext, path string, err error) *FileRecord {
+	return &FileRecord{
+		Version:  fileRecordVersion,
+		Path:     path,
+		Provider: c.name,
+		Session:  c.session,
+		Error:    err.Error(),
+	}
+}
+
+// Key returns the bucket key the record is stored under.
+func (r *FileRecord) Key() []byte {
+	return []byte(r.Path)
+}
+
+func (r *FileRecord) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalFileRecord decodes a record read from a bucket, rejecting records
+// written by a newer schema than this build understands.
+func UnmarshalFileRecord(v []byte) (*FileRecord, error) {
+	var r FileRecord
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, err
+	}
+	if r.Version > fileRecordVersion {
+		return nil, fmt.Errorf("file record %q has unsupported version %d (max %d)",
+			r.Path, r.Version, fileRecordVersion)
+	}
+	return &r, nil
+}
+
+// fileRecordCodec stores FileRecords in typed buckets.
+type fileRecordCodec struct{}
+
+func (fileRecordCodec) Encode(r *FileRecord) ([]byte, error) {
+	return r.Marshal()
+}
+
+func (fileRecordCodec) Decode(v []byte) (*FileRecord, error) {
+	return UnmarshalFileRecord(v)
+}
+
+// HasHashes reports whether r holds a digest for every algorithm in algos.
+func (r *FileRecord) HasHashes(algos []digest.Algorithm) bool {
+	for _, a := range algos {
+		if r.Hashes[string(a)] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// newScanSession returns an identifier for a single run over all strategies,
+// stamped into every record written during that run. It's precise enough for
+// back-to-back runs to differ, as records not stamped by a run are pruned.
+func newScanSession() string {
+	return time.Now().UTC().Format("20060102T150405.000000Z")
+}
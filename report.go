@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+)
+
+// A ReportGroup is a duplicate group of any kind, as reported and exported.
+type ReportGroup struct {
+	// Kind is exactGroup, crossProviderGroup or nearGroup.
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+	// Size and Wasted are unknown, and 0, for near duplicates.
+	Size    int64             `json:"size,omitempty"`
+	Wasted  int64             `json:"wasted,omitempty"`
+	Members []DuplicateMember `json:"members"`
+}
+
+// forEachGroup calls fn with every group found by the last dedup,
+// correlation and near-duplicate runs, skipping those never run.
+func forEachGroup(d *db.DB, fn func(g *ReportGroup) error) error {
+	b, err := openResults(d, duplicatesBucket)
+	if err == nil && b != nil {
+		err = db.NewTypedBucket[string, *DuplicateGroup](b, db.StringKeys{}, db.JSONCodec[*DuplicateGroup]{}).
+			Map(func(k string, g *DuplicateGroup) error {
+				return fn(&ReportGroup{exactGroup, k, g.Hash, g.Size, g.Wasted(), g.Members})
+			})
+	}
+	if err != nil {
+		return err
+	}
+	b, err = openResults(d, crossProviderBucket)
+	if err == nil && b != nil {
+		err = db.NewTypedBucket[string, *CrossProviderGroup](b, db.StringKeys{}, db.JSONCodec[*CrossProviderGroup]{}).
+			Map(func(k string, g *CrossProviderGroup) error {
+				wasted := g.Size * int64(len(g.Members)-1)
+				return fn(&ReportGroup{crossProviderGroup, k, strings.Join(g.Hashes, " "), g.Size, wasted, g.Members})
+			})
+	}
+	if err != nil {
+		return err
+	}
+	b, err = openResults(d, nearDuplicatesBucket)
+	if err == nil && b != nil {
+		err = db.NewTypedBucket[string, *NearDuplicateGroup](b, db.StringKeys{}, db.JSONCodec[*NearDuplicateGroup]{}).
+			Map(func(k string, g *NearDuplicateGroup) error {
+				return fn(&ReportGroup{nearGroup, k, string(g.Kind) + ":" + g.Hash, 0, 0, g.Members})
+			})
+	}
+	return err
+}
+
+// forEachScan calls fn with the statistics of every scan, oldest first.
+func forEachScan(d *db.DB, fn func(s *ScanStats) error) error {
+	b, err := openResults(d, scansBucket)
+	if err != nil || b == nil {
+		return err
+	}
+	return db.NewTypedBucket[string, *ScanStats](b, db.StringKeys{}, db.JSONCodec[*ScanStats]{}).
+		Map(func(_ string, s *ScanStats) error {
+			return fn(s)
+		})
+}
+
+// A Report summarizes the state of the inventory.
+type Report struct {
+	Generated time.Time `json:"generated"`
+	// Scans holds the last scan of each provider's root.
+	Scans []*ScanStats `json:"scans"`
+	// Kinds summarizes the groups of each kind found.
+	Kinds []*KindSummary `json:"kinds"`
+	// Top holds the groups wasting the most space.
+	Top []*ReportGroup `json:"top"`
+}
+
+// A KindSummary counts the duplicate groups of a kind.
+type KindSummary struct {
+	Kind    string `json:"kind"`
+	Groups  int    `json:"groups"`
+	Members int    `json:"members"`
+	Wasted  int64  `json:"wasted"`
+}
+
+// buildReport summarizes the database, listing the `top` groups wasting the
+// most space.
+func buildReport(d *db.DB, top int) (*Report, error) {
+	r := &Report{Generated: time.Now().UTC()}
+	index := make(map[string]int)
+	err := forEachScan(d, func(s *ScanStats) error {
+		k := s.Provider + "/" + s.Root
+		if i, ok := index[k]; ok {
+			r.Scans[i] = s
+		} else {
+			index[k] = len(r.Scans)
+			r.Scans = append(r.Scans, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]*KindSummary)
+	for _, kind := range []string{exactGroup, crossProviderGroup, nearGroup} {
+		kinds[kind] = &KindSummary{Kind: kind}
+		r.Kinds = append(r.Kinds, kinds[kind])
+	}
+	err = forEachGroup(d, func(g *ReportGroup) error {
+		k := kinds[g.Kind]
+		k.Groups++
+		k.Members += len(g.Members)
+		k.Wasted += g.Wasted
+		if g.Wasted > 0 {
+			r.Top = append(r.Top, g)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(r.Top, func(i, j int) bool { return r.Top[i].Wasted > r.Top[j].Wasted })
+	if len(r.Top) > top {
+		r.Top = r.Top[:top]
+	}
+	return r, nil
+}
+
+// writeText writes the report for people to read.
+func (r *Report) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCANS")
+	fmt.Fprintln(tw, "provider\troot\tfinished\tfiles\tunchanged\tremoved\terrors")
+	for _, s := range r.Scans {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", s.Provider, s.Root,
+			s.Finished.Local().Format(time.RFC3339), s.Files, s.Unchanged, s.Removed, s.Errors)
+	}
+	fmt.Fprintln(tw, "\nDUPLICATES")
+	fmt.Fprintln(tw, "kind\tgroups\tmembers\twasted")
+	for _, k := range r.Kinds {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", k.Kind, k.Groups, k.Members, formatBytes(k.Wasted))
+	}
+	if len(r.Top) > 0 {
+		fmt.Fprintln(tw, "\nTOP GROUPS")
+		fmt.Fprintln(tw, "kind\twasted\tcopies\tmembers")
+		for _, g := range r.Top {
+			paths := make([]string, len(g.Members))
+			for i, m := range g.Members {
+				paths[i] = m.Provider + ":" + m.Path
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", g.Kind, formatBytes(g.Wasted), len(g.Members), strings.Join(paths, " "))
+		}
+	}
+	return tw.Flush()
+}
+
+// formatBytes formats n bytes with a binary unit, eg: 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var reportOptions struct {
+	format string
+	top    int
+}
+
+var reportCommand = &command{
+	name:    "report",
+	summary: "summarize the last scans and the duplicates found",
+	flags: func(fs *flag.FlagSet, c *config.Config) {
+		fs.StringVar(&reportOptions.format, "format", "text", "output `format`: text or json")
+		fs.IntVar(&reportOptions.top, "top", 10, "number of groups wasting the most space to list")
+	},
+	run: noArgs(func(c *config.Config) error {
+		if f := reportOptions.format; f != "text" && f != "json" {
+			return usageError(fmt.Sprintf("unknown report format %q", f))
+		}
+		d, err := db.OpenReadOnly(c.DBFile, dbLockTimeout)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		r, err := buildReport(d, reportOptions.top)
+		if err != nil {
+			return err
+		}
+		if reportOptions.format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(r)
+		}
+		return r.writeText(os.Stdout)
+	}),
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/profiler"
+	"golang.org/x/exp/slog"
+)
+
+var scanProviders []string
+
+var scanCommand = &command{
+	name:    "scan",
+	summary: "scan the configured providers, recording every file",
+	help: `Files unchanged since the previous scan keep their stored hashes and
+metadata, and the records of files deleted since are removed, unless the scan
+is aborted. Scan statistics are kept for the report and export commands.
+
+The database stays locked for the whole scan, so that "wupdedup db backup"
+waits for it to end. Meanwhile, sending the scan SIGUSR1 makes it back up the
+database next to itself, as <db file>.<time>.bak, without pausing.
+`,
+	flags: func(fs *flag.FlagSet, c *config.Config) {
+		providerFlag(fs, &scanProviders)
+		fs.IntVar(&c.Batch.Size, "batch-size", c.Batch.Size, "records committed per transaction")
+		fs.DurationVar(&c.Batch.Interval, "batch-interval", c.Batch.Interval, "longest a record waits to be committed, or 0 to wait for a full batch")
+		fs.StringVar(&c.Local.RootPath, "local-root", c.Local.RootPath, "local directory to scan")
+		fs.IntVar(&c.Local.HashWorkers, "hash-workers", c.Local.HashWorkers, "concurrent hashing workers, or 0 for one per CPU")
+		fs.Var((*listFlag)(&c.Local.HashAlgorithms), "hash-algorithms", "comma-separated digest `algorithms` computed for local files")
+		fs.BoolVar(&c.Local.ImageHashes, "image-hashes", c.Local.ImageHashes, "compute perceptual hashes of images")
+		fs.BoolVar(&c.Local.ExtractMetadata, "extract-metadata", c.Local.ExtractMetadata, "extract EXIF and video metadata")
+	},
+	run: noArgs(func(c *config.Config) error {
+		d, contexts, err := openStore(c, scanProviders)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		defer startProfiler(c)()
+		// An interrupt aborts the scan, keeping what was recorded so far.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		defer backupOnSignal(d, c.DBFile)()
+		session := newScanSession()
+		slog.Info("starting scan session", "session", session)
+		for _, sc := range contexts {
+			sc.SetSession(session)
+			sc.SetBatchOptions(db.BatchOptions{
+				Size:         c.Batch.Size,
+				Interval:     c.Batch.Interval,
+				UseBoltBatch: c.Batch.UseBoltBatch,
+			})
+			if err := recordScan(d, sc.scanTree(ctx)); err != nil {
+				slog.Error("cannot store scan statistics", err, "provider", sc.name)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		return nil
+	}),
+}
+
+// backupOnSignal backs up d, the database at `path`, each time one of the
+// backupSignals is received, from a read transaction that doesn't hold back
+// the scan, until the returned func is called.
+func backupOnSignal(d *db.DB, path string) (stop func()) {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	if len(backupSignals) > 0 {
+		signal.Notify(sig, backupSignals...)
+	}
+	go func() {
+		for {
+			select {
+			case <-sig:
+				backup := fmt.Sprintf("%s.%s.bak", path, time.Now().UTC().Format("20060102T150405Z"))
+				start := time.Now()
+				if err := d.Backup(backup); err != nil {
+					slog.Error("cannot back up database", err, "path", path, "backup", backup)
+				} else {
+					slog.Info("Done backing up database", "path", path, "backup", backup,
+						"elapsed", time.Since(start))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+var dupesProviders []string
+
+var dupesCommand = &command{
+	name:    "dupes",
+	summary: "find exact, cross-provider and near duplicates among scanned files",
+	help: `Replaces the duplicate groups found by the previous run. Files the scan
+didn't hash are hashed on demand, where their provider can read them.
+
+Only the exact duplicates within the sources named by -provider are searched
+for again, while cross-provider and near duplicates are always searched for
+among every source, as they may span sources that weren't named.
+`,
+	flags: func(fs *flag.FlagSet, c *config.Config) {
+		providerFlag(fs, &dupesProviders)
+		fs.StringVar(&c.Dedup.Algorithm, "algorithm", c.Dedup.Algorithm, "digest `algorithm` confirming exact duplicates")
+		fs.IntVar(&c.Dedup.PartialHashKB, "partial-hash-kb", c.Dedup.PartialHashKB, "KiB hashed at each end of same-size files before hashing them fully, 0 to hash them fully at once")
+		fs.StringVar(&c.Dedup.NearHash, "near-hash", c.Dedup.NearHash, "perceptual `hash` compared for near duplicates: ahash, dhash or phash")
+		fs.IntVar(&c.Dedup.NearDistance, "near-distance", c.Dedup.NearDistance, "largest Hamming `distance` between near duplicates")
+	},
+	run: noArgs(func(c *config.Config) error {
+		d, all, err := openStore(c, nil)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		contexts, err := selectContexts(all, dupesProviders)
+		if err != nil {
+			return err
+		}
+		defer startProfiler(c)()
+		if err := findDuplicates(d, contexts, c.Dedup, len(dupesProviders) == 0); err != nil {
+			return fmt.Errorf("finding duplicates: %s", err)
+		}
+		if err := correlateProviders(d, all); err != nil {
+			return fmt.Errorf("correlating providers: %s", err)
+		}
+		if err := findNearDuplicates(d, all, c.Dedup); err != nil {
+			return fmt.Errorf("finding near-duplicates: %s", err)
+		}
+		return nil
+	}),
+}
+
+// openStore opens the database for writing, upgraded to the layout expected
+// for the configured sources, and binds the contexts of the sources named in
+// `providers`, or of all of them, to their record buckets.
+func openStore(c *config.Config, providers []string) (*db.DB, []*StorageStrategyContext, error) {
+	all, err := loadStorageStrategyContexts(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	contexts, err := selectContexts(all, providers)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := db.Open(c.DBFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := d.Migrate(migrations); err != nil {
+		d.Close()
+		return nil, nil, err
+	}
+	for _, context := range contexts {
+		b, err := openRecordBucket(d, context.name, context.storageStrategy.Root())
+		if err != nil {
+			d.Close()
+			return nil, nil, err
+		}
+		context.SetBucket(b)
+	}
+	return d, contexts, nil
+}
+
+// selectContexts returns the contexts of the named sources, or all of them if
+// none is named.
+func selectContexts(contexts []*StorageStrategyContext, providers []string) ([]*StorageStrategyContext, error) {
+	if len(providers) == 0 {
+		return contexts, nil
+	}
+	var selected []*StorageStrategyContext
+	for _, name := range providers {
+		var found bool
+		for _, context := range contexts {
+			if context.name == name {
+				selected = append(selected, context)
+				found = true
+			}
+		}
+		if !found {
+			var names []string
+			for _, context := range contexts {
+				names = append(names, context.name)
+			}
+			return nil, usageError(fmt.Sprintf("source %q isn't configured (configured: %s)",
+				name, strings.Join(names, ", ")))
+		}
+	}
+	return selected, nil
+}
+
+// startProfiler starts profiling if it's configured, and returns the func
+// stopping it.
+func startProfiler(c *config.Config) (stop func()) {
+	if !c.Profile.Specified() {
+		return func() {}
+	}
+	p := profiler.New(c.Profile)
+	p.Start()
+	return p.Stop
+}
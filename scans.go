@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/timblaktu/wupdedup/db"
+)
+
+// Name of the bucket holding the statistics of every scan.
+const scansBucket = "scans"
+
+// ScanStats summarize a single scan of a provider's root.
+type ScanStats struct {
+	Session  string    `json:"session"`
+	Provider string    `json:"provider"`
+	Root     string    `json:"root"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Nodes counts every tree node visited, Files the files among them, and
+	// Unchanged the files whose previous records were reused, and Removed
+	// the records of files deleted since the previous scan.
+	Nodes     int `json:"nodes"`
+	Files     int `json:"files"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+	Errors    int `json:"errors"`
+}
+
+// Key returns the scans bucket key the stats are stored under, which orders
+// them by session.
+func (s *ScanStats) Key() string {
+	return s.Session + "/" + s.Provider + "/" + s.Root
+}
+
+// scans returns the bucket of scan statistics.
+func scans(d *db.DB) (*db.TypedBucket[string, *ScanStats], error) {
+	b, err := d.Bucket([]byte(scansBucket))
+	if err != nil {
+		return nil, err
+	}
+	return db.NewTypedBucket[string, *ScanStats](b, db.StringKeys{}, db.JSONCodec[*ScanStats]{}), nil
+}
+
+// recordScan stores the statistics of a scan alongside those of earlier ones.
+func recordScan(d *db.DB, s *ScanStats) error {
+	b, err := scans(d)
+	if err != nil {
+		return err
+	}
+	return b.Put(s.Key(), s)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/db"
+	"golang.org/x/exp/slog"
+)
+
+var serveOptions struct {
+	addr string
+}
+
+var serveCommand = &command{
+	name:    "serve",
+	summary: "serve the report, duplicate groups and scan statistics over HTTP",
+	help: `Read-only JSON endpoints:
+  GET /api/report?top=N    the report command's report
+  GET /api/groups?kind=K   duplicate groups, of kind exact, cross-provider or near
+  GET /api/scans           statistics of every scan
+  GET /healthz             200 while serving
+
+The database is only opened while answering a request, so scans can run in
+between; requests made while one runs fail with 503.
+`,
+	flags: func(fs *flag.FlagSet, c *config.Config) {
+		fs.StringVar(&serveOptions.addr, "addr", "localhost:8080", "`address` to listen on")
+	},
+	run: noArgs(func(c *config.Config) error {
+		return serve(c, serveOptions.addr)
+	}),
+}
+
+// How long requests wait for a scan holding the database open.
+const serveLockTimeout = time.Second
+
+func serve(c *config.Config, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("/api/report", dbHandler(c, func(d *db.DB, r *http.Request) (any, error) {
+		top := 10
+		if s := r.URL.Query().Get("top"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, badRequest("top must be a non-negative integer")
+			}
+			top = n
+		}
+		return buildReport(d, top)
+	}))
+	mux.Handle("/api/groups", dbHandler(c, func(d *db.DB, r *http.Request) (any, error) {
+		kind := r.URL.Query().Get("kind")
+		switch kind {
+		case "", exactGroup, crossProviderGroup, nearGroup:
+		default:
+			return nil, badRequest("unknown kind " + strconv.Quote(kind))
+		}
+		groups := []*ReportGroup{}
+		err := forEachGroup(d, func(g *ReportGroup) error {
+			if kind == "" || g.Kind == kind {
+				groups = append(groups, g)
+			}
+			return nil
+		})
+		return groups, err
+	}))
+	mux.Handle("/api/scans", dbHandler(c, func(d *db.DB, r *http.Request) (any, error) {
+		scans := []*ScanStats{}
+		err := forEachScan(d, func(s *ScanStats) error {
+			scans = append(scans, s)
+			return nil
+		})
+		return scans, err
+	}))
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("serving", "addr", addr, "path", c.DBFile)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	slog.Info("Done serving", "addr", addr)
+	return nil
+}
+
+// A badRequest is an error caused by the request, answered with 400.
+type badRequest string
+
+func (e badRequest) Error() string {
+	return string(e)
+}
+
+// dbHandler answers GET requests with the JSON encoding of what fn returns
+// from the database, opened read-only for the request.
+func dbHandler(c *config.Config, fn func(d *db.DB, r *http.Request) (any, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		d, err := db.OpenReadOnly(c.DBFile, serveLockTimeout)
+		if err != nil {
+			slog.Warn("cannot open database", "err", err, "path", c.DBFile)
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		v, err := fn(d, r)
+		d.Close()
+		if e, ok := err.(badRequest); ok {
+			http.Error(w, string(e), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			slog.Error("request failed", err, "url", r.URL.String())
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	})
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// Signals asking a running scan to back up the database: none, where there's
+// no SIGUSR1.
+var backupSignals []os.Signal
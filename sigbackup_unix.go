@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals asking a running scan to back up the database.
+var backupSignals = []os.Signal{syscall.SIGUSR1}
@@ -1,16 +1,66 @@
 package main
 
 import (
+	"context"
+	"io/fs"
+
 	"github.com/timblaktu/wupdedup/config"
+	wfs "github.com/timblaktu/wupdedup/fs"
 
 	"golang.org/x/exp/slog"
 )
 
+func init() {
+	registerStorageStrategyType("smugmug", "the images of a SmugMug account",
+		func(conf *config.SmugMugConfig) (StorageStrategy, error) {
+			if err := conf.Valid(); err != nil {
+				return nil, err
+			}
+			return SmugmugStrategy{*conf, wfs.NewSmugMug(wfs.SmugMugCredentials{
+				APIKey:     conf.APIKey,
+				APISecret:  conf.APISecret,
+				UserToken:  conf.UserToken,
+				UserSecret: conf.UserSecret,
+			})}, nil
+		})
+}
+
 // Concrete type that implements StorageStrategy interface for SmugMug
 type SmugmugStrategy struct {
 	conf config.SmugMugConfig
+	// provider caches the account's albums for the life of the strategy.
+	provider *wfs.SmugMug
 }
 
-func (s SmugmugStrategy) scanTree(c *StorageStrategyContext) {
+func (s SmugmugStrategy) Root() string {
+	return s.conf.URL
+}
+
+// ScanTree records the images of the account as listed, without downloading
+// them: SmugMug reports their MD5 digests, and types are told by file names.
+func (s SmugmugStrategy) ScanTree(ctx context.Context, c *StorageStrategyContext) error {
 	slog.Info("scanning Smugmug account", "url", s.conf.URL)
+	if err := c.scanProvider(ctx, s, nil); err != nil {
+		return err
+	}
+	slog.Info("Done scanning Smugmug account", "url", s.conf.URL,
+		"#nodes", c.nodeCount, "#files", c.fileCount, "#unchanged", c.reuseCount,
+		"#errors", c.errorCount.Load())
+	return nil
+}
+
+func (s SmugmugStrategy) Provider() wfs.Provider {
+	return s.provider
+}
+
+// ProviderPath returns path, as images are recorded at their provider's path.
+func (s SmugmugStrategy) ProviderPath(path string) (string, error) {
+	if !fs.ValidPath(path) {
+		return "", &fs.PathError{Op: "rel", Path: path, Err: fs.ErrInvalid}
+	}
+	return path, nil
+}
+
+func (s SmugmugStrategy) RecordPath(path string) string {
+	return path
 }
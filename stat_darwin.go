@@ -0,0 +1,20 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// fileIdentity extracts the device, inode and status-change time of a file
+// from the system-specific data of its FileInfo or fs.Entry, which together
+// with size and mtime tell whether it changed between scans.
+func fileIdentity(sys any) (dev, ino uint64, ctime time.Time) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, time.Time{}
+	}
+	return uint64(st.Dev), st.Ino, time.Unix(st.Ctimespec.Sec, st.Ctimespec.Nsec)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+	"time"
+)
+
+// fileIdentity is unsupported on this platform, so change detection falls
+// back to comparing size and mtime alone.
+func fileIdentity(sys any) (dev, ino uint64, ctime time.Time) {
+	return 0, 0, time.Time{}
+}
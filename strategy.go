@@ -1,16 +1,45 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/timblaktu/wupdedup/config"
+	"github.com/timblaktu/wupdedup/content"
 	"github.com/timblaktu/wupdedup/db"
+	"github.com/timblaktu/wupdedup/fs"
+	"golang.org/x/exp/slog"
 )
 
 // -----------------------------------------------------------------------------
 // Strategy-pattern interface impl by storage providers
 type StorageStrategy interface {
-	scanTree(c *StorageStrategyContext)
+	// ScanTree stores a record of every file of the tree through c, and
+	// returns an error if the tree couldn't be walked entirely, eg: because
+	// ctx was canceled.
+	ScanTree(ctx context.Context, c *StorageStrategyContext) error
+	// Root names the tree the strategy scans within its provider (eg: a local
+	// directory). Each root's records live in their own bucket, nested in the
+	// provider's, so one root can be dropped or rescanned on its own.
+	Root() string
+}
+
+// A ProviderStrategy is a StorageStrategy whose files can be reached through
+// an fs.Provider, which scans list and read them through, the dedup engine
+// hashes them on demand through, and applying a dedup plan checks and deletes
+// them through.
+type ProviderStrategy interface {
+	Provider() fs.Provider
+	// ProviderPath returns the provider's path of the file recorded at path.
+	ProviderPath(path string) (string, error)
+	// RecordPath returns the path the file at the provider's path is recorded
+	// at, the inverse of ProviderPath.
+	RecordPath(path string) string
 }
 
 // -----------------------------------------------------------------------------
@@ -19,8 +48,16 @@ type StorageStrategyContext struct {
 	storageStrategy StorageStrategy
 	name            string
 	bucket          db.Bucket
+	records         *db.TypedBucket[string, *FileRecord]
+	session         string
 	fileCount       int
 	nodeCount       int
+	reuseCount      int
+	// errorCount is updated by hashing workers as well as by the tree walk.
+	errorCount   atomic.Int64
+	batchOptions db.BatchOptions
+	// writer batches the records written while scanning.
+	writer *db.TypedBatchWriter[string, *FileRecord]
 }
 
 func NewStorageStrategyContext(s StorageStrategy, n string) *StorageStrategyContext {
@@ -36,36 +73,276 @@ func (c *StorageStrategyContext) setStorageStrategy(s *StorageStrategy) {
 
 func (c *StorageStrategyContext) SetBucket(b *db.Bucket) {
 	c.bucket = *b
+	c.records = db.NewTypedBucket[string, *FileRecord](b, db.StringKeys{}, fileRecordCodec{})
 }
 
-func (c *StorageStrategyContext) scanTree() {
-	c.storageStrategy.scanTree(c)
+func (c *StorageStrategyContext) SetSession(s string) {
+	c.session = s
+}
+
+func (c *StorageStrategyContext) SetBatchOptions(opts db.BatchOptions) {
+	c.batchOptions = opts
+}
+
+// putRecord persists a FileRecord in the context's bucket. While scanning,
+// records are batched, and only visible in the bucket once the scan is done.
+func (c *StorageStrategyContext) putRecord(r *FileRecord) error {
+	if c.writer != nil {
+		return c.writer.Put(r.Path, r)
+	}
+	return c.records.Put(r.Path, r)
+}
+
+// record returns the FileRecord stored under key k.
+func (c *StorageStrategyContext) record(k []byte) (*FileRecord, error) {
+	r, ok, err := c.records.Get(string(k))
+	if err == nil && !ok {
+		err = fmt.Errorf("no record for %q", k)
+	}
+	return r, err
+}
+
+// previousRecord returns the record stored for path by an earlier scan, or nil
+// if there is none or it can't be decoded.
+func (c *StorageStrategyContext) previousRecord(path string) *FileRecord {
+	r, _, err := c.records.Get(path)
+	if err != nil {
+		slog.Warn("ignoring undecodable previous record", "path", path, "err", err)
+		return nil
+	}
+	return r
+}
+
+// scanTree scans the context's tree, storing a record of every file, and
+// returns the statistics of the scan.
+func (c *StorageStrategyContext) scanTree(ctx context.Context) *ScanStats {
+	stats := &ScanStats{
+		Session:  c.session,
+		Provider: c.name,
+		Root:     c.storageStrategy.Root(),
+		Started:  time.Now().UTC(),
+	}
+	c.writer = c.records.NewBatchWriter(c.batchOptions)
+	err := c.storageStrategy.ScanTree(ctx, c)
+	if err != nil {
+		slog.Error("scan aborted", err, "provider", c.name, "root", stats.Root)
+	}
+	if cerr := c.writer.Close(); cerr != nil {
+		slog.Error("cannot store scanned records", cerr, "provider", c.name)
+		if err == nil {
+			err = cerr
+		}
+	}
+	bs := c.writer.Stats()
+	slog.Info("Done storing scanned records", "provider", c.name, "#records", bs.Writes,
+		"#commits", bs.Commits, "mean", bs.Mean(), "max", bs.Max)
+	c.writer = nil
+	// Only a complete scan tells which files are gone: an aborted one keeps
+	// the records of the files it didn't reach.
+	if err == nil {
+		if stats.Removed, err = c.pruneRecords(); err != nil {
+			slog.Error("cannot remove records of deleted files", err, "provider", c.name)
+		}
+	}
+	stats.Finished = time.Now().UTC()
+	stats.Nodes = c.nodeCount
+	stats.Files = c.fileCount
+	stats.Unchanged = c.reuseCount
+	stats.Errors = int(c.errorCount.Load())
+	return stats
+}
+
+// scanProvider records every entry of the tree of ps's provider, handing the
+// regular files whose contents must be read off to pool, if there's one.
+// Entries that can't be listed are recorded with their error and skipped.
+func (c *StorageStrategyContext) scanProvider(ctx context.Context, ps ProviderStrategy, pool *hashPool) error {
+	visit := func(e *fs.Entry, err error) error {
+		path := ps.RecordPath(e.Path)
+		if err != nil {
+			slog.Warn("cannot visit", "path", path, "err", err)
+			c.errorCount.Add(1)
+			if err := c.putRecord(NewErrorRecord(c, path, err)); err != nil {
+				slog.Error("cannot store file record", err, "path", path)
+			}
+			return nil
+		}
+		return c.visit(pool, path, e)
+	}
+	p := ps.Provider()
+	if p.Capabilities().RecursiveList {
+		return p.List(ctx, ".", true, visit)
+	}
+	return listTree(ctx, p, ".", visit)
+}
+
+// listTree lists the tree of directory dir one directory at a time, for
+// providers that can't list it at once.
+func listTree(ctx context.Context, p fs.Provider, dir string, fn func(e *fs.Entry, err error) error) error {
+	return p.List(ctx, dir, false, func(e *fs.Entry, err error) error {
+		if err := fn(e, err); err != nil {
+			return err
+		}
+		if err == nil && e.IsDir() {
+			return listTree(ctx, p, e.Path, fn)
+		}
+		return nil
+	})
+}
+
+// visit records a listed entry, recorded at path, handing regular files whose
+// contents must be read off to pool, if there's one.
+func (c *StorageStrategyContext) visit(pool *hashPool, path string, e *fs.Entry) error {
+	slog.Debug("visiting", "path", path, "size", e.Size, "mode", e.Mode.String(),
+		"modtime", e.ModTime, "sys", e.Sys)
+	c.nodeCount++
+	if e.IsDir() {
+		slog.Debug("ignoring bc isdir")
+		return nil
+	}
+	c.fileCount++
+	r := NewFileRecord(c, path, e)
+	if prev := c.previousRecord(path); prev != nil && r.Unchanged(prev) {
+		r.Reuse(prev)
+		c.reuseCount++
+	}
+	if !e.Mode.IsRegular() {
+		// Symlinks, devices, pipes etc. are recorded but never opened.
+		r.Category = content.Other
+	} else if pool == nil && r.Category == content.Unknown {
+		// Contents aren't read, so the type is told by the name alone.
+		r.MimeType = content.NameType(path)
+		r.Category = content.CategoryOf(r.MimeType)
+	}
+	if pool != nil && pool.Needs(r) {
+		pool.Submit(r)
+	} else if err := c.putRecord(r); err != nil {
+		slog.Error("cannot store file record", err, "path", path)
+		c.errorCount.Add(1)
+		return err
+	}
+	slog.Debug("visited", "path", path, "#nodes", c.nodeCount, "#files", c.fileCount)
+	return nil
+}
+
+// pruneRecords deletes the records of files the current scan didn't find,
+// left by earlier scans, and returns how many it deleted. Records are deleted
+// through the indexed bucket so that their index entries go with them.
+func (c *StorageStrategyContext) pruneRecords() (int, error) {
+	var stale []string
+	err := c.records.Map(func(path string, r *FileRecord) error {
+		if r.Session != c.session {
+			stale = append(stale, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, path := range stale {
+		slog.Debug("removing record of deleted file", "path", path)
+		if err := c.records.Delete(path); err != nil {
+			return i, err
+		}
+	}
+	slog.Info("Done removing records of deleted files", "provider", c.name, "#removed", len(stale))
+	return len(stale), nil
 }
 
 // -----------------------------------------------------------------------------
 // Utility function to load concrete StorageStrategy instances from config spec.
 // The slice returned is a singleton.
-func loadStorageStrategyContexts(c *config.Config) []*StorageStrategyContext {
+func loadStorageStrategyContexts(c *config.Config) ([]*StorageStrategyContext, error) {
 	var contexts []*StorageStrategyContext
-	if c.Local.Specified() && c.Local.Valid() == true {
-		contexts = append(contexts,
-			[]*StorageStrategyContext{
-				NewStorageStrategyContext(LocalStrategy{c.Local}, "local"),
-			}...)
-	}
-	if c.Smugmug.Specified() && c.Smugmug.Valid() == true {
-		contexts = append(contexts,
-			[]*StorageStrategyContext{
-				NewStorageStrategyContext(SmugmugStrategy{c.Smugmug}, "smugmug"),
-			}...)
+	names := make(map[string]bool)
+	for _, s := range c.AllSources() {
+		if names[s.Name] {
+			return nil, fmt.Errorf("source %s configured twice", s.Name)
+		}
+		if nonRecordBuckets[s.Name] || db.IsMetaBucket([]byte(s.Name)) {
+			return nil, fmt.Errorf("source name %s is reserved", s.Name)
+		}
+		names[s.Name] = true
+		strategy, err := newStorageStrategy(s)
+		if err != nil {
+			return nil, err
+		}
+		contexts = append(contexts, NewStorageStrategyContext(strategy, s.Name))
 	}
 	if len(contexts) == 0 {
-		log.Fatalf("No Storage Strategies specified in config")
-	} else {
-		log.Printf("Loaded %d Storage Strategy Contexts:\n", len(contexts))
-		for i, context := range contexts {
-			log.Printf("  %d: %+v\n", i, *context)
+		return nil, errors.New("no sources configured")
+	}
+	for _, context := range contexts {
+		slog.Debug("loaded source", "name", context.name, "root", context.storageStrategy.Root())
+	}
+	return contexts, nil
+}
+
+// -----------------------------------------------------------------------------
+// Registry of the types of StorageStrategy, which their implementations fill
+// from init funcs, so that sources of any registered type can be configured.
+
+// A StorageStrategyType is a type of storage provider sources can have.
+type StorageStrategyType struct {
+	Name    string
+	Summary string
+	// newConfig returns a pointer to a zero config struct of the type, whose
+	// fields are the settings of its sources.
+	newConfig func() any
+	// new returns the strategy of a source with the settings in conf, loaded
+	// into a struct returned by newConfig.
+	new func(conf any) (StorageStrategy, error)
+}
+
+// Settings documents the settings of the type's sources.
+func (t *StorageStrategyType) Settings() []config.Setting {
+	return config.Settings(t.newConfig())
+}
+
+var storageStrategyTypes = make(map[string]*StorageStrategyType)
+
+// registerStorageStrategyType registers type `name` of storage provider,
+// whose sources have the settings of config struct C, and whose strategies
+// newStrategy returns.
+func registerStorageStrategyType[C any](name, summary string, newStrategy func(conf *C) (StorageStrategy, error)) {
+	if _, ok := storageStrategyTypes[name]; ok {
+		panic("storage strategy type registered twice: " + name)
+	}
+	storageStrategyTypes[name] = &StorageStrategyType{
+		Name:      name,
+		Summary:   summary,
+		newConfig: func() any { return new(C) },
+		new:       func(conf any) (StorageStrategy, error) { return newStrategy(conf.(*C)) },
+	}
+}
+
+// sortedStorageStrategyTypes returns the registered types, sorted by name.
+func sortedStorageStrategyTypes() []*StorageStrategyType {
+	types := make([]*StorageStrategyType, 0, len(storageStrategyTypes))
+	for _, t := range storageStrategyTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// newStorageStrategy returns the strategy of a source, of its type, with its
+// settings resolved.
+func newStorageStrategy(s *config.SourceConfig) (StorageStrategy, error) {
+	t, ok := storageStrategyTypes[s.Type]
+	if !ok {
+		var names []string
+		for _, t := range sortedStorageStrategyTypes() {
+			names = append(names, t.Name)
 		}
+		return nil, fmt.Errorf("source %s: unknown type %q (types: %s)", s.Name, s.Type, strings.Join(names, ", "))
+	}
+	conf := t.newConfig()
+	if err := s.Load(conf); err != nil {
+		return nil, err
+	}
+	strategy, err := t.new(conf)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %s", s.Name, err)
 	}
-	return contexts
+	return strategy, nil
 }